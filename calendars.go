@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/calendars"
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/google"
+	"google.golang.org/api/calendar/v3"
+)
+
+const calendarsUsage = `Usage: taska calendars [list] [--json]
+       taska calendars use <name|id>
+       taska calendars events [--taska-only] [--json] [name|id]
+       taska calendars create [--time-zone tz] [--description text] <name>`
+
+// runCalendars implements `taska calendars [list|use|events|create]`.
+func runCalendars(args []string, calendarName string, cfg *config.Config) error {
+	if len(args) == 0 {
+		return listCalendars(nil, calendarName, cfg)
+	}
+	switch args[0] {
+	case "list":
+		return listCalendars(args[1:], calendarName, cfg)
+	case "use":
+		if len(args) != 2 {
+			return fmt.Errorf("usage: taska calendars use <name|id>")
+		}
+		return useCalendar(args[1], cfg)
+	case "events":
+		return listCalendarEvents(args[1:], calendarName, cfg)
+	case "create":
+		return createCalendar(args[1:], cfg)
+	default:
+		return fmt.Errorf("unknown calendars command '%s'\n%s", args[0], calendarsUsage)
+	}
+}
+
+// listCalendars implements `taska calendars list [--json]`. The calendar
+// taska syncs to is marked with a '*'.
+func listCalendars(args []string, calendarName string, cfg *config.Config) error {
+	fs := flag.NewFlagSet("calendars", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), calendarsUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	srv, err := google.NewService(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	list, err := google.ListCalendars(srv)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(list)
+	}
+	current, _ := google.ResolveCalendar(srv, calendarName)
+	return calendars.PrintList(os.Stdout, list, current)
+}
+
+// useCalendar implements `taska calendars use <name|id>` and --set-calendar:
+// it checks that the calendar exists and is writable before saving it as the
+// profile's calendar.
+func useCalendar(name string, cfg *config.Config) error {
+	srv, err := google.NewService(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	entry, err := google.FindCalendar(srv, name)
+	if err != nil {
+		return fmt.Errorf("%w (see `taska calendars list`)", err)
+	}
+	if err := calendars.Writable(entry); err != nil {
+		return err
+	}
+
+	// Keep the rest of the config (duration rules etc.) intact
+	saved, err := config.Load()
+	if err != nil {
+		return fmt.Errorf("error loading config: %w", err)
+	}
+	saved.Calendar = name
+	if err := config.Save(saved); err != nil {
+		return fmt.Errorf("error saving config: %w", err)
+	}
+	fmt.Printf("Default calendar set to: %s (%s)\n", name, entry.Id)
+	return nil
+}
+
+// listCalendarEvents implements `taska calendars events [--taska-only]
+// [--json] [name|id]`. Without --taska-only it shows the events from today
+// on; with it, every event taska manages.
+func listCalendarEvents(args []string, calendarName string, cfg *config.Config) error {
+	fs := flag.NewFlagSet("calendars events", flag.ExitOnError)
+	taskaOnly := fs.Bool("taska-only", false, "Only show the events taska manages")
+	asJSON := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), calendarsUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 1 {
+		fs.Usage()
+		return fmt.Errorf("expected at most one calendar")
+	}
+	if fs.NArg() == 1 {
+		calendarName = fs.Arg(0)
+	}
+
+	gClient, err := google.NewClient(calendarName, nil, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open calendar '%s': %w", calendarName, err)
+	}
+	var events []*calendar.Event
+	if *taskaOnly {
+		events, err = gClient.ListTaskEvents()
+	} else {
+		now := time.Now()
+		events, err = gClient.ListEvents(time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local))
+	}
+	if err != nil {
+		return err
+	}
+	calendars.SortByStart(events)
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(events)
+	}
+	return calendars.PrintEvents(os.Stdout, events)
+}
+
+// createCalendar implements `taska calendars create [--time-zone tz]
+// [--description text] <name>`. The defaults come from new_calendar in the
+// config.
+func createCalendar(args []string, cfg *config.Config) error {
+	settings := cfg.NewCalendarSettings()
+	fs := flag.NewFlagSet("calendars create", flag.ExitOnError)
+	fs.StringVar(&settings.TimeZone, "time-zone", settings.TimeZone, "IANA time zone of the calendar (default: the account's)")
+	fs.StringVar(&settings.Description, "description", settings.Description, "Description of the calendar")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), calendarsUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return fmt.Errorf("expected one calendar name")
+	}
+	name := fs.Arg(0)
+
+	srv, err := google.NewService(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	existing, err := google.FindCalendar(srv, name)
+	if err == nil {
+		return fmt.Errorf("calendar '%s' already exists (%s)", name, existing.Id)
+	}
+	if !errors.Is(err, google.ErrCalendarNotFound) {
+		return err
+	}
+
+	created, err := google.CreateCalendar(srv, name, settings)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Created calendar '%s' (%s)\n", created.Summary, created.Id)
+	return nil
+}
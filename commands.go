@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+)
+
+// isCommand reports whether a positional argument names a subcommand.
+// Taskwarrior passes hooks "key:value" arguments only, so a bare word is a command.
+func isCommand(arg string) bool {
+	return arg != "" && !strings.Contains(arg, ":")
+}
+
+// runCommand dispatches `taska <command> [args]`.
+func runCommand(name string, args []string, calendarName string, cfg *config.Config) error {
+	switch name {
+	case "status":
+		return runStatus(args, calendarName, cfg)
+	case "doctor":
+		return runDoctor(args, calendarName, cfg)
+	case "conflicts":
+		return runConflicts(args, cfg)
+	case "purge":
+		return runPurge(args, calendarName, cfg)
+	case "migrate":
+		return runMigrate(args, calendarName, cfg)
+	case "calendars":
+		return runCalendars(args, calendarName, cfg)
+	case "sync":
+		return runSync(args, calendarName, cfg)
+	case "tick":
+		return runTick(args, calendarName, cfg)
+	case "daemon":
+		return runDaemon(args, calendarName, cfg)
+	case "systemd":
+		return runSystemd(args)
+	default:
+		return fmt.Errorf("unknown command '%s'", name)
+	}
+}
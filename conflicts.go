@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/conflicts"
+	"github.com/harrisonrobin/taska/pkg/google"
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+)
+
+// runConflicts implements `taska conflicts [list|resolve|clear]`.
+func runConflicts(args []string, cfg *config.Config) error {
+	if len(args) == 0 {
+		return listConflicts(nil)
+	}
+	switch args[0] {
+	case "list":
+		return listConflicts(args[1:])
+	case "resolve":
+		return resolveConflicts(args[1:], cfg)
+	case "clear":
+		n, err := conflicts.ClearResolved()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("Cleared %d resolved conflict(s)\n", n)
+		return nil
+	default:
+		return listConflicts(args)
+	}
+}
+
+// listConflicts implements `taska conflicts list [--all] [--json]`.
+func listConflicts(args []string) error {
+	fs := flag.NewFlagSet("conflicts", flag.ExitOnError)
+	all := fs.Bool("all", false, "Include conflicts a policy already resolved")
+	asJSON := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: taska conflicts [list] [--all] [--json]\n       taska conflicts resolve --keep task|calendar <uuid>...\n       taska conflicts clear")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	list, err := conflicts.List()
+	if err != nil {
+		return err
+	}
+	shown := []conflicts.Conflict{}
+	for _, c := range list {
+		if *all || c.Pending() {
+			shown = append(shown, c)
+		}
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(shown)
+	}
+	printConflicts(os.Stdout, shown)
+	return nil
+}
+
+func printConflicts(out io.Writer, list []conflicts.Conflict) {
+	if len(list) == 0 {
+		fmt.Fprintln(out, "No conflicts.")
+		return
+	}
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "KEPT\tUUID\tROLE\tDETECTED\tTASK\tCALENDAR")
+	for _, c := range list {
+		kept := c.Resolution
+		if c.Pending() {
+			kept = "pending"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", kept, c.TaskUUID, index.RoleName(c.Role),
+			c.DetectedAt.Local().Format(time.DateTime), describeVersion(c.Task), describeVersion(c.Calendar))
+	}
+	w.Flush()
+}
+
+func describeVersion(v conflicts.Version) string {
+	if v.Start == "" {
+		return fmt.Sprintf("%q", v.Summary)
+	}
+	if start, err := time.Parse(time.RFC3339, v.Start); err == nil {
+		return fmt.Sprintf("%q at %s", v.Summary, start.Local().Format("2006-01-02 15:04"))
+	}
+	return fmt.Sprintf("%q on %s", v.Summary, v.Start)
+}
+
+// resolveConflicts implements `taska conflicts resolve --keep task|calendar <uuid>...`.
+func resolveConflicts(args []string, cfg *config.Config) (err error) {
+	fs := flag.NewFlagSet("conflicts resolve", flag.ExitOnError)
+	keep := fs.String("keep", "", "The version to keep: task or calendar")
+	fs.Parse(args)
+	if *keep != conflicts.KeptTask && *keep != conflicts.KeptCalendar {
+		return fmt.Errorf("--keep must be task or calendar")
+	}
+	if fs.NArg() == 0 {
+		return fmt.Errorf("no task UUID given")
+	}
+
+	list, err := conflicts.List()
+	if err != nil {
+		return err
+	}
+	evtIndex, err := index.NewEventIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load event index: %w", err)
+	}
+	// Keep what the conflicts resolved before a failing one did
+	defer func() {
+		if saveErr := evtIndex.Save(); err == nil && saveErr != nil {
+			err = fmt.Errorf("failed to save event index: %w", saveErr)
+		}
+	}()
+	twClient := taskwarrior.NewClient()
+	clients := make(map[string]*google.CalendarClient)
+
+	for _, uuid := range fs.Args() {
+		found := false
+		for _, c := range list {
+			if c.TaskUUID != uuid {
+				continue
+			}
+			found = true
+			tasks, err := twClient.GetTasks([]string{uuid})
+			if err != nil {
+				return err
+			}
+			if len(tasks) == 0 {
+				return fmt.Errorf("task %s not found", uuid)
+			}
+			gClient, ok := clients[c.CalendarID]
+			if !ok {
+				gClient, err = google.NewClient(c.CalendarID, evtIndex, cfg)
+				if err != nil {
+					return fmt.Errorf("failed to open calendar '%s': %w", c.CalendarID, err)
+				}
+				clients[c.CalendarID] = gClient
+			}
+			if err := gClient.ResolveConflict(tasks[0], c, *keep); err != nil {
+				return fmt.Errorf("could not resolve the %s event of %s: %w", index.RoleName(c.Role), uuid, err)
+			}
+			fmt.Printf("Kept the %s version of the %s event of %s\n", *keep, index.RoleName(c.Role), uuid)
+		}
+		if !found {
+			return fmt.Errorf("no conflict recorded for %s", uuid)
+		}
+	}
+	return nil
+}
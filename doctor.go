@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/doctor"
+)
+
+var doctorMarks = map[string]string{
+	doctor.OK:   "✓",
+	doctor.Warn: "!",
+	doctor.Fail: "✗",
+	doctor.Skip: "-",
+}
+
+// runDoctor implements `taska doctor [--json]`.
+func runDoctor(args []string, calendarName string, cfg *config.Config) error {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Output JSON")
+	fs.Parse(args)
+
+	results := doctor.Run(context.Background(), doctor.Options{Calendar: calendarName, Config: cfg})
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(results); err != nil {
+			return err
+		}
+	} else {
+		for _, r := range results {
+			fmt.Printf("%s %-12s %s\n", doctorMarks[r.Status], r.Name, r.Detail)
+			if r.Fix != "" {
+				fmt.Printf("  fix: %s\n", r.Fix)
+			}
+		}
+	}
+
+	if doctor.Failed(results) {
+		return fmt.Errorf("some checks failed")
+	}
+	return nil
+}
@@ -3,7 +3,12 @@ module github.com/harrisonrobin/taska
 go 1.23.10
 
 require (
+	github.com/godbus/dbus/v5 v5.1.0
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/crypto v0.39.0
 	golang.org/x/oauth2 v0.30.0
+	golang.org/x/term v0.32.0
+	golang.org/x/time v0.12.0
 	google.golang.org/api v0.239.0
 )
 
@@ -23,7 +28,6 @@ require (
 	go.opentelemetry.io/otel v1.36.0 // indirect
 	go.opentelemetry.io/otel/metric v1.36.0 // indirect
 	go.opentelemetry.io/otel/trace v1.36.0 // indirect
-	golang.org/x/crypto v0.39.0 // indirect
 	golang.org/x/net v0.41.0 // indirect
 	golang.org/x/sys v0.33.0 // indirect
 	golang.org/x/text v0.26.0 // indirect
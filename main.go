@@ -6,80 +6,142 @@ import (
 	"flag"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/harrisonrobin/taska/pkg/auth"
+	"github.com/harrisonrobin/taska/pkg/colors"
 	"github.com/harrisonrobin/taska/pkg/config"
 	"github.com/harrisonrobin/taska/pkg/google"
 	"github.com/harrisonrobin/taska/pkg/index"
-	"github.com/harrisonrobin/taska/pkg/overdue"
+	"github.com/harrisonrobin/taska/pkg/logging"
+	"github.com/harrisonrobin/taska/pkg/paths"
+	"github.com/harrisonrobin/taska/pkg/scheduler"
 	"github.com/harrisonrobin/taska/pkg/taskwarrior"
-	"google.golang.org/api/calendar/v3"
 )
 
 func main() {
 	// 1. Parse Flags
 	calendarName := flag.String("calendar", "", "Google Calendar name to sync with (overrides config)")
-	setCalendar := flag.String("set-calendar", "", "Set the default Google Calendar name")
+	setCalendar := flag.String("set-calendar", "", "Check a Google Calendar (name or ID) and make it the default")
 	doAuth := flag.Bool("auth", false, "Authenticate with Google Calendar")
+	headless := flag.Bool("headless", false, "With --auth: authorize without a local browser (paste flow unless --auth-method is set)")
+	authMethod := flag.String("auth-method", "", "With --auth: browser, device or paste")
+	profile := flag.String("profile", "", "Use a named profile (its own credentials, token, calendar and state)")
 	background := flag.Bool("background", false, "Internal use: run in background mode")
+	unlink := flag.Bool("unlink", false, "Internal use: remove the task's events, it is now routed elsewhere")
+	batch := flag.Bool("batch", false, "Internal use: sync many tasks at once (on-exit hook)")
+	verbose := flag.Bool("verbose", false, "Mirror the log to stderr (foreground only)")
 	flag.Parse()
 
+	if err := paths.SetProfile(*profile); err != nil {
+		log.Fatalf("%v", err)
+	}
+
 	// 2. Handle Set Calendar
 	if *setCalendar != "" {
-		cfg := &config.Config{Calendar: *setCalendar}
-		if err := config.Save(cfg); err != nil {
-			log.Fatalf("Error saving config: %v", err)
+		cfg, err := config.Load()
+		if err != nil {
+			log.Fatalf("Error loading config: %v", err)
+		}
+		if err := useCalendar(*setCalendar, cfg); err != nil {
+			log.Fatalf("Could not set the calendar: %v", err)
 		}
-		fmt.Printf("Default calendar set to: %s\n", *setCalendar)
 		return
 	}
 
 	// 3. Determine Calendar (Priority: Flag > Config > Default)
 	selectedCalendar := "Tasks" // Default fallback
 	cfg, err := config.Load()
-	if err == nil && cfg.Calendar != "" {
+	if err != nil {
+		log.Printf("Warning: failed to load config, using defaults: %v", err)
+		cfg = nil
+	} else if cfg.Calendar != "" {
 		selectedCalendar = cfg.Calendar
 	}
 	if *calendarName != "" {
 		selectedCalendar = *calendarName
 	}
 
+	command := !*background && isCommand(flag.Arg(0))
+
+	// Logging: everything goes to the rotating log file, since the background
+	// process has no stderr. Interactive runs mirror it to stderr, and
+	// foreground failures reach the terminal either way.
+	logCfg := config.LogConfig{}
+	if cfg != nil {
+		logCfg = cfg.Log
+	}
+	logCloser, err := logging.Setup(logging.Options{
+		Level:      logCfg.Level,
+		Format:     logCfg.Format,
+		Path:       logCfg.Path,
+		MaxSize:    int64(logCfg.MaxSizeMB) * 1024 * 1024,
+		MaxBackups: logCfg.MaxBackups,
+		Stderr:     !*background && (*verbose || *doAuth || command),
+		Foreground: !*background,
+	})
+	if err != nil {
+		log.Printf("Warning: failed to set up logging: %v", err)
+	}
+	defer logCloser.Close()
+
+	if command {
+		if err := runCommand(flag.Arg(0), flag.Args()[1:], selectedCalendar, cfg); err != nil {
+			fmt.Fprintf(os.Stderr, "taska %s: %v\n", flag.Arg(0), err)
+			logCloser.Close()
+			os.Exit(1)
+		}
+		return
+	}
+
 	// 4. Handle Authentication
 	if *doAuth {
+		if cfg.UsesServiceAccount() {
+			fmt.Println("A service account is configured; it needs no authorization. Run `taska doctor` to check it.")
+			return
+		}
 		ctx := context.Background()
-		xdgConfigBase, err := auth.GetXdgHome()
+		store, err := auth.NewTokenStore(cfg)
 		if err != nil {
-			log.Fatalf("could not find path to configuration file: error %v", err)
+			log.Fatalf("could not open token store: %v", err)
 		}
-
-		tokenFile := filepath.Join(xdgConfigBase, auth.TokenFile)
-		_, err = os.Stat(tokenFile)
-		if err != nil {
-			if !os.IsNotExist(err) {
-				log.Printf("could not check token file '%s', error %v", tokenFile, err)
-			}
-		} else {
-			log.Printf("Removing existing token file at '%s'\n", tokenFile)
-			if err = os.Remove(tokenFile); err != nil {
-				log.Fatalf("could not delete token file '%s', error %v. Please delete it manually", tokenFile, err)
-			}
+		log.Printf("Removing existing token from %s\n", store)
+		if err := store.Delete(); err != nil {
+			log.Fatalf("could not delete token from %s, error %v. Please delete it manually", store, err)
 		}
 
-		_, err = auth.GetCalendarService(ctx)
-		if err != nil {
+		method := *authMethod
+		if method == "" && *headless {
+			method = auth.MethodPaste
+		}
+		if err := auth.Authenticate(ctx, cfg, method, os.Stdin, os.Stdout); err != nil {
 			log.Fatalf("Authentication failed: %v", err)
 		}
-		log.Printf("Authentication successful! Token saved to %s", auth.TokenFile)
+		log.Printf("Authentication successful! Token saved to %s", store)
 		return
 	}
 
 	// 5. Handle Foreground vs Background Mode
 	client := taskwarrior.NewClient()
 
+	if !*background && isOnExitHook() {
+		// FOREGROUND (on-exit): every task the command added or changed
+		// arrives at once. Nothing is echoed, on-exit output is only feedback.
+		twTasks, err := client.ParseTasks(os.Stdin)
+		if err != nil {
+			log.Fatalf("Error parsing tasks from stdin: %v", err)
+		}
+		if err := spawnBatches(cfg, twTasks, selectedCalendar, *calendarName != ""); err != nil {
+			log.Fatalf("could not start background process: %v", err)
+		}
+		return
+	}
+
 	if !*background {
 		// FOREGROUND: Read tasks, print to stdout, spawn background, exit.
 		twTasks, err := client.ParseTasks(os.Stdin)
@@ -99,27 +161,20 @@ func main() {
 			return
 		}
 
-		// Spawn background process
-		self, err := os.Executable()
-		if err != nil {
-			log.Fatalf("could not find self: %v", err)
-		}
-		args := []string{"--background", "--calendar", selectedCalendar}
-		cmd := exec.Command(self, args...)
-		cmd.Stdout = nil // Silence in background
-		cmd.Stderr = nil // Silence in background
-
-		// Encode tasks to pass via pipe
-		stdin, err := cmd.StdinPipe()
-		if err != nil {
-			log.Fatalf("could not open stdin pipe: %v", err)
-		}
-		if err := cmd.Start(); err != nil {
+		// Spawn the background sync for the profile and calendar the task is
+		// routed to. A task whose route changed also leaves its old calendar.
+		explicit := *calendarName != ""
+		target := resolveTarget(cfg, &twTasks[len(twTasks)-1], selectedCalendar, explicit)
+		if err := spawnBackground(target, twTasks); err != nil {
 			log.Fatalf("could not start background process: %v", err)
 		}
-
-		json.NewEncoder(stdin).Encode(twTasks)
-		stdin.Close()
+		if len(twTasks) >= 2 {
+			if previous := resolveTarget(cfg, &twTasks[0], selectedCalendar, explicit); previous != target {
+				if err := spawnBackground(previous, twTasks[:1], "--unlink"); err != nil {
+					log.Printf("could not remove the task from %s: %v", previous, err)
+				}
+			}
+		}
 
 		// Detach and exit
 		return
@@ -128,42 +183,42 @@ func main() {
 	// BACKGROUND: Performance heavy lifting
 	twTasks, err := client.ParseTasks(os.Stdin)
 	if err != nil {
-		log.Fatalf("Background: error parsing tasks: %v", err)
+		slog.Error("background: error parsing tasks", "error", err)
+		return
 	}
 
-	sweepTable, err := overdue.NewTable()
+	// Colours assigned while rendering are saved once, at the end of the run
+	defer saveColors()
+
+	sched, err := scheduler.New()
 	if err != nil {
-		log.Printf("Warning: failed to initialize overdue sweep table: %v", err)
+		slog.Warn("failed to load the schedule", "error", err)
 	}
 
 	evtIndex, err := index.NewEventIndex()
 	if err != nil {
-		log.Printf("Warning: failed to initialize event index: %v", err)
+		slog.Warn("failed to initialize event index", "error", err)
 	}
 
-	gClient, err := google.NewClient(selectedCalendar, evtIndex)
+	gClient, err := google.NewClient(selectedCalendar, evtIndex, cfg)
 	if err != nil {
-		log.Printf("Error creating Google Calendar client: %v", err)
+		slog.Error("error creating Google Calendar client", "calendar", selectedCalendar, "error", err)
 		return
 	}
 
-	// Run Overdue Sweep
-	if sweepTable != nil {
-		overdueEntries := sweepTable.Sweep(time.Now())
-		for _, e := range overdueEntries {
-			patch := &calendar.Event{
-				Summary: "! " + e.Summary,
-			}
-			if _, err := gClient.PatchEvent(e.GCalID, patch); err != nil {
-				log.Printf("Sweep: error patching event %s: %v", e.GCalID, err)
-			}
-		}
-		// Save table after sweep
-		if err := sweepTable.Save(); err != nil {
-			log.Printf("Warning: failed to save sweep table: %v", err)
+	// Refresh the events whose scheduled or due date passed
+	if sched != nil {
+		clientFor := scheduleClients(selectedCalendar, gClient, evtIndex, cfg)
+		if _, err := runSchedule(context.Background(), sched, clientFor, evtIndex); err != nil {
+			slog.Warn("scheduler run failed", logging.Op("tick"), "error", err)
 		}
 	}
 
+	if *batch {
+		syncBatch(gClient, twTasks, evtIndex, sched)
+		return
+	}
+
 	// Process Hook Tasks
 	if len(twTasks) == 0 {
 		return
@@ -178,17 +233,8 @@ func main() {
 		newT := &twTasks[1]
 		taskToSync = newT
 
-		isBlockedOrWaiting := false
-		if newT.Status == "waiting" {
-			isBlockedOrWaiting = true
-		}
-		for _, tag := range newT.Tags {
-			if tag == "BLOCKED" {
-				isBlockedOrWaiting = true
-				break
-			}
-		}
-		if isBlockedOrWaiting || newT.Status == "deleted" {
+		// Blocked, waiting and deleted tasks leave the calendar
+		if !newT.WantsEvent() {
 			action = "delete"
 		}
 	}
@@ -196,32 +242,152 @@ func main() {
 	if taskToSync == nil {
 		return
 	}
+	if *unlink {
+		action = "delete"
+	}
 
 	if action == "delete" {
-		event, err := gClient.GetEventByTaskID(taskToSync.UUID)
-		if err == nil && event != nil {
-			if err := gClient.DeleteEvent(event.Id); err != nil {
-				log.Printf("Error deleting event: %v", err)
-			}
+		// Removes the work block and the deadline marker together
+		if err := gClient.DeleteTaskEvents(taskToSync.UUID); err != nil {
+			slog.Error("error deleting events", logging.Op("delete"), logging.Task(taskToSync.UUID), "error", err)
 		}
-		if sweepTable != nil {
-			sweepTable.Remove(taskToSync.UUID)
-			sweepTable.Save()
+		// An unlinked task may be tracked for its new calendar already
+		if sched != nil && (!*unlink || sched.Entries[taskToSync.UUID].CalendarID == gClient.CalendarID()) {
+			sched.Remove(taskToSync.UUID)
+			sched.Save()
 		}
 		if evtIndex != nil {
-			evtIndex.Remove(taskToSync.UUID)
+			evtIndex.RemoveTask(taskToSync.UUID)
 			evtIndex.Save()
 		}
 	} else {
-		event, err := gClient.SyncEvent(*taskToSync)
-		if err != nil {
-			log.Printf("Error syncing event: %v\n", err)
-		} else if sweepTable != nil && taskToSync.Status == "pending" && taskToSync.Scheduled != nil {
-			sweepTable.Update(taskToSync.UUID, event.Id, taskToSync.Description, taskToSync.Scheduled.Time)
-			sweepTable.Save()
+		if _, err := gClient.SyncEvent(*taskToSync); err != nil {
+			slog.Error("error syncing event", logging.Op("sync"), logging.Task(taskToSync.UUID), "error", err)
+		} else if sched != nil {
+			sched.Track(*taskToSync, gClient.CalendarID(), time.Now())
+			sched.Save()
+		}
+		if _, err := gClient.SyncDeadlineEvent(*taskToSync); err != nil {
+			slog.Error("error syncing deadline marker", logging.Op("sync"), logging.Task(taskToSync.UUID), "error", err)
 		}
 		if evtIndex != nil {
 			evtIndex.Save() // Save new mappings
 		}
 	}
 }
+
+// syncBatch syncs the tasks of an on-exit run together, keeping the index
+// and the schedule in step as the per-task path does.
+func syncBatch(gClient *google.CalendarClient, tasks []taskwarrior.Task, evtIndex *index.EventIndex, sched *scheduler.Scheduler) {
+	errs := gClient.SyncTasks(context.Background(), tasks)
+	for _, task := range tasks {
+		if err := errs[task.UUID]; err != nil {
+			slog.Error("error syncing task", logging.Op("batch"), logging.Task(task.UUID), "error", err)
+			continue
+		}
+		if sched != nil {
+			sched.Track(task, gClient.CalendarID(), time.Now())
+		}
+	}
+	if sched != nil {
+		sched.Save()
+	}
+	if evtIndex != nil {
+		evtIndex.Save()
+	}
+}
+
+// saveColors saves the colour cache the run's events were rendered with.
+func saveColors() {
+	if err := colors.SaveShared(); err != nil {
+		slog.Warn("failed to save colour cache", "error", err)
+	}
+}
+
+// isOnExitHook reports whether taska runs as Taskwarrior's on-exit hook,
+// i.e. it is installed as on-exit.taska.
+func isOnExitHook() bool {
+	return strings.HasPrefix(filepath.Base(os.Args[0]), "on-exit")
+}
+
+// spawnBatches starts one batch background run per target for the tasks of
+// an on-exit hook.
+func spawnBatches(cfg *config.Config, tasks []taskwarrior.Task, selectedCalendar string, explicit bool) error {
+	var targets []config.Target
+	groups := make(map[config.Target][]taskwarrior.Task)
+	for i := range tasks {
+		target := resolveTarget(cfg, &tasks[i], selectedCalendar, explicit)
+		if _, ok := groups[target]; !ok {
+			targets = append(targets, target)
+		}
+		groups[target] = append(groups[target], tasks[i])
+	}
+	for _, target := range targets {
+		if err := spawnBackground(target, groups[target], "--batch"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// resolveTarget picks the profile and calendar a task syncs to: an explicit
+// --calendar, else the most specific route rule, else the configured calendar
+// of the current profile.
+func resolveTarget(cfg *config.Config, task *taskwarrior.Task, selectedCalendar string, explicit bool) config.Target {
+	fallback := config.Target{Profile: paths.Profile(), Calendar: selectedCalendar}
+	if explicit {
+		return fallback
+	}
+	target, ok := cfg.Route(task)
+	if !ok {
+		return fallback
+	}
+	if target.Profile == "" || target.Profile == paths.Profile() {
+		target.Profile = paths.Profile()
+		if target.Calendar == "" {
+			target.Calendar = selectedCalendar
+		}
+	}
+	if err := paths.ValidateProfile(target.Profile); err != nil {
+		log.Printf("Warning: ignoring route to '%s': %v", target, err)
+		return fallback
+	}
+	return target
+}
+
+// spawnBackground starts a detached `taska --background` for the target and
+// passes it the tasks on stdin.
+func spawnBackground(target config.Target, tasks []taskwarrior.Task, extra ...string) error {
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not find self: %w", err)
+	}
+	args := []string{"--background", "--profile", target.Profile}
+	if target.Calendar != "" {
+		// Otherwise the background uses the profile's configured calendar
+		args = append(args, "--calendar", target.Calendar)
+	}
+	args = append(args, extra...)
+	cmd := exec.Command(self, args...)
+	cmd.Stdout = nil // Silence in background
+	cmd.Stderr = nil // Silence in background
+
+	// Encode tasks to pass via pipe
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("could not open stdin pipe: %w", err)
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// One object per task, as ParseTasks reads them
+	encoder := json.NewEncoder(stdin)
+	for _, task := range tasks {
+		if err := encoder.Encode(task); err != nil {
+			stdin.Close()
+			return err
+		}
+	}
+	return stdin.Close()
+}
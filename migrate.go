@@ -0,0 +1,181 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/conflicts"
+	"github.com/harrisonrobin/taska/pkg/google"
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/logging"
+	"github.com/harrisonrobin/taska/pkg/scheduler"
+	"github.com/harrisonrobin/taska/pkg/state"
+)
+
+// migration is a calendar migration, recorded in the state store until every
+// event has been moved so an interrupted one can be resumed.
+type migration struct {
+	From      string    `json:"from"`
+	To        string    `json:"to"`
+	FromID    string    `json:"from_id"`
+	ToID      string    `json:"to_id"`
+	StartedAt time.Time `json:"started_at"`
+}
+
+// runMigrate implements `taska migrate [--from A] [--to B] [--dry-run]`: it
+// moves every taska event from one calendar to another, keeping the event
+// IDs, and updates the sync state. Without --from it resumes the migration
+// that was interrupted.
+func runMigrate(args []string, calendarName string, cfg *config.Config) error {
+	fs := flag.NewFlagSet("migrate", flag.ExitOnError)
+	from := fs.String("from", "", "The calendar to move the events from")
+	to := fs.String("to", calendarName, "The calendar to move the events to")
+	dryRun := fs.Bool("dry-run", false, "Only show what would be moved")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: taska migrate --from <calendar> [--to <calendar>] [--dry-run]\n       taska migrate    (resume an interrupted migration)")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	pending, resuming, err := loadMigration()
+	if err != nil {
+		return err
+	}
+	switch {
+	case *from == "" && !resuming:
+		fs.Usage()
+		return fmt.Errorf("no --from calendar given and no migration to resume")
+	case *from == "":
+		*from, *to = pending.From, pending.To
+		fmt.Printf("Resuming the migration from '%s' to '%s' started %s\n", pending.From, pending.To, pending.StartedAt.Local().Format(time.DateTime))
+	case resuming && (*from != pending.From || *to != pending.To):
+		return fmt.Errorf("the migration from '%s' to '%s' is unfinished; run `taska migrate` to resume it first", pending.From, pending.To)
+	}
+
+	evtIndex, err := index.NewEventIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load event index: %w", err)
+	}
+	src, err := google.NewClient(*from, evtIndex, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open calendar '%s': %w", *from, err)
+	}
+	dst, err := google.NewClient(*to, evtIndex, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open calendar '%s': %w", *to, err)
+	}
+	if src.CalendarID() == dst.CalendarID() {
+		return fmt.Errorf("'%s' and '%s' are the same calendar", *from, *to)
+	}
+
+	events, err := src.ListTaskEvents()
+	if err != nil {
+		return err
+	}
+	if *dryRun {
+		printTaskEvents(os.Stdout, events, nil)
+		fmt.Printf("Dry run: would move %d event(s) from '%s' to '%s'.\n", len(events), *from, *to)
+		return nil
+	}
+
+	m := migration{From: *from, To: *to, FromID: src.CalendarID(), ToID: dst.CalendarID(), StartedAt: time.Now()}
+	if resuming {
+		m.StartedAt = pending.StartedAt
+	}
+	if err := state.Update(func(tx *state.Tx) error {
+		return tx.Put(state.Meta, state.MigrationKey, m)
+	}); err != nil {
+		return err
+	}
+
+	// Events moved just before an interruption may not have been recorded
+	moved, err := dst.ListTaskEvents()
+	if err != nil {
+		return err
+	}
+	for _, e := range moved {
+		dst.Adopt(e)
+	}
+	if err := evtIndex.Save(); err != nil {
+		return fmt.Errorf("failed to save event index: %w", err)
+	}
+
+	failed := 0
+	for _, e := range events {
+		if _, err := src.MoveTo(e.Id, dst); err != nil {
+			slog.Error("could not move event", logging.Op("move"), logging.Task(google.EventTaskID(e)), logging.Event(e.Id), "error", err)
+			fmt.Fprintf(os.Stderr, "could not move %q (%s): %v\n", e.Summary, e.Id, err)
+			failed++
+			continue
+		}
+		// Saved after every move, so an interruption loses at most one
+		if err := evtIndex.Save(); err != nil {
+			return fmt.Errorf("failed to save event index: %w", err)
+		}
+	}
+	if err := moveConflicts(m.FromID, m.ToID); err != nil {
+		return err
+	}
+	if err := moveSchedule(m.FromID, m.ToID); err != nil {
+		return err
+	}
+	fmt.Printf("Moved %d of %d event(s) from '%s' to '%s'.\n", len(events)-failed, len(events), *from, *to)
+	if failed > 0 {
+		return fmt.Errorf("%d event(s) could not be moved; run `taska migrate` to retry, or `taska purge --calendar %q` to drop them", failed, *from)
+	}
+
+	if err := state.Update(func(tx *state.Tx) error {
+		return tx.Delete(state.Meta, state.MigrationKey)
+	}); err != nil {
+		return err
+	}
+	if *to != calendarName {
+		fmt.Printf("taska still syncs to '%s'; run `taska calendars use %q` to sync to '%s'.\n", calendarName, *to, *to)
+	}
+	return nil
+}
+
+// loadMigration returns the unfinished migration, if there is one.
+func loadMigration() (migration, bool, error) {
+	var m migration
+	var found bool
+	err := state.View(func(tx *state.Tx) error {
+		var err error
+		found, err = tx.Get(state.Meta, state.MigrationKey, &m)
+		return err
+	})
+	return m, found, err
+}
+
+// moveConflicts points the conflicts recorded for the old calendar at the
+// new one, since their events moved with the same IDs.
+func moveConflicts(fromID, toID string) error {
+	list, err := conflicts.List()
+	if err != nil {
+		return err
+	}
+	for _, c := range list {
+		if c.CalendarID == fromID {
+			c.CalendarID = toID
+			if err := conflicts.Put(c); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// moveSchedule points the schedule's entries for the old calendar at the new
+// one.
+func moveSchedule(fromID, toID string) error {
+	sched, err := scheduler.New()
+	if err != nil {
+		return err
+	}
+	sched.MoveCalendar(fromID, toID)
+	return sched.Save()
+}
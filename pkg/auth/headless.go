@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+
+	taskaconfig "github.com/harrisonrobin/taska/pkg/config"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// Authorization flows selectable with `taska --auth --auth-method`.
+const (
+	// MethodBrowser runs a callback server on the loopback interface.
+	MethodBrowser = "browser"
+	// MethodDevice uses the OAuth 2.0 device authorization grant (RFC 8628).
+	MethodDevice = "device"
+	// MethodPaste prints the authorization URL and reads the redirect URL or
+	// code back from stdin.
+	MethodPaste = "paste"
+)
+
+// Authenticate runs the chosen authorization flow and saves the new token.
+// in and out are used by the headless flows to talk to the user.
+func Authenticate(ctx context.Context, cfg *taskaconfig.Config, method string, in io.Reader, out io.Writer) error {
+	config, installed, err := loadConfig(Scopes())
+	if err != nil {
+		return err
+	}
+	store, err := NewTokenStore(cfg)
+	if err != nil {
+		return err
+	}
+
+	var tok *oauth2.Token
+	switch method {
+	case "", MethodBrowser:
+		tok, err = getTokenFromWeb(ctx, config, installed, out)
+	case MethodDevice:
+		tok, err = getTokenFromDevice(ctx, config, out)
+	case MethodPaste:
+		tok, err = getTokenFromPaste(ctx, config, in, out)
+	default:
+		return fmt.Errorf("unknown auth method '%s' (want %s, %s or %s)", method, MethodBrowser, MethodDevice, MethodPaste)
+	}
+	if err != nil {
+		return err
+	}
+
+	return store.Save(tok)
+}
+
+// getTokenFromDevice runs the device authorization grant: the user enters a
+// short code on any device with a browser while we poll for the token.
+// Google only allows this for "TVs and Limited Input devices" OAuth clients.
+func getTokenFromDevice(ctx context.Context, config *oauth2.Config, out io.Writer) (*oauth2.Token, error) {
+	if config.Endpoint.DeviceAuthURL == "" {
+		config.Endpoint.DeviceAuthURL = google.Endpoint.DeviceAuthURL
+	}
+
+	resp, err := config.DeviceAuth(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("device authorization request failed: %w", err)
+	}
+
+	verification := resp.VerificationURIComplete
+	if verification == "" {
+		verification = resp.VerificationURI
+	}
+	fmt.Fprintf(out, "On any device, open:\n%s\nand enter the code: %s\n", verification, resp.UserCode)
+	fmt.Fprintln(out, "Waiting for authorization...")
+
+	tok, err := config.DeviceAccessToken(ctx, resp)
+	if err != nil {
+		return nil, fmt.Errorf("device authorization failed: %w", err)
+	}
+	return tok, nil
+}
+
+// getTokenFromPaste prints the authorization URL and reads back either the
+// full URL the browser was redirected to (which fails to load when the browser
+// runs on another machine) or just the code from it.
+func getTokenFromPaste(ctx context.Context, config *oauth2.Config, in io.Reader, out io.Writer) (*oauth2.Token, error) {
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+	verifier := oauth2.GenerateVerifier()
+	authURL := config.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.SetAuthURLParam("prompt", "consent"), oauth2.S256ChallengeOption(verifier))
+	fmt.Fprintf(out, "Open the following URL in a browser on any machine:\n%s\n\n", authURL)
+	fmt.Fprintln(out, "After approving, the browser is redirected to a localhost page that won't load.")
+	fmt.Fprint(out, "Paste that page's full URL (or just the code) here: ")
+
+	line, err := bufio.NewReader(in).ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return nil, fmt.Errorf("could not read the authorization code: %w", err)
+	}
+	code, err := parsePastedCode(line, state)
+	if err != nil {
+		return nil, err
+	}
+
+	tok, err := config.Exchange(ctx, code, oauth2.VerifierOption(verifier))
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve token from Google: %w", err)
+	}
+	return tok, nil
+}
+
+// parsePastedCode extracts the authorization code from a pasted redirect URL,
+// checking its state, or accepts a bare code.
+func parsePastedCode(input, state string) (string, error) {
+	input = strings.TrimSpace(input)
+	if input == "" {
+		return "", fmt.Errorf("no authorization code given")
+	}
+	if !strings.Contains(input, "://") && !strings.Contains(input, "code=") {
+		return input, nil
+	}
+
+	raw := input
+	if i := strings.Index(raw, "?"); i >= 0 {
+		raw = raw[i+1:]
+	}
+	query, err := url.ParseQuery(raw)
+	if err != nil {
+		return "", fmt.Errorf("could not parse the pasted URL: %w", err)
+	}
+	if e := query.Get("error"); e != "" {
+		return "", fmt.Errorf("authorization denied: %s", e)
+	}
+	if got := query.Get("state"); got != state {
+		return "", fmt.Errorf("state mismatch in the pasted URL; start again with taska --auth")
+	}
+	code := query.Get("code")
+	if code == "" {
+		return "", fmt.Errorf("no code in the pasted URL")
+	}
+	return code, nil
+}
+
+// randomState returns an unguessable OAuth state value.
+func randomState() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("could not generate OAuth state: %w", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
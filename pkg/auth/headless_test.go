@@ -0,0 +1,168 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeOAuthServer stands in for Google's authorization server.
+type fakeOAuthServer struct {
+	t           *testing.T
+	pendingPoll int    // authorization_pending replies before the device grant succeeds
+	challenge   string // if set, the PKCE code_verifier must match this S256 challenge
+}
+
+func (f *fakeOAuthServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		f.t.Fatalf("ParseForm failed: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch r.URL.Path {
+	case "/device/code":
+		json.NewEncoder(w).Encode(map[string]any{
+			"device_code":      "dev-123",
+			"user_code":        "ABCD-EFGH",
+			"verification_url": "https://example.test/device",
+			"expires_in":       60,
+			"interval":         1,
+		})
+	case "/token":
+		switch r.Form.Get("grant_type") {
+		case "urn:ietf:params:oauth:grant-type:device_code":
+			if r.Form.Get("device_code") != "dev-123" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+				return
+			}
+			if f.pendingPoll > 0 {
+				f.pendingPoll--
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "authorization_pending"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"access_token": "device-access", "refresh_token": "device-refresh", "token_type": "Bearer", "expires_in": 3600,
+			})
+		case "authorization_code":
+			if f.challenge != "" && oauth2.S256ChallengeFromVerifier(r.Form.Get("code_verifier")) != f.challenge {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant", "error_description": "PKCE verification failed"})
+				return
+			}
+			if r.Form.Get("code") != "code-456" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+				return
+			}
+			json.NewEncoder(w).Encode(map[string]any{
+				"access_token": "paste-access", "refresh_token": "paste-refresh", "token_type": "Bearer", "expires_in": 3600,
+			})
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func newTestConfig(serverURL string) *oauth2.Config {
+	return &oauth2.Config{
+		ClientID:     "client",
+		ClientSecret: "secret",
+		RedirectURL:  "http://localhost:" + LocalhostAuthPort + "/",
+		Scopes:       Scopes(),
+		Endpoint: oauth2.Endpoint{
+			AuthURL:       serverURL + "/auth",
+			TokenURL:      serverURL + "/token",
+			DeviceAuthURL: serverURL + "/device/code",
+			AuthStyle:     oauth2.AuthStyleInParams,
+		},
+	}
+}
+
+func TestDeviceFlow(t *testing.T) {
+	server := httptest.NewServer(&fakeOAuthServer{t: t, pendingPoll: 1})
+	defer server.Close()
+
+	var out bytes.Buffer
+	tok, err := getTokenFromDevice(context.Background(), newTestConfig(server.URL), &out)
+	if err != nil {
+		t.Fatalf("getTokenFromDevice failed: %v", err)
+	}
+	if tok.AccessToken != "device-access" || tok.RefreshToken != "device-refresh" {
+		t.Errorf("Unexpected token: %+v", tok)
+	}
+	if !strings.Contains(out.String(), "ABCD-EFGH") || !strings.Contains(out.String(), "https://example.test/device") {
+		t.Errorf("Expected the user code and URL to be printed, got: %s", out.String())
+	}
+}
+
+func TestPasteFlow(t *testing.T) {
+	server := httptest.NewServer(&fakeOAuthServer{t: t})
+	defer server.Close()
+	config := newTestConfig(server.URL)
+
+	// The state is only known from the printed URL, so feed the reader lazily.
+	pr := &pasteReader{}
+	var out bytes.Buffer
+	pr.out = &out
+
+	tok, err := getTokenFromPaste(context.Background(), config, pr, &out)
+	if err != nil {
+		t.Fatalf("getTokenFromPaste failed: %v", err)
+	}
+	if tok.AccessToken != "paste-access" {
+		t.Errorf("Unexpected token: %+v", tok)
+	}
+
+	// A bare code is accepted as well.
+	tok, err = getTokenFromPaste(context.Background(), config, strings.NewReader("code-456\n"), &bytes.Buffer{})
+	if err != nil {
+		t.Fatalf("getTokenFromPaste with bare code failed: %v", err)
+	}
+	if tok.RefreshToken != "paste-refresh" {
+		t.Errorf("Unexpected token: %+v", tok)
+	}
+}
+
+// pasteReader answers with the redirect URL a browser would land on, using
+// the state from the authorization URL printed so far.
+type pasteReader struct {
+	out  *bytes.Buffer
+	done bool
+}
+
+var authURLPattern = regexp.MustCompile(`http\S+/auth\?\S+`)
+
+func (p *pasteReader) Read(b []byte) (int, error) {
+	if p.done {
+		return 0, nil
+	}
+	p.done = true
+	authURL, _ := url.Parse(authURLPattern.FindString(p.out.String()))
+	state := authURL.Query().Get("state")
+	line := "http://localhost:" + LocalhostAuthPort + "/?state=" + url.QueryEscape(state) + "&code=code-456&scope=x\n"
+	return copy(b, line), nil
+}
+
+func TestParsePastedCode(t *testing.T) {
+	if _, err := parsePastedCode("http://localhost:6789/?state=wrong&code=abc", "right"); err == nil {
+		t.Error("Expected a state mismatch error")
+	}
+	if _, err := parsePastedCode("http://localhost:6789/?state=right&error=access_denied", "right"); err == nil {
+		t.Error("Expected an access denied error")
+	}
+	code, err := parsePastedCode("  4/0AbCd  \n", "right")
+	if err != nil || code != "4/0AbCd" {
+		t.Errorf("Expected bare code 4/0AbCd, got %q (err: %v)", code, err)
+	}
+}
@@ -0,0 +1,182 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"html/template"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// loopbackTimeout is how long the user has to approve access in the browser.
+const loopbackTimeout = 5 * time.Minute
+
+// getTokenFromWeb initiates the OAuth 2.0 authorization code flow via a local web server.
+// The user opens the authorization URL in a browser and Google redirects back
+// to a server listening only on 127.0.0.1. The redirect must carry the random
+// state we sent, and the code is exchanged with a PKCE (S256) verifier.
+// Installed clients get an ephemeral port; other clients must use the port of
+// their registered redirect URI.
+func getTokenFromWeb(ctx context.Context, config *oauth2.Config, installed bool, out io.Writer) (*oauth2.Token, error) {
+	redirect, err := url.Parse(config.RedirectURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redirect URL '%s': %w", config.RedirectURL, err)
+	}
+	port := redirect.Port()
+	if installed || port == "" {
+		port = "0"
+	}
+
+	// Start a local HTTP server to capture the redirect
+	listener, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to start listener on 127.0.0.1:%s: %w", port, err)
+	}
+	defer listener.Close() // Ensure listener is closed
+
+	// Work on a copy so the caller's config keeps the registered redirect URL
+	flow := *config
+	if port == "0" {
+		redirect.Host = listener.Addr().String()
+		flow.RedirectURL = redirect.String()
+	}
+
+	state, err := randomState()
+	if err != nil {
+		return nil, err
+	}
+	handler := newCallbackHandler(redirect.Path, state)
+	server := &http.Server{
+		Handler:      handler,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		IdleTimeout:  15 * time.Second,
+	}
+	serveErr := make(chan error, 1)
+	go func() {
+		log.Printf("Local server listening on %s for OAuth2 redirect...", flow.RedirectURL)
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			serveErr <- fmt.Errorf("HTTP server error: %w", err)
+		}
+	}()
+	defer server.Shutdown(context.Background())
+
+	// AccessTypeOffline is crucial to ensure a refresh token is returned.
+	verifier := oauth2.GenerateVerifier()
+	authURL := flow.AuthCodeURL(state, oauth2.AccessTypeOffline, oauth2.SetAuthURLParam("prompt", "consent"), oauth2.S256ChallengeOption(verifier))
+	fmt.Fprintf(out, "Please open the following URL in your browser to authorize taska:\n%s\n", authURL)
+	log.Println("Waiting for authorization code...")
+
+	timeout := time.NewTimer(loopbackTimeout)
+	defer timeout.Stop()
+	select {
+	case res := <-handler.result:
+		if res.err != nil {
+			return nil, res.err
+		}
+		exchangeCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		tok, err := flow.Exchange(exchangeCtx, res.code, oauth2.VerifierOption(verifier))
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve token from Google: %w", err)
+		}
+		return tok, nil
+	case err := <-serveErr:
+		return nil, err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-timeout.C: // Timeout for the user to authorize
+		return nil, fmt.Errorf("authorization timed out. Please try again")
+	}
+}
+
+// callbackResult is the outcome of the OAuth redirect.
+type callbackResult struct {
+	code string
+	err  error
+}
+
+// callbackHandler serves the OAuth redirect. Requests for other paths get a
+// 404 and requests without the expected state are rejected without ending the
+// flow, so a forged or stale redirect can neither inject a code nor abort the
+// login. The first genuine redirect is delivered on result; later ones are
+// dropped instead of blocking.
+type callbackHandler struct {
+	path   string
+	state  string
+	result chan callbackResult
+}
+
+func newCallbackHandler(path, state string) *callbackHandler {
+	if path == "" {
+		path = "/"
+	}
+	return &callbackHandler{path: path, state: state, result: make(chan callbackResult, 1)}
+}
+
+func (h *callbackHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != h.path {
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeCallbackPage(w, http.StatusMethodNotAllowed, false, "Unexpected request method.")
+		return
+	}
+
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(h.state)) != 1 {
+		log.Printf("Warning: ignoring OAuth redirect with an invalid state from %s", r.RemoteAddr)
+		writeCallbackPage(w, http.StatusBadRequest, false, "This authorization response does not belong to the running taska login. Start again with taska --auth.")
+		return
+	}
+	if e := query.Get("error"); e != "" {
+		writeCallbackPage(w, http.StatusForbidden, false, "Authorization was not granted: "+e)
+		h.deliver(callbackResult{err: fmt.Errorf("authorization denied: %s", e)})
+		return
+	}
+	code := query.Get("code")
+	if code == "" {
+		writeCallbackPage(w, http.StatusBadRequest, false, "The authorization code is missing.")
+		h.deliver(callbackResult{err: fmt.Errorf("authorization code not found in redirect URL")})
+		return
+	}
+
+	writeCallbackPage(w, http.StatusOK, true, "taska is now authorized. You can close this window.")
+	h.deliver(callbackResult{code: code})
+}
+
+func (h *callbackHandler) deliver(res callbackResult) {
+	select {
+	case h.result <- res:
+	default: // a result is already pending
+	}
+}
+
+var callbackPage = template.Must(template.New("callback").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head><meta charset="utf-8"><title>taska authorization</title></head>
+<body style="font-family: sans-serif; max-width: 36em; margin: 4em auto;">
+<h1>{{if .OK}}Authorization successful{{else}}Authorization failed{{end}}</h1>
+<p>{{.Message}}</p>
+</body>
+</html>
+`))
+
+func writeCallbackPage(w http.ResponseWriter, status int, ok bool, message string) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	callbackPage.Execute(w, struct {
+		OK      bool
+		Message string
+	}{ok, message})
+}
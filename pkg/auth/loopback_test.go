@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func TestCallbackHandler(t *testing.T) {
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantCode   string
+		wantErr    bool
+		delivered  bool
+	}{
+		{"success", "/callback?state=good&code=abc", http.StatusOK, "abc", false, true},
+		{"wrong state is ignored", "/callback?state=evil&code=abc", http.StatusBadRequest, "", false, false},
+		{"missing state is ignored", "/callback?code=abc", http.StatusBadRequest, "", false, false},
+		{"other path", "/favicon.ico", http.StatusNotFound, "", false, false},
+		{"denied", "/callback?state=good&error=access_denied", http.StatusForbidden, "", true, true},
+		{"missing code", "/callback?state=good", http.StatusBadRequest, "", true, true},
+	}
+	for _, tt := range tests {
+		h := newCallbackHandler("/callback", "good")
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+		if rec.Code != tt.wantStatus {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.wantStatus)
+		}
+		if ct := rec.Header().Get("Content-Type"); tt.wantStatus != http.StatusNotFound && !strings.HasPrefix(ct, "text/html") {
+			t.Errorf("%s: Content-Type %q, want an HTML page", tt.name, ct)
+		}
+		select {
+		case res := <-h.result:
+			if !tt.delivered {
+				t.Errorf("%s: unexpected result %+v", tt.name, res)
+			} else if res.code != tt.wantCode || (res.err != nil) != tt.wantErr {
+				t.Errorf("%s: got code %q err %v", tt.name, res.code, res.err)
+			}
+		default:
+			if tt.delivered {
+				t.Errorf("%s: no result delivered", tt.name)
+			}
+		}
+	}
+}
+
+func TestCallbackHandlerEscapesError(t *testing.T) {
+	h := newCallbackHandler("/", "s")
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/?state=s&error="+url.QueryEscape("<script>x</script>"), nil))
+	if strings.Contains(rec.Body.String(), "<script>") {
+		t.Errorf("error page is not escaped: %s", rec.Body.String())
+	}
+}
+
+func TestCallbackHandlerDoesNotBlock(t *testing.T) {
+	h := newCallbackHandler("/", "s")
+	srv := httptest.NewServer(h)
+	defer srv.Close()
+
+	// Nobody reads h.result; the second redirect must still get a response.
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(srv.URL + "/?state=s&code=c")
+		if err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+		resp.Body.Close()
+	}
+	if res := <-h.result; res.code != "c" {
+		t.Errorf("got %+v, want code c", res)
+	}
+}
+
+func TestWebFlow(t *testing.T) {
+	fake := &fakeOAuthServer{t: t}
+	authServer := httptest.NewServer(fake)
+	defer authServer.Close()
+	config := newTestConfig(authServer.URL)
+
+	outR, outW := io.Pipe()
+	type result struct {
+		tok *oauth2.Token
+		err error
+	}
+	done := make(chan result, 1)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	go func() {
+		tok, err := getTokenFromWeb(ctx, config, true, outW)
+		done <- result{tok, err}
+	}()
+
+	// Read the printed authorization URL, as the user would.
+	var authURL *url.URL
+	scanner := bufio.NewScanner(outR)
+	for scanner.Scan() {
+		if strings.HasPrefix(scanner.Text(), authServer.URL) {
+			authURL, _ = url.Parse(scanner.Text())
+			break
+		}
+	}
+	go io.Copy(io.Discard, outR)
+	if authURL == nil {
+		t.Fatal("authorization URL was not printed")
+	}
+	q := authURL.Query()
+	if q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Fatalf("authorization URL lacks a PKCE challenge: %s", authURL)
+	}
+	if q.Get("state") == "" || q.Get("state") == "state-token" {
+		t.Fatalf("authorization URL has no random state: %s", authURL)
+	}
+	fake.challenge = q.Get("code_challenge")
+
+	redirect, err := url.Parse(q.Get("redirect_uri"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if redirect.Hostname() != "127.0.0.1" || redirect.Port() == LocalhostAuthPort {
+		t.Errorf("redirect_uri %s is not an ephemeral loopback port", redirect)
+	}
+
+	// The browser follows Google's redirect back to the local server.
+	resp, err := http.Get(redirect.String() + "?state=" + url.QueryEscape(q.Get("state")) + "&code=code-456")
+	if err != nil {
+		t.Fatalf("callback request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("callback status %d", resp.StatusCode)
+	}
+
+	res := <-done
+	if res.err != nil {
+		t.Fatalf("getTokenFromWeb failed: %v", res.err)
+	}
+	if res.tok.RefreshToken != "paste-refresh" {
+		t.Errorf("unexpected token %+v", res.tok)
+	}
+	if config.RedirectURL != "http://localhost:"+LocalhostAuthPort+"/" {
+		t.Errorf("caller's config was modified: %s", config.RedirectURL)
+	}
+}
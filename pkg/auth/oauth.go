@@ -3,15 +3,18 @@ package auth
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
-	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
+	taskaconfig "github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/paths"
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/calendar/v3" // Used for calendar.CalendarEventsScope
@@ -32,27 +35,48 @@ const (
 	// LocalhostAuthPort is the port that the local web server will listen on
 	// to capture the OAuth redirect. Choose a free port.
 	LocalhostAuthPort = "6789"
-
-	xdgAppName = "taska"
 )
 
+// Scopes returns the OAuth scopes taska needs.
+// calendar.CalendarEventsScope: Allows viewing and editing events on all calendars.
+// calendar.CalendarReadonlyScope: Allows resolving calendar names to IDs.
+// calendar.CalendarAppCreatedScope: Allows creating the calendar taska syncs
+// to; tokens from before it was added lack it.
+func Scopes() []string {
+	return []string{
+		calendar.CalendarEventsScope,
+		calendar.CalendarReadonlyScope,
+		calendar.CalendarAppCreatedScope,
+	}
+}
+
 // GetConfig creates an oauth2.Config from the client secrets file and specified scopes.
 func GetConfig(scopes []string) (*oauth2.Config, error) {
-	xdgConfigBase, err := GetXdgHome()
+	config, _, err := loadConfig(scopes)
+	return config, err
+}
+
+// loadConfig is GetConfig that also reports whether the credentials belong to
+// an installed ("Desktop app") client. Google lets those redirect to any
+// loopback port, so the browser flow can use an ephemeral one.
+func loadConfig(scopes []string) (*oauth2.Config, bool, error) {
+	clientSecretsFile, err := credentialsPath()
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-
-	clientSecretsFile := filepath.Join(xdgConfigBase, ClientSecretsFile)
 	b, err := os.ReadFile(clientSecretsFile)
 	if err != nil {
-		return nil, fmt.Errorf("unable to read client secret file %s: %w", clientSecretsFile, err)
+		return nil, false, fmt.Errorf("unable to read client secret file %s: %w", clientSecretsFile, err)
 	}
 
 	config, err := google.ConfigFromJSON(b, scopes...)
 	if err != nil {
-		return nil, fmt.Errorf("unable to parse client secret file to config: %w", err)
+		return nil, false, fmt.Errorf("unable to parse client secret file to config: %w", err)
+	}
+	var clientType struct {
+		Installed json.RawMessage `json:"installed"`
 	}
+	installed := json.Unmarshal(b, &clientType) == nil && len(clientType.Installed) > 0
 
 	parsedURL, parseErr := url.Parse(config.RedirectURL)
 	if parseErr != nil {
@@ -81,129 +105,174 @@ func GetConfig(scopes []string) (*oauth2.Config, error) {
 		log.Printf("Warning: Configured RedirectURL in credentials.json is not a localhost callback or OOB: %s. Ensure this is correct for your setup.", config.RedirectURL)
 	}
 
-	return config, nil
+	return config, installed, nil
 }
 
-// GetClient retrieves an authenticated *http.Client.
-// It tries to load an existing token, refreshes it if expired, or
-// initiates a new web-based authorization flow if no token exists.
-func GetClient(ctx context.Context, scopes []string) (*http.Client, error) {
-	config, err := GetConfig(scopes)
+// CheckCredentials validates the client secrets file. It returns the redirect
+// URI found in it and, if that URI won't reach the local callback server on
+// LocalhostAuthPort, a description of the problem.
+func CheckCredentials() (redirectURL string, problem string, err error) {
+	clientSecretsFile, err := credentialsPath()
+	if err != nil {
+		return "", "", err
+	}
+	b, err := os.ReadFile(clientSecretsFile)
+	if err != nil {
+		return "", "", err
+	}
+	config, err := google.ConfigFromJSON(b, Scopes()...)
+	if err != nil {
+		return "", "", fmt.Errorf("unable to parse client secret file: %w", err)
+	}
+	if config.ClientID == "" || config.ClientSecret == "" {
+		return config.RedirectURL, "", fmt.Errorf("client secret file has no client_id or client_secret")
+	}
+
+	redirectURL = config.RedirectURL
+	parsedURL, err := url.Parse(redirectURL)
+	switch {
+	case redirectURL == "urn:ietf:wg:oauth:2.0:oob":
+		problem = "uses the deprecated out-of-band redirect"
+	case err != nil:
+		problem = fmt.Sprintf("redirect URI cannot be parsed: %v", err)
+	case parsedURL.Hostname() != "localhost" && parsedURL.Hostname() != "127.0.0.1":
+		problem = "redirect URI is not a localhost callback"
+	case parsedURL.Port() != "" && parsedURL.Port() != LocalhostAuthPort:
+		problem = fmt.Sprintf("redirect URI uses port %s, but taska listens on %s", parsedURL.Port(), LocalhostAuthPort)
+	}
+	return redirectURL, problem, nil
+}
+
+// GetClient retrieves an authenticated *http.Client using the token source
+// selected by the config.
+func GetClient(ctx context.Context, cfg *taskaconfig.Config, scopes []string) (*http.Client, error) {
+	src, err := TokenSource(ctx, cfg, scopes)
 	if err != nil {
 		return nil, err
 	}
+	return oauth2.NewClient(ctx, src), nil
+}
 
-	xdgConfigBase, err := GetXdgHome()
+// TokenSource returns the credentials taska calls the API with: the service
+// account key when one is configured, otherwise the user's OAuth token.
+func TokenSource(ctx context.Context, cfg *taskaconfig.Config, scopes []string) (oauth2.TokenSource, error) {
+	if cfg.UsesServiceAccount() {
+		return serviceAccountTokenSource(ctx, cfg.ServiceAccount, scopes)
+	}
+	return userTokenSource(ctx, cfg, scopes)
+}
+
+// userTokenSource loads the user's token from the configured token store, or
+// initiates a new web-based authorization flow if no token exists. Refreshed
+// tokens are written back to the store.
+func userTokenSource(ctx context.Context, cfg *taskaconfig.Config, scopes []string) (oauth2.TokenSource, error) {
+	config, installed, err := loadConfig(scopes)
 	if err != nil {
 		return nil, err
 	}
 
-	tokenFile := filepath.Join(xdgConfigBase, TokenFile)
-	tok, err := tokenFromFile(tokenFile)
+	store, err := NewTokenStore(cfg)
 	if err != nil {
+		return nil, err
+	}
+
+	tok, err := store.Load()
+	if errors.Is(err, ErrNoToken) {
 		// No existing token, perform the full OAuth flow
-		log.Printf("No existing token found at %s. Initiating web authorization flow...", tokenFile)
-		tok, err = getTokenFromWeb(config)
+		log.Printf("No existing token found in %s. Initiating web authorization flow...", store)
+		tok, err = getTokenFromWeb(ctx, config, installed, os.Stdout)
 		if err != nil {
 			return nil, fmt.Errorf("failed to get token from web: %w", err)
 		}
-		saveToken(tokenFile, tok) // Save the newly obtained token
+		if err := store.Save(tok); err != nil {
+			return nil, err
+		}
+	} else if err != nil {
+		return nil, err
 	}
 
-	// config.Client creates an HTTP client that automatically handles token refreshing.
-	// If the AccessToken is expired and a RefreshToken is available, it will use the
-	// RefreshToken to get a new AccessToken.
-	client := config.Client(ctx, tok)
-
-	// It's good practice to ensure the token in TokenFile is always the latest valid one,
-	// especially after an automatic refresh by config.Client().
-	// We get the token from the TokenSource created by config.Client
-	// and re-save it if it has changed (e.g., AccessToken was refreshed).
-	// Note: It's rare but possible for the RefreshToken itself to change,
-	// so always saving the whole token is safest.
-	go func() {
-		currentTok, err := config.TokenSource(ctx, tok).Token()
-		if err != nil {
-			log.Printf("Warning: Could not get current token from source for re-saving: %v", err)
-			return
-		}
-		// Compare access tokens for simplicity, if they differ, save the new one.
-		// A more robust check might compare entire token structs, but access token change
-		// is the most common indication of a refresh.
-		if currentTok.AccessToken != tok.AccessToken || currentTok.RefreshToken != tok.RefreshToken {
-			saveToken(tokenFile, currentTok)
-		}
-	}()
+	// The token source refreshes the AccessToken with the RefreshToken when it
+	// expires; persistingTokenSource saves each new token to the store.
+	src := &persistingTokenSource{base: config.TokenSource(ctx, tok), store: store, last: tok}
+	return oauth2.ReuseTokenSource(tok, src), nil
+}
 
-	return client, nil
+// TokenCheck describes the health of the saved OAuth token.
+type TokenCheck struct {
+	Expiry        time.Time
+	Scopes        []string
+	MissingScopes []string
 }
 
-// getTokenFromWeb initiates the OAuth 2.0 authorization code flow via a local web server.
-// It opens a browser window for the user to grant permission and captures the redirect.
-func getTokenFromWeb(config *oauth2.Config) (*oauth2.Token, error) {
-	// Create a channel to receive the authorization code
-	codeCh := make(chan string)
-	errCh := make(chan error)
+// CheckToken verifies that the saved token can be refreshed and that it grants
+// every scope in Scopes(). It never starts an authorization flow. The refreshed
+// token is saved. With a service account it checks that a token can be issued.
+func CheckToken(ctx context.Context, cfg *taskaconfig.Config) (*TokenCheck, error) {
+	if cfg.UsesServiceAccount() {
+		return checkServiceAccountToken(ctx, cfg.ServiceAccount)
+	}
+	store, err := NewTokenStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return CheckStoredToken(ctx, store)
+}
 
-	// Start a local HTTP server to capture the redirect
-	listener, err := net.Listen("tcp", fmt.Sprintf(":%s", LocalhostAuthPort))
+// CheckStoredToken is CheckToken for the user token kept in store.
+func CheckStoredToken(ctx context.Context, store TokenStore) (*TokenCheck, error) {
+	config, err := GetConfig(Scopes())
 	if err != nil {
-		return nil, fmt.Errorf("failed to start listener on port %s: %w", LocalhostAuthPort, err)
+		return nil, err
+	}
+	tok, err := store.Load()
+	if err != nil {
+		return nil, err
+	}
+	if tok.RefreshToken == "" {
+		return nil, fmt.Errorf("token has no refresh token")
 	}
-	defer listener.Close() // Ensure listener is closed
 
-	server := &http.Server{
-		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			code := r.URL.Query().Get("code")
-			if code == "" {
-				http.Error(w, "Authorization code not found", http.StatusBadRequest)
-				errCh <- fmt.Errorf("authorization code not found in redirect URL")
-				return
-			}
-			fmt.Fprintf(w, "Authentication successful! You can close this window.")
-			codeCh <- code // Send the code to the channel
-		}),
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		IdleTimeout:  15 * time.Second,
+	// Force a refresh by presenting the token as expired.
+	expired := *tok
+	expired.AccessToken = ""
+	expired.Expiry = time.Now().Add(-time.Hour)
+	refreshed, err := config.TokenSource(ctx, &expired).Token()
+	if err != nil {
+		return nil, fmt.Errorf("token refresh failed: %w", err)
+	}
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = tok.RefreshToken
+	}
+	if err := store.Save(refreshed); err != nil {
+		return nil, err
 	}
 
-	go func() {
-		log.Printf("Local server listening on %s for OAuth2 redirect...", config.RedirectURL)
-		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
-			errCh <- fmt.Errorf("HTTP server error: %w", err)
+	check := &TokenCheck{Expiry: refreshed.Expiry}
+	if scope, ok := refreshed.Extra("scope").(string); ok {
+		check.Scopes = strings.Fields(scope)
+	}
+	if len(check.Scopes) > 0 { // Google reports the granted scopes on refresh
+		granted := make(map[string]bool, len(check.Scopes))
+		for _, s := range check.Scopes {
+			granted[s] = true
 		}
-	}()
-
-	// Construct the authorization URL
-	// AccessTypeOffline is crucial to ensure a refresh token is returned.
-	authURL := config.AuthCodeURL("state-token", oauth2.AccessTypeOffline, oauth2.SetAuthURLParam("prompt", "consent"))
-	fmt.Printf("Please open the following URL in your browser to authorize TaskwarriorAgenda:\n%s\n", authURL)
-
-	// Attempt to open the URL in the default browser (platform-dependent)
-	// You might need a more robust cross-platform solution for this.
-	// For simple cases, `go run` often opens it automatically if you have
-	// a "Desktop App" client type configured to OOB or localhost redirect.
-	log.Println("Waiting for authorization code...")
-
-	select {
-	case authCode := <-codeCh:
-		// Exchange the authorization code for tokens
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
-		tok, err := config.Exchange(ctx, authCode)
-		if err != nil {
-			return nil, fmt.Errorf("unable to retrieve token from Google: %w", err)
+		for _, s := range Scopes() {
+			if !granted[s] {
+				check.MissingScopes = append(check.MissingScopes, s)
+			}
 		}
-		// Shut down the local server after successful exchange
-		server.Shutdown(ctx)
-		return tok, nil
-	case err := <-errCh:
+	}
+	return check, nil
+}
+
+// LoadToken reads the saved OAuth token from the configured store without
+// refreshing it.
+func LoadToken(cfg *taskaconfig.Config) (*oauth2.Token, error) {
+	store, err := NewTokenStore(cfg)
+	if err != nil {
 		return nil, err
-	case <-time.After(5 * time.Minute): // Timeout for the user to authorize
-		server.Shutdown(context.Background()) // Attempt to shut down server on timeout
-		return nil, fmt.Errorf("authorization timed out. Please try again")
 	}
+	return store.Load()
 }
 
 // tokenFromFile reads an oauth2.Token from a JSON file.
@@ -221,40 +290,10 @@ func tokenFromFile(file string) (*oauth2.Token, error) {
 	return tok, nil
 }
 
-// saveToken saves an oauth2.Token to a JSON file.
-func saveToken(path string, token *oauth2.Token) {
-	// Create the directory if it doesn't exist
-	dir := os.Args[0] // Default to current executable directory if no specific path is given
-	if len(path) > 0 && path[len(path)-1] != os.PathSeparator {
-		dir = path[:len(path)-len("token.json")] // Get directory from path
-	}
-	if dir == "" {
-		dir = "." // Current directory
-	}
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		log.Printf("Warning: Could not create token directory %s: %v", dir, err)
-	}
-
-	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600) // 0600: read/write for owner only
-	if err != nil {
-		log.Fatalf("Unable to cache OAuth token to %s: %v", path, err)
-	}
-	defer f.Close()
-	json.NewEncoder(f).Encode(token)
-}
-
 // GetCalendarService creates an authenticated Google Calendar service.
 // This is the function your main application logic (e.g., `sync.go`) will call.
-func GetCalendarService(ctx context.Context) (*calendar.Service, error) {
-	// Define the necessary scopes for your application.
-	// calendar.CalendarEventsScope: Allows viewing and editing events on all calendars.
-	// This is typically sufficient for a sync tool.
-	scopes := []string{
-		calendar.CalendarEventsScope,
-		calendar.CalendarReadonlyScope,
-	}
-
-	client, err := GetClient(ctx, scopes)
+func GetCalendarService(ctx context.Context, cfg *taskaconfig.Config) (*calendar.Service, error) {
+	client, err := GetClient(ctx, cfg, Scopes())
 	if err != nil {
 		return nil, fmt.Errorf("failed to get authenticated client for Calendar API: %w", err)
 	}
@@ -267,10 +306,35 @@ func GetCalendarService(ctx context.Context) (*calendar.Service, error) {
 	return srv, nil
 }
 
-func GetXdgHome() (string, error) {
-	xdgHome, err := os.UserHomeDir()
+// credentialsPath returns the selected profile's client secrets file. A named
+// profile without one falls back to the default profile's, since one OAuth
+// client can authorize several Google accounts.
+func credentialsPath() (string, error) {
+	xdgConfigBase, err := GetXdgHome()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(xdgConfigBase, ClientSecretsFile)
+	if paths.IsDefault() || fileExists(path) {
+		return path, nil
+	}
+	base, err := paths.BaseConfigDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(xdgHome, ".config", xdgAppName), nil
+	if fallback := filepath.Join(base, ClientSecretsFile); fileExists(fallback) {
+		return fallback, nil
+	}
+	return path, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// GetXdgHome returns the config directory of the selected profile, which
+// holds credentials.json and the token.
+func GetXdgHome() (string, error) {
+	return paths.ConfigDir()
 }
@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/paths"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+	"golang.org/x/oauth2/jwt"
+)
+
+// serviceAccountTokenSource returns tokens for the configured service account,
+// impersonating its subject when one is set. The key is signed locally, so
+// nothing needs to be stored between runs.
+func serviceAccountTokenSource(ctx context.Context, sa config.ServiceAccountConfig, scopes []string) (oauth2.TokenSource, error) {
+	conf, err := serviceAccountConfig(sa, scopes)
+	if err != nil {
+		return nil, err
+	}
+	return conf.TokenSource(ctx), nil
+}
+
+func serviceAccountConfig(sa config.ServiceAccountConfig, scopes []string) (*jwt.Config, error) {
+	path, err := serviceAccountKeyPath(sa.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account key %s: %w", path, err)
+	}
+	conf, err := google.JWTConfigFromJSON(b, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse service account key %s: %w", path, err)
+	}
+	conf.Subject = sa.Subject
+	return conf, nil
+}
+
+// serviceAccountKeyPath resolves the key file against the config directory.
+func serviceAccountKeyPath(keyFile string) (string, error) {
+	if keyFile == "~" || strings.HasPrefix(keyFile, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		return filepath.Join(home, strings.TrimPrefix(keyFile, "~")), nil
+	}
+	if filepath.IsAbs(keyFile) {
+		return keyFile, nil
+	}
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, keyFile), nil
+}
+
+// ServiceAccountEmail returns the client email of the configured key, for
+// messages and diagnostics.
+func ServiceAccountEmail(sa config.ServiceAccountConfig) (string, error) {
+	conf, err := serviceAccountConfig(sa, Scopes())
+	if err != nil {
+		return "", err
+	}
+	return conf.Email, nil
+}
+
+func checkServiceAccountToken(ctx context.Context, sa config.ServiceAccountConfig) (*TokenCheck, error) {
+	src, err := serviceAccountTokenSource(ctx, sa, Scopes())
+	if err != nil {
+		return nil, err
+	}
+	tok, err := src.Token()
+	if err != nil {
+		if sa.Subject != "" {
+			return nil, fmt.Errorf("could not impersonate %s: %w", sa.Subject, err)
+		}
+		return nil, fmt.Errorf("could not get a service account token: %w", err)
+	}
+	// Scopes are fixed by the key's grant (and, for delegation, by the
+	// Workspace admin); a token is only issued if all of them are allowed.
+	return &TokenCheck{Expiry: tok.Expiry, Scopes: Scopes()}, nil
+}
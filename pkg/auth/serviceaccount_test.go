@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+)
+
+func TestServiceAccountTokenSource(t *testing.T) {
+	var claims map[string]any
+	tokenServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if r.Form.Get("grant_type") != "urn:ietf:params:oauth:grant-type:jwt-bearer" {
+			t.Errorf("unexpected grant type %q", r.Form.Get("grant_type"))
+		}
+		parts := strings.Split(r.Form.Get("assertion"), ".")
+		if len(parts) != 3 {
+			t.Fatalf("malformed assertion %q", r.Form.Get("assertion"))
+		}
+		payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+		json.Unmarshal(payload, &claims)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]any{"access_token": "sa-access", "token_type": "Bearer", "expires_in": 3600})
+	}))
+	defer tokenServer.Close()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, _ := x509.MarshalPKCS8PrivateKey(key)
+	keyJSON, _ := json.Marshal(map[string]string{
+		"type":           "service_account",
+		"client_email":   "sprint@project.iam.gserviceaccount.com",
+		"private_key_id": "kid",
+		"private_key":    string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})),
+		"token_uri":      tokenServer.URL,
+	})
+	keyFile := filepath.Join(t.TempDir(), "sa.json")
+	if err := os.WriteFile(keyFile, keyJSON, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	cfg := &config.Config{ServiceAccount: config.ServiceAccountConfig{KeyFile: keyFile, Subject: "lead@example.com"}}
+	src, err := TokenSource(context.Background(), cfg, Scopes())
+	if err != nil {
+		t.Fatalf("TokenSource: %v", err)
+	}
+	tok, err := src.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if tok.AccessToken != "sa-access" {
+		t.Errorf("access token = %q", tok.AccessToken)
+	}
+	if claims["iss"] != "sprint@project.iam.gserviceaccount.com" || claims["sub"] != "lead@example.com" {
+		t.Errorf("unexpected assertion claims %v", claims)
+	}
+	if scope, _ := claims["scope"].(string); scope != strings.Join(Scopes(), " ") {
+		t.Errorf("assertion scope = %q", scope)
+	}
+
+	if email, err := ServiceAccountEmail(cfg.ServiceAccount); err != nil || email != "sprint@project.iam.gserviceaccount.com" {
+		t.Errorf("ServiceAccountEmail = %q, %v", email, err)
+	}
+}
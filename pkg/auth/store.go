@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/paths"
+	"golang.org/x/oauth2"
+)
+
+// Token store backends, selected with "token_store" in the config.
+const (
+	StoreFile          = "file"
+	StoreEncrypted     = "encrypted"
+	StoreSecretService = "secret-service"
+
+	// EncryptedTokenFile is where the encrypted backend keeps the token.
+	EncryptedTokenFile = "token.json.enc"
+)
+
+// ErrNoToken is returned by TokenStore.Load when no token has been saved.
+var ErrNoToken = errors.New("no saved token")
+
+// TokenStore persists the OAuth token between runs.
+type TokenStore interface {
+	// Load returns the saved token, or ErrNoToken.
+	Load() (*oauth2.Token, error)
+	Save(tok *oauth2.Token) error
+	// Delete removes the saved token. Deleting a missing token is not an error.
+	Delete() error
+	// String describes where the token is kept, for messages.
+	String() string
+}
+
+// NewTokenStore returns the token store selected in the config. When a store
+// other than the plaintext file is selected and a plaintext token.json still
+// exists, the token is moved into the new store and the file is removed.
+func NewTokenStore(cfg *config.Config) (TokenStore, error) {
+	xdgConfigBase, err := GetXdgHome()
+	if err != nil {
+		return nil, err
+	}
+	plain := &FileStore{Path: filepath.Join(xdgConfigBase, TokenFile)}
+
+	backend := StoreFile
+	if cfg != nil && cfg.TokenStore != "" {
+		backend = cfg.TokenStore
+	}
+
+	var store TokenStore
+	switch backend {
+	case StoreFile:
+		return plain, nil
+	case StoreEncrypted:
+		passphraseCommand := ""
+		if cfg != nil {
+			passphraseCommand = cfg.TokenPassphraseCommand
+		}
+		store = &EncryptedFileStore{
+			Path:       filepath.Join(xdgConfigBase, EncryptedTokenFile),
+			Passphrase: passphraseSource(passphraseCommand),
+		}
+	case StoreSecretService:
+		store = &SecretServiceStore{Account: paths.Profile()}
+	default:
+		return nil, fmt.Errorf("unknown token store '%s' (want %s, %s or %s)", backend, StoreFile, StoreEncrypted, StoreSecretService)
+	}
+
+	if err := migrateToken(plain, store); err != nil {
+		return nil, fmt.Errorf("failed to migrate %s to %s: %w", plain, store, err)
+	}
+	return store, nil
+}
+
+// migrateToken moves a token from one store to another if the source has one.
+func migrateToken(from *FileStore, to TokenStore) error {
+	if _, err := os.Stat(from.Path); err != nil {
+		return nil // nothing to migrate
+	}
+	tok, err := from.Load()
+	if err != nil {
+		return err
+	}
+	if err := to.Save(tok); err != nil {
+		return err
+	}
+	if err := from.Delete(); err != nil {
+		return err
+	}
+	slog.Info("migrated OAuth token", "from", from.String(), "to", to.String())
+	return nil
+}
+
+// FileStore keeps the token as plaintext JSON, readable only by the owner.
+type FileStore struct {
+	Path string
+}
+
+func (s *FileStore) Load() (*oauth2.Token, error) {
+	tok, err := tokenFromFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoToken
+	}
+	return tok, err
+}
+
+func (s *FileStore) Save(tok *oauth2.Token) error {
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return fmt.Errorf("failed to encode token: %w", err)
+	}
+	return writeFileAtomic(s.Path, b)
+}
+
+func (s *FileStore) Delete() error {
+	if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *FileStore) String() string { return s.Path }
+
+// writeFileAtomic writes data to a temporary file next to path and renames it
+// into place, so a crash never leaves a truncated token behind.
+func writeFileAtomic(path string, data []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return fmt.Errorf("could not create token directory %s: %w", dir, err)
+	}
+	f, err := os.CreateTemp(dir, filepath.Base(path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("unable to cache OAuth token to %s: %w", path, err)
+	}
+	defer os.Remove(f.Name()) // no-op after a successful rename
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("unable to cache OAuth token to %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// persistingTokenSource saves every new token (e.g. after a refresh) to the store.
+type persistingTokenSource struct {
+	base  oauth2.TokenSource
+	store TokenStore
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+func (s *persistingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.base.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// Compare access tokens for simplicity; a refresh always changes it. It's
+	// rare but possible for the RefreshToken itself to change, so always
+	// saving the whole token is safest.
+	if s.last == nil || tok.AccessToken != s.last.AccessToken || tok.RefreshToken != s.last.RefreshToken {
+		if err := s.store.Save(tok); err != nil {
+			slog.Warn("could not save refreshed token", "store", s.store.String(), "error", err)
+		} else {
+			s.last = tok
+		}
+	}
+	return tok, nil
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+	"golang.org/x/oauth2"
+	"golang.org/x/term"
+)
+
+// PassphraseEnv names the environment variable holding the passphrase of the
+// encrypted token store.
+const PassphraseEnv = "TASKA_TOKEN_PASSPHRASE"
+
+// scrypt parameters recommended for interactive logins (2^15, 8, 1).
+const (
+	scryptN      = 1 << 15
+	scryptR      = 8
+	scryptP      = 1
+	scryptKeyLen = 32 // AES-256
+	saltLen      = 16
+)
+
+// encryptedToken is the on-disk format of the encrypted token store.
+type encryptedToken struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	N          int    `json:"n"`
+	R          int    `json:"r"`
+	P          int    `json:"p"`
+	Salt       []byte `json:"salt"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// EncryptedFileStore keeps the token in a file encrypted with AES-256-GCM under
+// a key derived from a passphrase with scrypt.
+type EncryptedFileStore struct {
+	Path       string
+	Passphrase func() ([]byte, error)
+}
+
+func (s *EncryptedFileStore) Load() (*oauth2.Token, error) {
+	b, err := os.ReadFile(s.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoToken
+	}
+	if err != nil {
+		return nil, err
+	}
+	var env encryptedToken
+	if err := json.Unmarshal(b, &env); err != nil {
+		return nil, fmt.Errorf("failed to decode encrypted token file %s: %w", s.Path, err)
+	}
+	if env.Version != 1 || env.KDF != "scrypt" {
+		return nil, fmt.Errorf("unsupported encrypted token format (version %d, kdf %s)", env.Version, env.KDF)
+	}
+
+	passphrase, err := s.Passphrase()
+	if err != nil {
+		return nil, err
+	}
+	key, err := scrypt.Key(passphrase, env.Salt, env.N, env.R, env.P, scryptKeyLen)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := aead.Open(nil, env.Nonce, env.Ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not decrypt %s: wrong passphrase or corrupted file", s.Path)
+	}
+
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal(plaintext, tok); err != nil {
+		return nil, fmt.Errorf("failed to decode token from file %s: %w", s.Path, err)
+	}
+	return tok, nil
+}
+
+func (s *EncryptedFileStore) Save(tok *oauth2.Token) error {
+	plaintext, err := json.Marshal(tok)
+	if err != nil {
+		return fmt.Errorf("failed to encode token: %w", err)
+	}
+	passphrase, err := s.Passphrase()
+	if err != nil {
+		return err
+	}
+
+	env := encryptedToken{Version: 1, KDF: "scrypt", N: scryptN, R: scryptR, P: scryptP}
+	env.Salt = make([]byte, saltLen)
+	if _, err := rand.Read(env.Salt); err != nil {
+		return err
+	}
+	key, err := scrypt.Key(passphrase, env.Salt, env.N, env.R, env.P, scryptKeyLen)
+	if err != nil {
+		return err
+	}
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	env.Nonce = make([]byte, aead.NonceSize())
+	if _, err := rand.Read(env.Nonce); err != nil {
+		return err
+	}
+	env.Ciphertext = aead.Seal(nil, env.Nonce, plaintext, nil)
+
+	b, err := json.Marshal(env)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(s.Path, b)
+}
+
+func (s *EncryptedFileStore) Delete() error {
+	if err := os.Remove(s.Path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+func (s *EncryptedFileStore) String() string { return s.Path + " (encrypted)" }
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// passphraseSource returns a function that obtains the passphrase once per
+// process from $TASKA_TOKEN_PASSPHRASE, from the output of command, or, when
+// running interactively, from a terminal prompt. The background sync has no
+// terminal, so one of the first two must be set up for it.
+func passphraseSource(command string) func() ([]byte, error) {
+	var (
+		once       sync.Once
+		passphrase []byte
+		err        error
+	)
+	return func() ([]byte, error) {
+		once.Do(func() {
+			passphrase, err = readPassphrase(command)
+		})
+		return passphrase, err
+	}
+}
+
+func readPassphrase(command string) ([]byte, error) {
+	if p := os.Getenv(PassphraseEnv); p != "" {
+		return []byte(p), nil
+	}
+	if command != "" {
+		out, err := exec.Command("sh", "-c", command).Output()
+		if err != nil {
+			return nil, fmt.Errorf("token passphrase command failed: %w", err)
+		}
+		p := bytes.TrimRight(out, "\r\n")
+		if len(p) == 0 {
+			return nil, fmt.Errorf("token passphrase command printed nothing")
+		}
+		return p, nil
+	}
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, "Token passphrase: ")
+		p, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if err != nil {
+			return nil, err
+		}
+		if len(p) == 0 {
+			return nil, fmt.Errorf("empty token passphrase")
+		}
+		return p, nil
+	}
+	return nil, fmt.Errorf("no token passphrase: set %s or token_passphrase_command", PassphraseEnv)
+}
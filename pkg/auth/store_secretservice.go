@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/harrisonrobin/taska/pkg/paths"
+	"golang.org/x/oauth2"
+)
+
+// Secret Service D-Bus API (https://specifications.freedesktop.org/secret-service/).
+const (
+	ssBusName       = "org.freedesktop.secrets"
+	ssServicePath   = "/org/freedesktop/secrets"
+	ssService       = "org.freedesktop.Secret.Service"
+	ssCollection    = "org.freedesktop.Secret.Collection"
+	ssItem          = "org.freedesktop.Secret.Item"
+	ssSession       = "org.freedesktop.Secret.Session"
+	ssPrompt        = "org.freedesktop.Secret.Prompt"
+	ssNoPrompt      = dbus.ObjectPath("/")
+	ssPromptTimeout = 2 * time.Minute
+)
+
+// ssSecret is the Secret struct of the Secret Service API, (oayays).
+type ssSecret struct {
+	Session     dbus.ObjectPath
+	Parameters  []byte
+	Value       []byte
+	ContentType string
+}
+
+// SecretServiceStore keeps the token in the user's keyring (GNOME Keyring,
+// KWallet, KeePassXC...) through the freedesktop Secret Service over D-Bus.
+type SecretServiceStore struct {
+	// Account distinguishes tokens of different profiles.
+	Account string
+}
+
+func (s *SecretServiceStore) attributes() map[string]string {
+	return map[string]string{
+		"application": paths.AppName,
+		"type":        "oauth-token",
+		"account":     s.Account,
+	}
+}
+
+func (s *SecretServiceStore) Load() (*oauth2.Token, error) {
+	sess, err := openSecretSession()
+	if err != nil {
+		return nil, err
+	}
+	defer sess.close()
+
+	items, err := sess.search(s.attributes())
+	if err != nil {
+		return nil, err
+	}
+	if len(items) == 0 {
+		return nil, ErrNoToken
+	}
+
+	var secret ssSecret
+	if err := sess.conn.Object(ssBusName, items[0]).Call(ssItem+".GetSecret", 0, sess.path).Store(&secret); err != nil {
+		return nil, fmt.Errorf("could not read token from the Secret Service: %w", err)
+	}
+	tok := &oauth2.Token{}
+	if err := json.Unmarshal(secret.Value, tok); err != nil {
+		return nil, fmt.Errorf("failed to decode token from the Secret Service: %w", err)
+	}
+	return tok, nil
+}
+
+func (s *SecretServiceStore) Save(tok *oauth2.Token) error {
+	value, err := json.Marshal(tok)
+	if err != nil {
+		return fmt.Errorf("failed to encode token: %w", err)
+	}
+
+	sess, err := openSecretSession()
+	if err != nil {
+		return err
+	}
+	defer sess.close()
+
+	var collection dbus.ObjectPath
+	if err := sess.service.Call(ssService+".ReadAlias", 0, "default").Store(&collection); err != nil {
+		return fmt.Errorf("could not find the default keyring: %w", err)
+	}
+	if collection == ssNoPrompt {
+		return fmt.Errorf("the Secret Service has no default keyring")
+	}
+	if err := sess.unlock([]dbus.ObjectPath{collection}); err != nil {
+		return err
+	}
+
+	props := map[string]dbus.Variant{
+		ssItem + ".Label":      dbus.MakeVariant("taska OAuth token (" + s.Account + ")"),
+		ssItem + ".Attributes": dbus.MakeVariant(s.attributes()),
+	}
+	secret := ssSecret{Session: sess.path, Parameters: []byte{}, Value: value, ContentType: "application/json"}
+	var item, prompt dbus.ObjectPath
+	err = sess.conn.Object(ssBusName, collection).
+		Call(ssCollection+".CreateItem", 0, props, secret, true).
+		Store(&item, &prompt)
+	if err != nil {
+		return fmt.Errorf("could not save token to the Secret Service: %w", err)
+	}
+	return sess.prompt(prompt)
+}
+
+func (s *SecretServiceStore) Delete() error {
+	sess, err := openSecretSession()
+	if err != nil {
+		return err
+	}
+	defer sess.close()
+
+	items, err := sess.search(s.attributes())
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		var prompt dbus.ObjectPath
+		if err := sess.conn.Object(ssBusName, item).Call(ssItem+".Delete", 0).Store(&prompt); err != nil {
+			return fmt.Errorf("could not delete token from the Secret Service: %w", err)
+		}
+		if err := sess.prompt(prompt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *SecretServiceStore) String() string {
+	return "Secret Service (account " + s.Account + ")"
+}
+
+// secretSession is an open Secret Service session using the "plain"
+// algorithm; the secret never leaves the local D-Bus session bus.
+type secretSession struct {
+	conn    *dbus.Conn
+	service dbus.BusObject
+	path    dbus.ObjectPath
+}
+
+func openSecretSession() (*secretSession, error) {
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to the D-Bus session bus: %w", err)
+	}
+	service := conn.Object(ssBusName, ssServicePath)
+	var output dbus.Variant
+	var path dbus.ObjectPath
+	if err := service.Call(ssService+".OpenSession", 0, "plain", dbus.MakeVariant("")).Store(&output, &path); err != nil {
+		return nil, fmt.Errorf("could not open a Secret Service session: %w", err)
+	}
+	return &secretSession{conn: conn, service: service, path: path}, nil
+}
+
+func (s *secretSession) close() {
+	s.conn.Object(ssBusName, s.path).Call(ssSession+".Close", 0)
+}
+
+// search returns the items matching attrs, unlocking locked ones.
+func (s *secretSession) search(attrs map[string]string) ([]dbus.ObjectPath, error) {
+	var unlocked, locked []dbus.ObjectPath
+	if err := s.service.Call(ssService+".SearchItems", 0, attrs).Store(&unlocked, &locked); err != nil {
+		return nil, fmt.Errorf("could not search the Secret Service: %w", err)
+	}
+	if len(locked) > 0 {
+		if err := s.unlock(locked); err != nil {
+			return nil, err
+		}
+		unlocked = append(unlocked, locked...)
+	}
+	return unlocked, nil
+}
+
+func (s *secretSession) unlock(objects []dbus.ObjectPath) error {
+	var unlocked []dbus.ObjectPath
+	var prompt dbus.ObjectPath
+	if err := s.service.Call(ssService+".Unlock", 0, objects).Store(&unlocked, &prompt); err != nil {
+		return fmt.Errorf("could not unlock the keyring: %w", err)
+	}
+	return s.prompt(prompt)
+}
+
+// prompt shows a Secret Service prompt (e.g. the keyring password dialog) and
+// waits for the user to complete it.
+func (s *secretSession) prompt(path dbus.ObjectPath) error {
+	if path == ssNoPrompt || path == "" {
+		return nil
+	}
+	match := []dbus.MatchOption{
+		dbus.WithMatchObjectPath(path),
+		dbus.WithMatchInterface(ssPrompt),
+		dbus.WithMatchMember("Completed"),
+	}
+	if err := s.conn.AddMatchSignal(match...); err != nil {
+		return err
+	}
+	defer s.conn.RemoveMatchSignal(match...)
+	signals := make(chan *dbus.Signal, 1)
+	s.conn.Signal(signals)
+	defer s.conn.RemoveSignal(signals)
+
+	if err := s.conn.Object(ssBusName, path).Call(ssPrompt+".Prompt", 0, "").Err; err != nil {
+		return fmt.Errorf("could not show the keyring prompt: %w", err)
+	}
+
+	timeout := time.After(ssPromptTimeout)
+	for {
+		select {
+		case sig := <-signals:
+			if sig.Path != path || sig.Name != ssPrompt+".Completed" || len(sig.Body) == 0 {
+				continue
+			}
+			if dismissed, _ := sig.Body[0].(bool); dismissed {
+				return fmt.Errorf("the keyring prompt was dismissed")
+			}
+			return nil
+		case <-timeout:
+			return fmt.Errorf("timed out waiting for the keyring prompt")
+		}
+	}
+}
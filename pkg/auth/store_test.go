@@ -0,0 +1,89 @@
+package auth
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+func staticPassphrase(p string) func() ([]byte, error) {
+	return func() ([]byte, error) { return []byte(p), nil }
+}
+
+func TestEncryptedFileStoreRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), EncryptedTokenFile)
+	store := &EncryptedFileStore{Path: path, Passphrase: staticPassphrase("correct horse")}
+
+	if _, err := store.Load(); !errors.Is(err, ErrNoToken) {
+		t.Fatalf("Load before Save: got %v, want ErrNoToken", err)
+	}
+
+	want := &oauth2.Token{AccessToken: "access", RefreshToken: "refresh-secret", Expiry: time.Now().Add(time.Hour).Round(time.Second)}
+	if err := store.Save(want); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "refresh-secret") {
+		t.Error("refresh token is stored in plaintext")
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	got, err := store.Load()
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if got.AccessToken != want.AccessToken || got.RefreshToken != want.RefreshToken || !got.Expiry.Equal(want.Expiry) {
+		t.Errorf("Load = %+v, want %+v", got, want)
+	}
+
+	wrong := &EncryptedFileStore{Path: path, Passphrase: staticPassphrase("wrong")}
+	if _, err := wrong.Load(); err == nil {
+		t.Error("Load with the wrong passphrase succeeded")
+	}
+
+	if err := store.Delete(); err != nil {
+		t.Fatalf("Delete: %v", err)
+	}
+	if err := store.Delete(); err != nil {
+		t.Errorf("Delete of a missing token: %v", err)
+	}
+}
+
+func TestMigrateToken(t *testing.T) {
+	dir := t.TempDir()
+	plain := &FileStore{Path: filepath.Join(dir, TokenFile)}
+	encrypted := &EncryptedFileStore{Path: filepath.Join(dir, EncryptedTokenFile), Passphrase: staticPassphrase("pw")}
+
+	// Nothing to migrate
+	if err := migrateToken(plain, encrypted); err != nil {
+		t.Fatalf("migrate without token: %v", err)
+	}
+
+	if err := plain.Save(&oauth2.Token{RefreshToken: "refresh"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrateToken(plain, encrypted); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+	if _, err := os.Stat(plain.Path); !os.IsNotExist(err) {
+		t.Error("plaintext token was not removed after migration")
+	}
+	tok, err := encrypted.Load()
+	if err != nil {
+		t.Fatalf("Load migrated token: %v", err)
+	}
+	if tok.RefreshToken != "refresh" {
+		t.Errorf("migrated refresh token = %q", tok.RefreshToken)
+	}
+}
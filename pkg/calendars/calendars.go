@@ -0,0 +1,86 @@
+// Package calendars prints the calendar and event listings of `taska
+// calendars`.
+package calendars
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/google"
+	"github.com/harrisonrobin/taska/pkg/index"
+	"google.golang.org/api/calendar/v3"
+)
+
+// PrintList writes the calendars as a table, marking the one with the ID
+// current with a '*'.
+func PrintList(w io.Writer, list []*calendar.CalendarListEntry, current string) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tNAME\tID\tACCESS\tTIME ZONE\tCOLOUR")
+	for _, c := range list {
+		mark := ""
+		if c.Id == current {
+			mark = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\n", mark, c.Summary, c.Id, c.AccessRole, c.TimeZone, c.BackgroundColor)
+	}
+	return tw.Flush()
+}
+
+// Writable returns an error unless taska can write to the calendar. A
+// calendar found outside the calendar list has no access role to check.
+func Writable(entry *calendar.CalendarListEntry) error {
+	switch entry.AccessRole {
+	case "", "owner", "writer":
+		return nil
+	}
+	return fmt.Errorf("calendar '%s' is %s-only; taska needs to write to it", entry.Summary, entry.AccessRole)
+}
+
+// SortByStart orders events by their start, all-day events by their date.
+func SortByStart(events []*calendar.Event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventStart(events[i]) < eventStart(events[j])
+	})
+}
+
+// PrintEvents writes the events as a table with the task and role of those
+// taska manages, or "No events.".
+func PrintEvents(w io.Writer, events []*calendar.Event) error {
+	if len(events) == 0 {
+		_, err := fmt.Fprintln(w, "No events.")
+		return err
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "START\tSUMMARY\tEVENT\tTASK\tROLE")
+	for _, e := range events {
+		task, role := google.EventTaskID(e), ""
+		if task != "" {
+			role = index.RoleName(google.EventRole(e))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n", FormatStart(e), e.Summary, e.Id, task, role)
+	}
+	return tw.Flush()
+}
+
+// eventStart returns an event's start as written, a date or an RFC 3339 time.
+func eventStart(e *calendar.Event) string {
+	if e.Start == nil {
+		return ""
+	}
+	if e.Start.DateTime != "" {
+		return e.Start.DateTime
+	}
+	return e.Start.Date
+}
+
+// FormatStart formats an event's start in local time, or as its date.
+func FormatStart(e *calendar.Event) string {
+	start := eventStart(e)
+	if t, err := time.Parse(time.RFC3339, start); err == nil {
+		return t.Local().Format("2006-01-02 15:04")
+	}
+	return start
+}
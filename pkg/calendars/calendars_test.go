@@ -0,0 +1,77 @@
+package calendars
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/harrisonrobin/taska/pkg/util"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestPrintList(t *testing.T) {
+	list := []*calendar.CalendarListEntry{
+		{Id: "me@example.com", Summary: "Me", AccessRole: "owner", TimeZone: "Europe/Berlin", BackgroundColor: "#9fe1e7"},
+		{Id: "tasks@group.calendar.google.com", Summary: "Tasks", AccessRole: "writer"},
+	}
+	var out strings.Builder
+	if err := PrintList(&out, list, "tasks@group.calendar.google.com"); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d lines:\n%s", len(lines), out.String())
+	}
+	if strings.HasPrefix(lines[1], "*") || !strings.Contains(lines[1], "Europe/Berlin") {
+		t.Errorf("line for Me: %q", lines[1])
+	}
+	if !strings.HasPrefix(lines[2], "*") || !strings.Contains(lines[2], "tasks@group.calendar.google.com") {
+		t.Errorf("the calendar in use is not marked: %q", lines[2])
+	}
+}
+
+func TestWritable(t *testing.T) {
+	for role, ok := range map[string]bool{"owner": true, "writer": true, "": true, "reader": false, "freeBusyReader": false} {
+		err := Writable(&calendar.CalendarListEntry{Summary: "Team", AccessRole: role})
+		if (err == nil) != ok {
+			t.Errorf("%q: got %v", role, err)
+		}
+	}
+}
+
+func TestPrintEvents(t *testing.T) {
+	deadline := &calendar.Event{Id: "ev2", Summary: "Due: Write report", Start: &calendar.EventDateTime{DateTime: "2026-03-02T17:00:00Z"},
+		ExtendedProperties: &calendar.EventExtendedProperties{Private: map[string]string{util.TaskIDProperty: "u1", util.RoleProperty: "deadline"}}}
+	work := &calendar.Event{Id: "ev1", Summary: "Write report", Start: &calendar.EventDateTime{DateTime: "2026-03-02T09:00:00Z"},
+		ExtendedProperties: &calendar.EventExtendedProperties{Private: map[string]string{util.TaskIDProperty: "u1"}}}
+	holiday := &calendar.Event{Id: "ev3", Summary: "Holiday", Start: &calendar.EventDateTime{Date: "2026-03-01"}}
+
+	events := []*calendar.Event{deadline, work, holiday}
+	SortByStart(events)
+	if events[0] != holiday || events[1] != work || events[2] != deadline {
+		t.Errorf("not sorted by start: %s %s %s", events[0].Id, events[1].Id, events[2].Id)
+	}
+
+	var out strings.Builder
+	if err := PrintEvents(&out, events); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("got %d lines:\n%s", len(lines), out.String())
+	}
+	if !strings.HasPrefix(lines[1], "2026-03-01 ") || strings.Contains(lines[1], "u1") {
+		t.Errorf("all-day event without a task: %q", lines[1])
+	}
+	if !strings.Contains(lines[2], "u1") || !strings.Contains(lines[2], "primary") {
+		t.Errorf("primary event: %q", lines[2])
+	}
+	if !strings.Contains(lines[3], "deadline") {
+		t.Errorf("deadline marker: %q", lines[3])
+	}
+
+	out.Reset()
+	PrintEvents(&out, nil)
+	if out.String() != "No events.\n" {
+		t.Errorf("empty listing: %q", out.String())
+	}
+}
@@ -3,10 +3,10 @@ package colors
 import (
 	"encoding/json"
 	"log"
-	"os"
-	"path/filepath"
 	"strconv"
 	"time"
+
+	"github.com/harrisonrobin/taska/pkg/state"
 )
 
 type ProjectState struct {
@@ -15,68 +15,70 @@ type ProjectState struct {
 	LastModified time.Time `json:"last_modified"`
 }
 
+// ColorCache assigns colours to projects. It is loaded from the state store
+// when created; Save writes back only the projects changed since.
 type ColorCache struct {
-	Path     string
-	Projects map[string]*ProjectState `json:"projects"`
-	dirty    bool
+	Projects map[string]*ProjectState
+	changed  map[string]bool
 }
 
-const (
-	xdgAppName = "taska"
-	cacheFile  = "project_colors.json"
-)
-
 func NewColorCache() (*ColorCache, error) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return nil, err
-	}
-	path := filepath.Join(home, ".config", xdgAppName, cacheFile)
-
 	cache := &ColorCache{
-		Path:     path,
 		Projects: make(map[string]*ProjectState),
+		changed:  make(map[string]bool),
 	}
-
-	if _, err := os.Stat(path); err == nil {
-		if err := cache.Load(); err != nil {
-			return nil, err
-		}
+	if err := cache.Load(); err != nil {
+		return nil, err
 	}
 	return cache, nil
 }
 
 func (c *ColorCache) Load() error {
-	f, err := os.Open(c.Path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	return json.NewDecoder(f).Decode(&c.Projects)
+	return state.View(func(tx *state.Tx) error {
+		return tx.ForEach(state.Colors, func(project string, value json.RawMessage) error {
+			var s ProjectState
+			if err := json.Unmarshal(value, &s); err != nil {
+				return err
+			}
+			c.Projects[project] = &s
+			return nil
+		})
+	})
 }
 
 func (c *ColorCache) Save() error {
-	if !c.dirty {
+	if len(c.changed) == 0 {
 		return nil
 	}
-	// ensure directory exists
-	dir := filepath.Dir(c.Path)
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		log.Printf("Error creating color cache directory: %v", err)
-		return err
-	}
-
-	f, err := os.Create(c.Path)
+	err := state.Update(func(tx *state.Tx) error {
+		for project := range c.changed {
+			s, exists := c.Projects[project]
+			var err error
+			if exists {
+				err = tx.Put(state.Colors, project, s)
+			} else {
+				err = tx.Delete(state.Colors, project)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
-		log.Printf("Error creating color cache file: %v", err)
+		log.Printf("Error saving color cache: %v", err)
 		return err
 	}
-	defer f.Close()
-	err = json.NewEncoder(f).Encode(c.Projects)
-	if err == nil {
-		c.dirty = false
+	clear(c.changed)
+	return nil
+}
+
+// Remove forgets a project's colour, freeing it for other projects.
+func (c *ColorCache) Remove(project string) {
+	if _, exists := c.Projects[project]; exists {
+		delete(c.Projects, project)
+		c.changed[project] = true
 	}
-	return err
 }
 
 // GetColorID returns the color ID for a project, managing LRU logic.
@@ -86,14 +88,14 @@ func (c *ColorCache) GetColorID(project string, isTaskActive bool) string {
 		return "14" // Default gray for no project
 	}
 
-	state, exists := c.Projects[project]
+	ps, exists := c.Projects[project]
 	if exists {
 		// Just updating LastModified doesn't necessarily need a sync Save
 		// unless we are VERY concerned about perfect LRU on crash.
-		// For performance, we'll mark dirty but NOT call Save() here.
-		state.LastModified = time.Now()
-		c.dirty = true
-		return state.ColorID
+		// For performance, we'll mark it changed but NOT call Save() here.
+		ps.LastModified = time.Now()
+		c.changed[project] = true
+		return ps.ColorID
 	}
 
 	// New Project
@@ -116,7 +118,7 @@ func (c *ColorCache) assignColor(project string) string {
 				LastModified: time.Now(),
 				ActiveTasks:  1,
 			}
-			c.dirty = true
+			c.changed[project] = true
 			return id
 		}
 	}
@@ -137,13 +139,14 @@ func (c *ColorCache) assignColor(project string) string {
 	if oldestProject != "" {
 		recycledColor := c.Projects[oldestProject].ColorID
 		delete(c.Projects, oldestProject)
+		c.changed[oldestProject] = true
 
 		c.Projects[project] = &ProjectState{
 			ColorID:      recycledColor,
 			LastModified: time.Now(),
 			ActiveTasks:  1,
 		}
-		c.dirty = true
+		c.changed[project] = true
 		return recycledColor
 	}
 
@@ -0,0 +1,167 @@
+package colors
+
+import (
+	"hash/fnv"
+	"log/slog"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+)
+
+// Google Calendar event colours used by the default mappings.
+const (
+	Banana    = "5"
+	Tangerine = "6"
+	Graphite  = "8"
+	Tomato    = "11"
+)
+
+// eventColors is the number of Google Calendar event colours, "1" to "11".
+const eventColors = 11
+
+var (
+	defaultPriorities = map[string]string{"H": Tomato, "M": Tangerine, "L": Banana}
+	defaultUrgency    = []config.UrgencyColor{{Min: 10, ColorID: Tomato}, {Min: 6, ColorID: Tangerine}, {Min: 3, ColorID: Banana}}
+	defaultStatuses   = map[string]string{"completed": Graphite}
+)
+
+// Pick returns the colour of a task's event from the configured strategies,
+// or "" to keep the calendar's colour.
+func Pick(task *taskwarrior.Task, cfg *config.Config) string {
+	var settings config.ColorConfig
+	if cfg != nil {
+		settings = cfg.Colors
+	}
+	for _, strategy := range cfg.ColorStrategies() {
+		if id := pick(strategy, task, settings); id != "" {
+			return id
+		}
+	}
+	return ""
+}
+
+func pick(strategy string, task *taskwarrior.Task, settings config.ColorConfig) string {
+	switch strategy {
+	case config.ColorLRU:
+		cache, err := Shared()
+		if err != nil {
+			slog.Warn("could not load colour cache", "error", err)
+			return ""
+		}
+		isActive := task.Status == "pending" || task.Status == "waiting" // broad definition
+		return cache.GetColorID(task.Project, isActive)
+	case config.ColorHash:
+		return hashColor(task.Project)
+	case config.ColorProject:
+		// The deepest mapped project wins: "Work.Client" before "Work"
+		for project := task.Project; project != ""; {
+			if id, ok := settings.Projects[project]; ok {
+				return id
+			}
+			i := strings.LastIndex(project, ".")
+			if i < 0 {
+				break
+			}
+			project = project[:i]
+		}
+	case config.ColorPriority:
+		return orDefault(settings.Priorities, defaultPriorities)[strings.ToUpper(task.Priority)]
+	case config.ColorUrgency:
+		return urgencyColor(task.UrgencyAt(time.Now()), settings.Urgency)
+	case config.ColorTag:
+		for _, tag := range task.Tags {
+			if id, ok := settings.Tags[tag]; ok {
+				return id
+			}
+		}
+	case config.ColorStatus:
+		return orDefault(settings.Statuses, defaultStatuses)[taskState(task)]
+	default:
+		slog.Warn("unknown colour strategy", "strategy", strategy)
+	}
+	return ""
+}
+
+// hashColor spreads projects over the event colours by a hash of the name,
+// so a project keeps its colour however many projects there are.
+func hashColor(project string) string {
+	if project == "" {
+		return ""
+	}
+	h := fnv.New32a()
+	h.Write([]byte(project))
+	return strconv.Itoa(int(h.Sum32()%eventColors) + 1)
+}
+
+func urgencyColor(urgency float64, buckets []config.UrgencyColor) string {
+	if len(buckets) == 0 {
+		buckets = defaultUrgency
+	}
+	sorted := append([]config.UrgencyColor(nil), buckets...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Min > sorted[j].Min })
+	for _, b := range sorted {
+		if urgency >= b.Min {
+			return b.ColorID
+		}
+	}
+	return ""
+}
+
+// taskState is the status a task is coloured by, "started" for an active
+// pending task.
+func taskState(task *taskwarrior.Task) string {
+	if task.Status == taskwarrior.PENDING && task.Start != nil && !task.Start.IsZero() {
+		return "started"
+	}
+	return task.Status
+}
+
+func orDefault(m, fallback map[string]string) map[string]string {
+	if len(m) == 0 {
+		return fallback
+	}
+	return m
+}
+
+var (
+	sharedMu  sync.Mutex
+	shared    *ColorCache
+	errShared error
+	loaded    bool
+)
+
+// Shared returns the colour cache the "lru" strategy uses, loaded on first
+// use. All events rendered by a run share it, and SaveShared writes it back
+// once at the end.
+func Shared() (*ColorCache, error) {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if !loaded {
+		shared, errShared = NewColorCache()
+		loaded = true
+	}
+	return shared, errShared
+}
+
+// ReloadShared makes the next Shared call read the colour cache again, so a
+// long-running process sees the colours hook runs assigned in the meantime.
+func ReloadShared() {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	shared, errShared, loaded = nil, nil, false
+}
+
+// SaveShared saves the shared colour cache, if it was loaded.
+func SaveShared() error {
+	sharedMu.Lock()
+	defer sharedMu.Unlock()
+	if shared == nil {
+		return nil
+	}
+	return shared.Save()
+}
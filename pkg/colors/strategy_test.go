@@ -0,0 +1,100 @@
+package colors
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+)
+
+func TestPick(t *testing.T) {
+	cfg := &config.Config{Colors: config.ColorConfig{
+		Projects: map[string]string{"Work": "9", "Work.Client": "3"},
+		Tags:     map[string]string{"call": "2", "urgent": "4"},
+		Urgency:  []config.UrgencyColor{{Min: 2, ColorID: "7"}, {Min: 8, ColorID: "11"}},
+	}}
+	tests := []struct {
+		name     string
+		strategy string
+		task     taskwarrior.Task
+		want     string
+	}{
+		{"project", "project", taskwarrior.Task{Project: "Work"}, "9"},
+		{"sub-project", "project", taskwarrior.Task{Project: "Work.Client.Acme"}, "3"},
+		{"unmapped project", "project", taskwarrior.Task{Project: "Home"}, ""},
+		{"priority default", "priority", taskwarrior.Task{Priority: "h"}, Tomato},
+		{"first mapped tag", "tag", taskwarrior.Task{Tags: []string{"home", "urgent", "call"}}, "4"},
+		{"urgency bucket", "urgency", taskwarrior.Task{Urgency: 9.5}, "11"},
+		{"low urgency bucket", "urgency", taskwarrior.Task{Urgency: 2.1}, "7"},
+		{"completed is grey", "status", taskwarrior.Task{Status: taskwarrior.COMPLETED}, Graphite},
+		{"pending keeps the calendar colour", "status", taskwarrior.Task{Status: taskwarrior.PENDING}, ""},
+		{"fallback chain", "status, project, hash", taskwarrior.Task{Status: taskwarrior.PENDING, Project: "Work"}, "9"},
+		{"hash without project", "hash", taskwarrior.Task{}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg.Colors.Strategy = tt.strategy
+			if got := Pick(&tt.task, cfg); got != tt.want {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHashColorIsStable(t *testing.T) {
+	seen := make(map[string]bool)
+	for _, project := range []string{"Work", "Home", "Garden", "Taxes", "Reading", "Music", "Sport", "Travel", "Car", "House", "Kids", "Health"} {
+		id := hashColor(project)
+		if id != hashColor(project) {
+			t.Fatalf("%s: colour changed", project)
+		}
+		if n, err := strconv.Atoi(id); err != nil || n < 1 || n > eventColors {
+			t.Errorf("%s: %q is not an event colour", project, id)
+		}
+		seen[id] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("every project got the same colour")
+	}
+}
+
+func TestReloadShared(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	t.Cleanup(ReloadShared)
+	ReloadShared()
+
+	first, err := Shared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := first.GetColorID("Work", true)
+	if err := SaveShared(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Another process assigns a colour to a second project
+	other, err := NewColorCache()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherID := other.GetColorID("Home", true)
+	if err := other.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	if again, _ := Shared(); again != first {
+		t.Fatal("Shared reloaded the cache without ReloadShared")
+	}
+	ReloadShared()
+	reloaded, err := Shared()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := reloaded.Projects["Home"]; got == nil || got.ColorID != otherID {
+		t.Errorf("reloaded cache misses the other process's colour: %+v", got)
+	}
+	if got := reloaded.GetColorID("Work", true); got != id {
+		t.Errorf("Work changed colour from %s to %s", id, got)
+	}
+}
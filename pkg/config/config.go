@@ -5,23 +5,197 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+
+	"github.com/harrisonrobin/taska/pkg/paths"
 )
 
+const configFile = "config.json"
+
+type Config struct {
+	Calendar  string         `json:"calendar"`
+	Durations DurationConfig `json:"durations,omitempty"`
+	Reminders []ReminderRule `json:"reminders,omitempty"`
+	Deadlines DeadlineConfig `json:"deadline_markers,omitempty"`
+	Privacy   PrivacyConfig  `json:"privacy,omitempty"`
+	Log       LogConfig      `json:"log,omitempty"`
+	// Routes send matching tasks to other calendars or profiles.
+	Routes []RouteRule `json:"routes,omitempty"`
+	// TokenStore is "file" (default), "encrypted" or "secret-service".
+	TokenStore string `json:"token_store,omitempty"`
+	// TokenPassphraseCommand prints the passphrase of the encrypted token
+	// store, e.g. "pass show taska".
+	TokenPassphraseCommand string `json:"token_passphrase_command,omitempty"`
+	// ServiceAccount authenticates with a service account key instead of a
+	// user's OAuth token.
+	ServiceAccount ServiceAccountConfig `json:"service_account,omitempty"`
+	// ConflictPolicy decides what happens to events edited on the calendar
+	// since taska last wrote them: "task-wins" (default), "calendar-wins",
+	// "newest-wins" or "keep-both".
+	ConflictPolicy string `json:"conflict_policy,omitempty"`
+	// CreateMissingCalendar creates the calendar when no calendar has the
+	// configured name, instead of failing.
+	CreateMissingCalendar bool `json:"create_missing_calendar,omitempty"`
+	// NewCalendar describes the calendars taska creates.
+	NewCalendar NewCalendarConfig `json:"new_calendar,omitempty"`
+	// Colors chooses the colours of task events.
+	Colors ColorConfig `json:"colors,omitempty"`
+}
+
+// NewCalendarConfig holds the settings of a calendar taska creates.
+type NewCalendarConfig struct {
+	// TimeZone is an IANA time zone such as "Europe/Berlin". Without one the
+	// calendar takes the account's time zone.
+	TimeZone    string `json:"time_zone,omitempty"`
+	Description string `json:"description,omitempty"`
+}
+
+// CreatesMissingCalendar reports whether a missing calendar is created. It is
+// safe to call on a nil Config.
+func (c *Config) CreatesMissingCalendar() bool {
+	return c != nil && c.CreateMissingCalendar
+}
+
+// NewCalendarSettings returns the settings of calendars taska creates. It is
+// safe to call on a nil Config.
+func (c *Config) NewCalendarSettings() NewCalendarConfig {
+	if c == nil {
+		return NewCalendarConfig{}
+	}
+	return c.NewCalendar
+}
+
+// Conflict policies.
 const (
-	xdgAppName = "taska"
-	configFile = "config.json"
+	ConflictTaskWins     = "task-wins"
+	ConflictCalendarWins = "calendar-wins"
+	ConflictNewestWins   = "newest-wins"
+	ConflictKeepBoth     = "keep-both"
 )
 
-type Config struct {
-	Calendar string `json:"calendar"`
+// Conflicts returns the configured conflict policy. Unknown policies are
+// treated as "keep-both", which changes neither side. It is safe to call on a
+// nil Config.
+func (c *Config) Conflicts() string {
+	if c == nil || c.ConflictPolicy == "" {
+		return ConflictTaskWins
+	}
+	switch policy := strings.ToLower(c.ConflictPolicy); policy {
+	case ConflictTaskWins, ConflictCalendarWins, ConflictNewestWins, ConflictKeepBoth:
+		return policy
+	default:
+		return ConflictKeepBoth
+	}
+}
+
+// ServiceAccountConfig selects service account authentication, optionally
+// impersonating a Workspace user through domain-wide delegation.
+type ServiceAccountConfig struct {
+	// KeyFile is the service account's JSON key, relative to the config
+	// directory unless absolute.
+	KeyFile string `json:"key_file,omitempty"`
+	// Subject is the user to impersonate. Without one the service account
+	// acts as itself and only sees calendars shared with it.
+	Subject string `json:"subject,omitempty"`
+}
+
+// UsesServiceAccount reports whether a service account key is configured.
+// It is safe to call on a nil Config.
+func (c *Config) UsesServiceAccount() bool {
+	return c != nil && c.ServiceAccount.KeyFile != ""
+}
+
+// LogConfig controls the log file written to ~/.local/state/taska/taska.log.
+type LogConfig struct {
+	// Level is debug, info (default), warn or error.
+	Level string `json:"level,omitempty"`
+	// Format is text (default) or json.
+	Format string `json:"format,omitempty"`
+	// Path overrides the log file location.
+	Path string `json:"path,omitempty"`
+	// MaxSizeMB is the size at which the log is rotated (default 5).
+	MaxSizeMB int `json:"max_size_mb,omitempty"`
+	// MaxBackups is the number of rotated files kept (default 3).
+	MaxBackups int `json:"max_backups,omitempty"`
+}
+
+// Colour strategies.
+const (
+	ColorLRU      = "lru"
+	ColorHash     = "hash"
+	ColorProject  = "project"
+	ColorPriority = "priority"
+	ColorUrgency  = "urgency"
+	ColorTag      = "tag"
+	ColorStatus   = "status"
+)
+
+// ColorConfig chooses the colours of task events. The maps hold Google event
+// colour IDs, "1" (Lavender) to "11" (Tomato).
+type ColorConfig struct {
+	// Strategy is "lru" (default), "hash", "project", "priority", "urgency",
+	// "tag" or "status". Several are tried in turn when separated by commas,
+	// e.g. "status,project,hash"; a task none of them colours keeps the
+	// calendar's colour.
+	Strategy string `json:"strategy,omitempty"`
+	// Projects maps projects to colours; a project also colours its
+	// sub-projects.
+	Projects map[string]string `json:"projects,omitempty"`
+	// Priorities maps "H", "M" and "L" to colours.
+	Priorities map[string]string `json:"priorities,omitempty"`
+	// Urgency colours tasks by their urgency: the bucket with the highest Min
+	// not above it wins.
+	Urgency []UrgencyColor `json:"urgency,omitempty"`
+	// Tags maps tags to colours; the task's first mapped tag wins.
+	Tags map[string]string `json:"tags,omitempty"`
+	// Statuses maps "pending", "started", "waiting" and "completed" to
+	// colours.
+	Statuses map[string]string `json:"statuses,omitempty"`
+}
+
+// UrgencyColor is the colour of the tasks with an urgency of at least Min.
+type UrgencyColor struct {
+	Min     float64 `json:"min"`
+	ColorID string  `json:"color_id"`
+}
+
+// ColorStrategies returns the colour strategies to try in order. It is safe
+// to call on a nil Config.
+func (c *Config) ColorStrategies() []string {
+	if c == nil || strings.TrimSpace(c.Colors.Strategy) == "" {
+		return []string{ColorLRU}
+	}
+	var strategies []string
+	for _, s := range strings.Split(c.Colors.Strategy, ",") {
+		if s = strings.ToLower(strings.TrimSpace(s)); s != "" {
+			strategies = append(strategies, s)
+		}
+	}
+	return strategies
+}
+
+// Deadline marker styles.
+const (
+	DeadlineStylePoint  = "point"
+	DeadlineStyleAllDay = "all-day"
+)
+
+// DeadlineConfig controls the optional second event that marks the due date of
+// tasks that are also scheduled.
+type DeadlineConfig struct {
+	Enabled bool `json:"enabled"`
+	// Style is "point" (a zero-length event at the due time, the default) or "all-day".
+	Style string `json:"style,omitempty"`
+	// ColorID is the Google event colour of the marker, "11" (Tomato) by default.
+	ColorID string `json:"color_id,omitempty"`
 }
 
 func GetConfigPath() (string, error) {
-	xdgHome, err := os.UserHomeDir()
+	dir, err := paths.ConfigDir()
 	if err != nil {
 		return "", err
 	}
-	return filepath.Join(xdgHome, ".config", xdgAppName, configFile), nil
+	return filepath.Join(dir, configFile), nil
 }
 
 func Load() (*Config, error) {
@@ -0,0 +1,382 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+)
+
+// DefaultEventDuration is the event length used when neither the task nor any
+// configured rule provides one.
+const DefaultEventDuration = 30 * time.Minute
+
+// Duration is a time.Duration that is written to and read from JSON as a Go
+// duration string such as "15m" or "1h30m".
+type Duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface for Duration.
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"15m\": %w", err)
+	}
+	if s == "" {
+		d.Duration = 0
+		return nil
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration '%s': %w", s, err)
+	}
+	if parsed < 0 {
+		return fmt.Errorf("invalid duration '%s': must not be negative", s)
+	}
+	d.Duration = parsed
+	return nil
+}
+
+// MarshalJSON implements the json.Marshaler interface for Duration.
+func (d Duration) MarshalJSON() ([]byte, error) {
+	if d.Duration == 0 {
+		return []byte(`""`), nil
+	}
+	return json.Marshal(d.Duration.String())
+}
+
+// Selector picks the tasks a rule applies to. Every non-empty field must match.
+// Project matches the project itself and all of its sub-projects, so "Work"
+// also matches "Work.Meetings".
+type Selector struct {
+	Project  string `json:"project,omitempty"`
+	Tag      string `json:"tag,omitempty"`
+	Priority string `json:"priority,omitempty"`
+}
+
+// Matches reports whether the selector applies to the given task.
+func (s Selector) Matches(task *taskwarrior.Task) bool {
+	if task == nil {
+		return false
+	}
+	if s.Project != "" && !projectMatches(s.Project, task.Project) {
+		return false
+	}
+	if s.Priority != "" && !strings.EqualFold(s.Priority, task.Priority) {
+		return false
+	}
+	if s.Tag != "" {
+		found := false
+		for _, tag := range task.Tags {
+			if tag == s.Tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// specificity ranks selectors so that the most specific matching rule wins:
+// a tag beats a project, a project beats a priority, and a deeper project
+// beats a shallower one.
+func (s Selector) specificity() int {
+	score := 0
+	if s.Tag != "" {
+		score += 1000
+	}
+	if s.Project != "" {
+		score += 100 + strings.Count(s.Project, ".")
+	}
+	if s.Priority != "" {
+		score += 10
+	}
+	return score
+}
+
+func projectMatches(rule, project string) bool {
+	return project == rule || strings.HasPrefix(project, rule+".")
+}
+
+// DurationRule overrides the duration policy for the tasks matched by its selector.
+// Zero values leave the corresponding setting to less specific rules.
+type DurationRule struct {
+	Selector
+	Default Duration `json:"default,omitempty"`
+	Min     Duration `json:"min,omitempty"`
+	Max     Duration `json:"max,omitempty"`
+	Round   Duration `json:"round,omitempty"`
+}
+
+// DurationConfig holds the global duration settings and the per-task rules.
+type DurationConfig struct {
+	Default Duration       `json:"default,omitempty"`
+	Min     Duration       `json:"min,omitempty"`
+	Max     Duration       `json:"max,omitempty"`
+	Round   Duration       `json:"round,omitempty"`
+	Rules   []DurationRule `json:"rules,omitempty"`
+}
+
+// DurationPolicy is the resolved set of duration settings for a single task.
+type DurationPolicy struct {
+	Default time.Duration
+	Min     time.Duration
+	Max     time.Duration
+	Round   time.Duration
+}
+
+// DurationPolicy resolves the duration settings for a task. Each setting is
+// taken from the most specific matching rule that sets it, falling back to the
+// global settings and finally to DefaultEventDuration. Ties between equally
+// specific rules go to the one listed first. It is safe to call on a nil Config.
+func (c *Config) DurationPolicy(task *taskwarrior.Task) DurationPolicy {
+	policy := DurationPolicy{Default: DefaultEventDuration}
+	if c == nil {
+		return policy
+	}
+
+	global := c.Durations
+	if global.Default.Duration > 0 {
+		policy.Default = global.Default.Duration
+	}
+	policy.Min = global.Min.Duration
+	policy.Max = global.Max.Duration
+	policy.Round = global.Round.Duration
+
+	// Track the specificity that set each field so a later, equally specific
+	// rule does not override an earlier one.
+	best := [4]int{-1, -1, -1, -1}
+	apply := func(i int, value time.Duration, score int, dst *time.Duration) {
+		if value > 0 && score > best[i] {
+			*dst = value
+			best[i] = score
+		}
+	}
+
+	for _, rule := range global.Rules {
+		if !rule.Matches(task) {
+			continue
+		}
+		score := rule.specificity()
+		apply(0, rule.Default.Duration, score, &policy.Default)
+		apply(1, rule.Min.Duration, score, &policy.Min)
+		apply(2, rule.Max.Duration, score, &policy.Max)
+		apply(3, rule.Round.Duration, score, &policy.Round)
+	}
+	return policy
+}
+
+// Clamp limits a duration to the policy's minimum and maximum, where set.
+func (p DurationPolicy) Clamp(d time.Duration) time.Duration {
+	if p.Min > 0 && d < p.Min {
+		d = p.Min
+	}
+	if p.Max > 0 && d > p.Max {
+		d = p.Max
+	}
+	return d
+}
+
+// Snap widens [start, end) outwards to the policy's slot boundaries on the
+// wall clock of the times' location, so that 1h slots start on the hour and
+// 24h slots at midnight there. If that makes it longer than the maximum, the
+// end moves in to the last boundary within it instead. It returns the times
+// unchanged when no rounding is configured.
+func (p DurationPolicy) Snap(start, end time.Time) (time.Time, time.Time) {
+	if p.Round <= 0 {
+		return start, end
+	}
+	snappedStart := truncateLocal(start, p.Round)
+	snappedEnd := truncateLocal(end, p.Round)
+	if snappedEnd.Before(end) {
+		snappedEnd = snappedEnd.Add(p.Round)
+	}
+	if p.Max > 0 && snappedEnd.Sub(snappedStart) > p.Max {
+		span := p.Max - p.Max%p.Round
+		if span <= 0 {
+			// No slot fits within the maximum
+			span = p.Max
+		}
+		snappedEnd = snappedStart.Add(span)
+	}
+	return snappedStart, snappedEnd
+}
+
+// truncateLocal rounds t down to a multiple of d on its wall clock.
+// time.Truncate counts from the zero time in UTC, which puts the boundaries
+// of e.g. 1h slots at half past in a +05:30 zone.
+func truncateLocal(t time.Time, d time.Duration) time.Time {
+	_, offset := t.Zone()
+	shift := time.Duration(offset) * time.Second
+	return t.Add(shift).Truncate(d).Add(-shift)
+}
+
+// ReminderRule adds a reminder to the events of the tasks matched by its selector.
+type ReminderRule struct {
+	Selector
+	// Method is "popup" (the default) or "email".
+	Method string   `json:"method,omitempty"`
+	Before Duration `json:"before"`
+}
+
+// ReminderRules returns the reminder rules that apply to a task: all matching
+// rules that share the highest specificity, so a tag rule replaces the project
+// and global reminders instead of adding to them. It returns nil when no rule
+// matches, in which case the calendar's default reminders should be kept.
+func (c *Config) ReminderRules(task *taskwarrior.Task) []ReminderRule {
+	if c == nil {
+		return nil
+	}
+	var matched []ReminderRule
+	best := -1
+	for _, rule := range c.Reminders {
+		if !rule.Matches(task) {
+			continue
+		}
+		score := rule.specificity()
+		if score > best {
+			matched = matched[:0]
+			best = score
+		}
+		if score == best {
+			matched = append(matched, rule)
+		}
+	}
+	return matched
+}
+
+// Privacy levels, from least to most redacted.
+const (
+	PrivacyFull      = "full"
+	PrivacyTitleOnly = "title-only"
+	PrivacyBusy      = "busy"
+)
+
+var privacyRank = map[string]int{
+	PrivacyFull:      0,
+	PrivacyTitleOnly: 1,
+	PrivacyBusy:      2,
+}
+
+// PrivacyRule sets the privacy level for the tasks matched by its selector.
+type PrivacyRule struct {
+	Selector
+	Level string `json:"level"`
+}
+
+// PrivacyConfig controls how much of a task is written into its events.
+type PrivacyConfig struct {
+	// Default applies to tasks no rule matches; "full" when empty.
+	Default string `json:"default,omitempty"`
+	// Calendars maps a calendar name or ID to the minimum level used on it.
+	Calendars map[string]string `json:"calendars,omitempty"`
+	Rules     []PrivacyRule     `json:"rules,omitempty"`
+	// BusyTransparency is "opaque" (the default) or "transparent" and sets
+	// whether redacted "busy" events block time in free/busy lookups.
+	BusyTransparency string `json:"busy_transparency,omitempty"`
+}
+
+// PrivacyLevel resolves the privacy level for a task synced to a calendar,
+// which is looked up in Calendars by each of the given keys (name and ID).
+// The most specific matching rule, or else the default, picks the
+// level; the calendar's level is a floor on top of that, so a task can never
+// be shown in more detail than the calendar allows. Unknown levels are treated
+// as "busy" to fail closed. It is safe to call on a nil Config.
+func (c *Config) PrivacyLevel(task *taskwarrior.Task, calendarKeys ...string) string {
+	if c == nil {
+		return PrivacyFull
+	}
+
+	level := c.Privacy.Default
+	best := -1
+	for _, rule := range c.Privacy.Rules {
+		if !rule.Matches(task) {
+			continue
+		}
+		if score := rule.specificity(); score > best {
+			level = rule.Level
+			best = score
+		}
+	}
+	level = normalizePrivacy(level)
+
+	for _, key := range calendarKeys {
+		floor, ok := c.Privacy.Calendars[key]
+		if !ok {
+			continue
+		}
+		floor = normalizePrivacy(floor)
+		if privacyRank[floor] > privacyRank[level] {
+			level = floor
+		}
+	}
+	return level
+}
+
+func normalizePrivacy(level string) string {
+	if level == "" {
+		return PrivacyFull
+	}
+	level = strings.ToLower(level)
+	if _, ok := privacyRank[level]; !ok {
+		return PrivacyBusy
+	}
+	return level
+}
+
+// RouteRule sends the tasks matched by its selector to another calendar,
+// possibly one of another profile's Google account.
+type RouteRule struct {
+	Selector
+	// Target is "calendar", "profile:calendar", or "profile:" for that
+	// profile's configured calendar.
+	Target string `json:"target"`
+}
+
+// Target is the profile and calendar a task's events are synced to. Empty
+// fields mean the current profile and its configured calendar.
+type Target struct {
+	Profile  string
+	Calendar string
+}
+
+// ParseTarget splits a route target at the first ':'.
+func ParseTarget(s string) Target {
+	if profile, calendar, ok := strings.Cut(s, ":"); ok {
+		return Target{Profile: profile, Calendar: calendar}
+	}
+	return Target{Calendar: s}
+}
+
+// String formats the target the way it is written in a route rule.
+func (t Target) String() string {
+	if t.Profile == "" {
+		return t.Calendar
+	}
+	return t.Profile + ":" + t.Calendar
+}
+
+// Route returns the target of the most specific matching route rule. Ties
+// between equally specific rules go to the one listed first. ok is false when
+// no rule matches, in which case the task goes to the configured calendar.
+func (c *Config) Route(task *taskwarrior.Task) (target Target, ok bool) {
+	if c == nil {
+		return Target{}, false
+	}
+	best := -1
+	for _, rule := range c.Routes {
+		if !rule.Matches(task) {
+			continue
+		}
+		if score := rule.specificity(); score > best {
+			target, best = ParseTarget(rule.Target), score
+		}
+	}
+	return target, best >= 0
+}
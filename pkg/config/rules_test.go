@@ -0,0 +1,234 @@
+package config
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+)
+
+func TestDurationPolicyPrecedence(t *testing.T) {
+	input := `{
+		"calendar": "Tasks",
+		"durations": {
+			"default": "45m",
+			"round": "5m",
+			"rules": [
+				{"priority": "H", "default": "2h", "max": "3h"},
+				{"project": "Work", "default": "1h", "min": "30m"},
+				{"project": "Work.Meetings", "default": "50m"},
+				{"tag": "errand", "default": "20m", "round": "10m"},
+				{"tag": "errand", "default": "99m"}
+			]
+		}
+	}`
+	var cfg Config
+	if err := json.Unmarshal([]byte(input), &cfg); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		task taskwarrior.Task
+		want DurationPolicy
+	}{
+		{
+			name: "global defaults",
+			task: taskwarrior.Task{Project: "Home"},
+			want: DurationPolicy{Default: 45 * time.Minute, Round: 5 * time.Minute},
+		},
+		{
+			name: "priority rule",
+			task: taskwarrior.Task{Priority: "H"},
+			want: DurationPolicy{Default: 2 * time.Hour, Max: 3 * time.Hour, Round: 5 * time.Minute},
+		},
+		{
+			name: "project beats priority, priority still fills max",
+			task: taskwarrior.Task{Project: "Work", Priority: "H"},
+			want: DurationPolicy{Default: time.Hour, Min: 30 * time.Minute, Max: 3 * time.Hour, Round: 5 * time.Minute},
+		},
+		{
+			name: "sub-project beats parent project",
+			task: taskwarrior.Task{Project: "Work.Meetings"},
+			want: DurationPolicy{Default: 50 * time.Minute, Min: 30 * time.Minute, Round: 5 * time.Minute},
+		},
+		{
+			name: "project prefix must end at a dot",
+			task: taskwarrior.Task{Project: "Workshop"},
+			want: DurationPolicy{Default: 45 * time.Minute, Round: 5 * time.Minute},
+		},
+		{
+			name: "tag beats project, first equal rule wins",
+			task: taskwarrior.Task{Project: "Work.Meetings", Tags: []string{"errand"}},
+			want: DurationPolicy{Default: 20 * time.Minute, Min: 30 * time.Minute, Round: 10 * time.Minute},
+		},
+	}
+
+	for _, tt := range tests {
+		got := cfg.DurationPolicy(&tt.task)
+		if got != tt.want {
+			t.Errorf("%s: expected %+v, got %+v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestDurationPolicyNilConfig(t *testing.T) {
+	var cfg *Config
+	got := cfg.DurationPolicy(&taskwarrior.Task{})
+	if got.Default != DefaultEventDuration {
+		t.Errorf("Expected default %s, got %s", DefaultEventDuration, got.Default)
+	}
+}
+
+func TestDurationPolicyClampAndSnap(t *testing.T) {
+	p := DurationPolicy{Min: 15 * time.Minute, Max: 2 * time.Hour, Round: 15 * time.Minute}
+	if got := p.Clamp(5 * time.Minute); got != 15*time.Minute {
+		t.Errorf("Expected clamp to min 15m, got %s", got)
+	}
+	if got := p.Clamp(3 * time.Hour); got != 2*time.Hour {
+		t.Errorf("Expected clamp to max 2h, got %s", got)
+	}
+
+	start := time.Date(2023, 1, 1, 9, 7, 0, 0, time.UTC)
+	end := time.Date(2023, 1, 1, 9, 37, 0, 0, time.UTC)
+	s, e := p.Snap(start, end)
+	if !s.Equal(time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected start snapped to 09:00, got %s", s)
+	}
+	if !e.Equal(time.Date(2023, 1, 1, 9, 45, 0, 0, time.UTC)) {
+		t.Errorf("Expected end snapped to 09:45, got %s", e)
+	}
+
+	// Widening a block of the maximum length would exceed it
+	s, e = p.Snap(start, start.Add(p.Clamp(3*time.Hour)))
+	if !s.Equal(time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)) || !e.Equal(time.Date(2023, 1, 1, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("Expected 09:00-11:00 within the 2h maximum, got %s-%s", s, e)
+	}
+	uneven := DurationPolicy{Max: 50 * time.Minute, Round: 15 * time.Minute}
+	if s, e := uneven.Snap(start, start.Add(50*time.Minute)); e.Sub(s) != 45*time.Minute {
+		t.Errorf("Expected 45m, the longest whole slots within 50m, got %s", e.Sub(s))
+	}
+	short := DurationPolicy{Max: 10 * time.Minute, Round: 15 * time.Minute}
+	if s, e := short.Snap(start, start.Add(10*time.Minute)); e.Sub(s) != 10*time.Minute {
+		t.Errorf("Expected the 10m maximum when no slot fits, got %s", e.Sub(s))
+	}
+}
+
+func TestDurationPolicySnapsOnTheWallClock(t *testing.T) {
+	india := time.FixedZone("IST", 5*3600+1800)
+	tests := []struct {
+		name               string
+		round              time.Duration
+		start, end         time.Time
+		wantStart, wantEnd time.Time
+	}{
+		{
+			name:      "hour slots",
+			round:     time.Hour,
+			start:     time.Date(2026, 3, 2, 9, 10, 0, 0, india),
+			end:       time.Date(2026, 3, 2, 10, 20, 0, 0, india),
+			wantStart: time.Date(2026, 3, 2, 9, 0, 0, 0, india),
+			wantEnd:   time.Date(2026, 3, 2, 11, 0, 0, 0, india),
+		},
+		{
+			name:      "day slots",
+			round:     24 * time.Hour,
+			start:     time.Date(2026, 3, 2, 3, 0, 0, 0, india),
+			end:       time.Date(2026, 3, 2, 4, 0, 0, 0, india),
+			wantStart: time.Date(2026, 3, 2, 0, 0, 0, 0, india),
+			wantEnd:   time.Date(2026, 3, 3, 0, 0, 0, 0, india),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, e := DurationPolicy{Round: tt.round}.Snap(tt.start, tt.end)
+			if !s.Equal(tt.wantStart) || !e.Equal(tt.wantEnd) {
+				t.Errorf("got %s-%s, want %s-%s", s, e, tt.wantStart, tt.wantEnd)
+			}
+		})
+	}
+}
+
+func TestPrivacyLevel(t *testing.T) {
+	cfg := &Config{
+		Privacy: PrivacyConfig{
+			Default:   PrivacyTitleOnly,
+			Calendars: map[string]string{"Shared": PrivacyBusy},
+			Rules: []PrivacyRule{
+				{Selector: Selector{Project: "Personal"}, Level: PrivacyBusy},
+				{Selector: Selector{Project: "OpenSource"}, Level: PrivacyFull},
+				{Selector: Selector{Tag: "typo"}, Level: "bogus"},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		task     taskwarrior.Task
+		calendar string
+		want     string
+	}{
+		{"default", taskwarrior.Task{Project: "Work"}, "Tasks", PrivacyTitleOnly},
+		{"rule relaxes default", taskwarrior.Task{Project: "OpenSource"}, "Tasks", PrivacyFull},
+		{"rule tightens default", taskwarrior.Task{Project: "Personal.Health"}, "Tasks", PrivacyBusy},
+		{"calendar is a floor", taskwarrior.Task{Project: "OpenSource"}, "Shared", PrivacyBusy},
+		{"unknown level fails closed", taskwarrior.Task{Tags: []string{"typo"}}, "Tasks", PrivacyBusy},
+	}
+	for _, tt := range tests {
+		if got := cfg.PrivacyLevel(&tt.task, tt.calendar); got != tt.want {
+			t.Errorf("%s: expected %s, got %s", tt.name, tt.want, got)
+		}
+	}
+
+	var nilCfg *Config
+	if got := nilCfg.PrivacyLevel(&taskwarrior.Task{}); got != PrivacyFull {
+		t.Errorf("Expected full privacy level for nil config, got %s", got)
+	}
+}
+
+func TestRoute(t *testing.T) {
+	cfg := &Config{
+		Routes: []RouteRule{
+			{Selector: Selector{Project: "Work"}, Target: "work:"},
+			{Selector: Selector{Project: "Work.Sprint"}, Target: "work:Sprint"},
+			{Selector: Selector{Tag: "family"}, Target: "Family"},
+		},
+	}
+
+	tests := []struct {
+		name string
+		task taskwarrior.Task
+		want Target
+		ok   bool
+	}{
+		{"no match", taskwarrior.Task{Project: "Home"}, Target{}, false},
+		{"profile default calendar", taskwarrior.Task{Project: "Work.Admin"}, Target{Profile: "work"}, true},
+		{"deeper project wins", taskwarrior.Task{Project: "Work.Sprint"}, Target{Profile: "work", Calendar: "Sprint"}, true},
+		{"tag wins, same profile", taskwarrior.Task{Project: "Work", Tags: []string{"family"}}, Target{Calendar: "Family"}, true},
+	}
+	for _, tt := range tests {
+		got, ok := cfg.Route(&tt.task)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("%s: expected %+v (%v), got %+v (%v)", tt.name, tt.want, tt.ok, got, ok)
+		}
+	}
+}
+
+func TestConflicts(t *testing.T) {
+	tests := []struct {
+		cfg  *Config
+		want string
+	}{
+		{nil, ConflictTaskWins},
+		{&Config{}, ConflictTaskWins},
+		{&Config{ConflictPolicy: "Newest-Wins"}, ConflictNewestWins},
+		{&Config{ConflictPolicy: "calendar-wins"}, ConflictCalendarWins},
+		{&Config{ConflictPolicy: "task-loses"}, ConflictKeepBoth},
+	}
+	for _, tt := range tests {
+		if got := tt.cfg.Conflicts(); got != tt.want {
+			t.Errorf("Conflicts() of %+v = %q, expected %q", tt.cfg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+// Package conflicts records events that were edited on the calendar after
+// taska last wrote them, and works out how to carry such an edit back to its
+// task.
+package conflicts
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/state"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Resolutions, i.e. the side that was kept.
+const (
+	// Pending conflicts wait for `taska conflicts resolve`.
+	Pending      = ""
+	KeptTask     = "task"
+	KeptCalendar = "calendar"
+)
+
+// Conflict is an event that changed on the calendar since its last sync
+// while its task changed too.
+type Conflict struct {
+	// Key is the event's index key.
+	Key        string `json:"key"`
+	TaskUUID   string `json:"task_uuid"`
+	Role       string `json:"role,omitempty"`
+	EventID    string `json:"event_id"`
+	CalendarID string `json:"calendar_id"`
+	// Policy is the conflict policy that was in effect.
+	Policy string `json:"policy"`
+	// Resolution is the side that was kept, or Pending.
+	Resolution string    `json:"resolution,omitempty"`
+	DetectedAt time.Time `json:"detected_at"`
+	// Task is the event as rendered from the task, Calendar the edited event.
+	Task     Version `json:"task"`
+	Calendar Version `json:"calendar"`
+}
+
+// Pending reports whether the conflict still needs a decision.
+func (c Conflict) Pending() bool {
+	return c.Resolution == Pending
+}
+
+// Version is one side of a conflict.
+type Version struct {
+	Summary string `json:"summary"`
+	Start   string `json:"start,omitempty"`
+	End     string `json:"end,omitempty"`
+	// Updated is when this side last changed, if known.
+	Updated time.Time `json:"updated,omitempty"`
+}
+
+// VersionOf summarizes an event.
+func VersionOf(event *calendar.Event) Version {
+	v := Version{Summary: event.Summary, Start: dateTime(event.Start), End: dateTime(event.End)}
+	if updated, err := time.Parse(time.RFC3339, event.Updated); err == nil {
+		v.Updated = updated
+	}
+	return v
+}
+
+// Event returns the version as an event with only a summary and times.
+func (v Version) Event() *calendar.Event {
+	return &calendar.Event{Summary: v.Summary, Start: eventDateTime(v.Start), End: eventDateTime(v.End)}
+}
+
+func eventDateTime(s string) *calendar.EventDateTime {
+	switch {
+	case s == "":
+		return nil
+	case len(s) == len(time.DateOnly):
+		return &calendar.EventDateTime{Date: s}
+	default:
+		return &calendar.EventDateTime{DateTime: s}
+	}
+}
+
+func dateTime(t *calendar.EventDateTime) string {
+	if t == nil {
+		return ""
+	}
+	if t.DateTime != "" {
+		return t.DateTime
+	}
+	return t.Date
+}
+
+// Put records c, replacing an earlier conflict of the same event.
+func Put(c Conflict) error {
+	return state.Update(func(tx *state.Tx) error {
+		return tx.Put(state.Conflicts, c.Key, c)
+	})
+}
+
+// Get returns the recorded conflict of the event with the given index key.
+func Get(key string) (Conflict, bool, error) {
+	var c Conflict
+	var ok bool
+	err := state.View(func(tx *state.Tx) error {
+		var err error
+		ok, err = tx.Get(state.Conflicts, key, &c)
+		return err
+	})
+	return c, ok, err
+}
+
+// List returns every recorded conflict, oldest first.
+func List() ([]Conflict, error) {
+	var list []Conflict
+	err := state.View(func(tx *state.Tx) error {
+		return tx.ForEach(state.Conflicts, func(key string, value json.RawMessage) error {
+			var c Conflict
+			if err := json.Unmarshal(value, &c); err != nil {
+				return fmt.Errorf("corrupt conflict %s: %w", key, err)
+			}
+			list = append(list, c)
+			return nil
+		})
+	})
+	sort.Slice(list, func(i, j int) bool { return list[i].DetectedAt.Before(list[j].DetectedAt) })
+	return list, err
+}
+
+// Delete forgets the conflict of the event with the given index key.
+func Delete(key string) error {
+	return state.Update(func(tx *state.Tx) error {
+		return tx.Delete(state.Conflicts, key)
+	})
+}
+
+// ClearResolved forgets every conflict that was resolved by a policy and
+// returns how many there were.
+func ClearResolved() (int, error) {
+	list, err := List()
+	if err != nil {
+		return 0, err
+	}
+	n := 0
+	err = state.Update(func(tx *state.Tx) error {
+		for _, c := range list {
+			if c.Pending() {
+				continue
+			}
+			if err := tx.Delete(state.Conflicts, c.Key); err != nil {
+				return err
+			}
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
+
+// TaskChanges returns the Taskwarrior modifications that carry the calendar's
+// version of an event over to its task: a new title becomes the description
+// and a moved event moves the date it was placed at. rendered is the event as
+// taska renders the task now. Other edits (length, notes, colour) have no
+// place in the task and are dropped.
+func TaskChanges(task *taskwarrior.Task, role string, remote, rendered *calendar.Event) ([]string, error) {
+	var mods []string
+
+	// The rendered summary is a status prefix plus the description, unless
+	// the event is redacted and says nothing about the task.
+	if remote.Summary != rendered.Summary && strings.HasSuffix(rendered.Summary, task.Description) {
+		prefix := strings.TrimSuffix(rendered.Summary, task.Description)
+		description := strings.TrimSpace(strings.TrimPrefix(remote.Summary, prefix))
+		for _, p := range statusPrefixes {
+			description = strings.TrimPrefix(description, p)
+		}
+		if description != "" && description != task.Description {
+			mods = append(mods, "description:"+description)
+		}
+	}
+
+	attribute, current := placedAt(task, role)
+	if attribute == "" {
+		return mods, nil
+	}
+	was, err := eventStart(rendered.Start)
+	if err != nil {
+		return nil, err
+	}
+	now, err := eventStart(remote.Start)
+	if err != nil {
+		return nil, err
+	}
+	if now.IsZero() || now.Equal(was) {
+		return mods, nil
+	}
+	if remote.Start.DateTime == "" {
+		// Moved to another day as an all-day event: keep the time of day.
+		now = now.Add(current.Sub(startOfDay(current)))
+	}
+	return append(mods, attribute+":"+taskwarrior.FormatTime(now)), nil
+}
+
+// statusPrefixes are the markers taska puts in front of event titles.
+var statusPrefixes = []string{"✓ ", "‣ ", "! "}
+
+// placedAt returns the task attribute an event's start was taken from and its
+// value, or "" if the start is derived from when the task was worked on.
+func placedAt(task *taskwarrior.Task, role string) (string, time.Time) {
+	hasDue := task.Due != nil && !task.Due.IsZero()
+	if role == index.RoleDeadline {
+		if hasDue {
+			return "due", task.Due.Time
+		}
+		return "", time.Time{}
+	}
+	if task.Status == taskwarrior.COMPLETED || (task.Start != nil && !task.Start.IsZero()) {
+		return "", time.Time{}
+	}
+	if task.Scheduled != nil && !task.Scheduled.IsZero() {
+		return "scheduled", task.Scheduled.Time
+	}
+	if hasDue {
+		return "due", task.Due.Time
+	}
+	return "", time.Time{}
+}
+
+func eventStart(t *calendar.EventDateTime) (time.Time, error) {
+	switch {
+	case t == nil:
+		return time.Time{}, nil
+	case t.DateTime != "":
+		return time.Parse(time.RFC3339, t.DateTime)
+	case t.Date != "":
+		return time.ParseInLocation(time.DateOnly, t.Date, time.Local)
+	default:
+		return time.Time{}, nil
+	}
+}
+
+func startOfDay(t time.Time) time.Time {
+	t = t.Local()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.Local)
+}
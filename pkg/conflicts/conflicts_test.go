@@ -0,0 +1,119 @@
+package conflicts
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"google.golang.org/api/calendar/v3"
+)
+
+func at(s string) *calendar.EventDateTime {
+	return &calendar.EventDateTime{DateTime: s}
+}
+
+func TestTaskChanges(t *testing.T) {
+	scheduled := time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)
+	due := time.Date(2026, 3, 6, 17, 0, 0, 0, time.UTC)
+	task := &taskwarrior.Task{
+		UUID:        "u1",
+		Description: "Write report",
+		Status:      taskwarrior.PENDING,
+		Scheduled:   &taskwarrior.CustomTime{Time: scheduled},
+		Due:         &taskwarrior.CustomTime{Time: due},
+	}
+	rendered := &calendar.Event{Summary: "Write report", Start: at("2026-03-02T09:00:00Z"), End: at("2026-03-02T09:30:00Z")}
+
+	tests := []struct {
+		name     string
+		role     string
+		remote   *calendar.Event
+		rendered *calendar.Event
+		want     []string
+	}{
+		{
+			name:     "renamed and moved",
+			remote:   &calendar.Event{Summary: "Write the report", Start: at("2026-03-03T10:00:00+01:00")},
+			rendered: rendered,
+			want:     []string{"description:Write the report", "scheduled:20260303T090000Z"},
+		},
+		{
+			name:     "status prefix is not part of the description",
+			remote:   &calendar.Event{Summary: "! Write it", Start: at("2026-03-02T09:00:00Z")},
+			rendered: &calendar.Event{Summary: "! Write report", Start: at("2026-03-02T09:00:00Z")},
+			want:     []string{"description:Write it"},
+		},
+		{
+			name:     "redacted title is not imported",
+			remote:   &calendar.Event{Summary: "Dentist", Start: at("2026-03-02T09:00:00Z")},
+			rendered: &calendar.Event{Summary: "Busy", Start: at("2026-03-02T09:00:00Z")},
+			want:     nil,
+		},
+		{
+			name:     "deadline marker moves the due date",
+			role:     index.RoleDeadline,
+			remote:   &calendar.Event{Summary: "⚑ Due: Write report", Start: at("2026-03-09T17:00:00Z")},
+			rendered: &calendar.Event{Summary: "⚑ Due: Write report", Start: at("2026-03-06T17:00:00Z")},
+			want:     []string{"due:20260309T170000Z"},
+		},
+	}
+	for _, tt := range tests {
+		got, err := TaskChanges(task, tt.role, tt.remote, tt.rendered)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestTaskChangesStartedTaskKeepsDates(t *testing.T) {
+	task := &taskwarrior.Task{
+		UUID:        "u1",
+		Description: "Write report",
+		Status:      taskwarrior.PENDING,
+		Start:       &taskwarrior.CustomTime{Time: time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)},
+	}
+	rendered := &calendar.Event{Summary: "‣ Write report", Start: at("2026-03-02T09:00:00Z")}
+	remote := &calendar.Event{Summary: "‣ Write report", Start: at("2026-03-02T11:00:00Z")}
+	got, err := TaskChanges(task, index.RolePrimary, remote, rendered)
+	if err != nil || len(got) != 0 {
+		t.Errorf("expected no changes, got %q (%v)", got, err)
+	}
+}
+
+func TestStore(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	base := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	for i, c := range []Conflict{
+		{Key: "u2", TaskUUID: "u2", Resolution: KeptTask, DetectedAt: base.Add(2 * time.Hour)},
+		{Key: "u1", TaskUUID: "u1", DetectedAt: base.Add(time.Hour)},
+	} {
+		if err := Put(c); err != nil {
+			t.Fatalf("Put %d: %v", i, err)
+		}
+	}
+
+	list, err := List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 || list[0].Key != "u1" || list[1].Key != "u2" {
+		t.Fatalf("expected u1 then u2, got %+v", list)
+	}
+
+	n, err := ClearResolved()
+	if err != nil || n != 1 {
+		t.Fatalf("ClearResolved() = %d, %v", n, err)
+	}
+	if _, ok, _ := Get("u2"); ok {
+		t.Error("resolved conflict was kept")
+	}
+	if c, ok, _ := Get("u1"); !ok || !c.Pending() {
+		t.Error("pending conflict was cleared")
+	}
+}
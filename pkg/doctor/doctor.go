@@ -0,0 +1,461 @@
+package doctor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/harrisonrobin/taska/pkg/auth"
+	"github.com/harrisonrobin/taska/pkg/colors"
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/google"
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/scheduler"
+	"github.com/harrisonrobin/taska/pkg/state"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"google.golang.org/api/calendar/v3"
+)
+
+// newTokenStore and newService open the token store and the Calendar API the
+// checks use; tests replace them with fakes.
+var (
+	newTokenStore = auth.NewTokenStore
+	newService    = google.NewService
+)
+
+// Check outcomes.
+const (
+	OK   = "ok"
+	Warn = "warn"
+	Fail = "fail"
+	Skip = "skip"
+)
+
+// minTaskVersion is the oldest Taskwarrior with hook API v2 and `_get`.
+var minTaskVersion = [3]int{2, 6, 0}
+
+// Result is the outcome of a single check.
+type Result struct {
+	Name   string `json:"name"`
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+	Fix    string `json:"fix,omitempty"`
+}
+
+// Options configures Run.
+type Options struct {
+	Calendar string
+	// Config selects the token store; nil uses the defaults.
+	Config *config.Config
+}
+
+// Run performs every check in order. Checks that depend on an earlier one
+// (e.g. the calendar needs a working token) are skipped when it failed.
+func Run(ctx context.Context, opts Options) []Result {
+	var results []Result
+
+	results = append(results, checkTaskBinary())
+	tw := results[len(results)-1].Status != Fail
+	if tw {
+		results = append(results, checkHooks())
+		results = append(results, checkUDAs())
+	}
+
+	results = append(results, checkStateFiles())
+
+	var creds Result
+	if opts.Config.UsesServiceAccount() {
+		creds = checkServiceAccount(opts.Config.ServiceAccount)
+	} else {
+		creds = checkCredentials()
+	}
+	results = append(results, creds)
+	token := Result{Name: "token", Status: Skip, Detail: "credentials are not usable"}
+	if creds.Status != Fail {
+		token = checkToken(ctx, opts.Config)
+	}
+	results = append(results, token)
+
+	cal := Result{Name: "calendar", Status: Skip, Detail: "no usable token"}
+	if token.Status == OK || token.Status == Warn {
+		cal = checkCalendar(ctx, opts.Calendar, opts.Config)
+	}
+	results = append(results, cal)
+	return results
+}
+
+// Failed reports whether any check failed.
+func Failed(results []Result) bool {
+	for _, r := range results {
+		if r.Status == Fail {
+			return true
+		}
+	}
+	return false
+}
+
+func checkTaskBinary() Result {
+	r := Result{Name: "task binary"}
+	path, err := exec.LookPath("task")
+	if err != nil {
+		r.Status = Fail
+		r.Detail = "task is not on $PATH"
+		r.Fix = "install Taskwarrior (https://taskwarrior.org/download/) and make sure `task` is on $PATH"
+		return r
+	}
+	version, err := taskwarrior.NewClient().Version()
+	if err != nil {
+		r.Status = Fail
+		r.Detail = fmt.Sprintf("%s: %v", path, err)
+		r.Fix = "check that `task --version` runs"
+		return r
+	}
+	if !versionAtLeast(version, minTaskVersion) {
+		r.Status = Fail
+		r.Detail = fmt.Sprintf("%s is version %s", path, version)
+		r.Fix = fmt.Sprintf("upgrade Taskwarrior to %d.%d.%d or later", minTaskVersion[0], minTaskVersion[1], minTaskVersion[2])
+		return r
+	}
+	r.Status = OK
+	r.Detail = fmt.Sprintf("%s (%s)", path, version)
+	return r
+}
+
+// versionAtLeast compares a dotted version such as "3.1.0" with min.
+func versionAtLeast(version string, min [3]int) bool {
+	parts := strings.SplitN(version, ".", 3)
+	for i := 0; i < 3; i++ {
+		n := 0
+		if i < len(parts) {
+			digits := strings.TrimFunc(parts[i], func(r rune) bool { return r < '0' || r > '9' })
+			n, _ = strconv.Atoi(digits)
+		}
+		if n != min[i] {
+			return n > min[i]
+		}
+	}
+	return true
+}
+
+func checkHooks() Result {
+	r := Result{Name: "hooks"}
+	tw := taskwarrior.NewClient()
+
+	if enabled, err := tw.GetConfigured("rc.hooks"); err == nil && enabled != "" && !isTruthy(enabled) {
+		r.Status = Fail
+		r.Detail = "hooks are disabled (rc.hooks=" + enabled + ")"
+		r.Fix = "task config hooks on"
+		return r
+	}
+
+	dir, err := hooksDir(tw)
+	if err != nil {
+		r.Status = Fail
+		r.Detail = err.Error()
+		r.Fix = "check your Taskwarrior configuration with `task show`"
+		return r
+	}
+
+	// An on-exit hook syncs in batches and replaces the per-task hooks
+	events := []string{"on-add", "on-modify"}
+	var twice []string
+	if _, batch := findHook(dir, "on-exit"); batch {
+		for _, event := range events {
+			if path, found := findHook(dir, event); found {
+				twice = append(twice, path)
+			}
+		}
+		events = []string{"on-exit"}
+	}
+
+	var missing, notExecutable []string
+	for _, event := range events {
+		path, found := findHook(dir, event)
+		if !found {
+			missing = append(missing, event)
+			continue
+		}
+		if info, err := os.Stat(path); err != nil || info.Mode()&0111 == 0 {
+			notExecutable = append(notExecutable, path)
+		}
+	}
+
+	switch {
+	case len(missing) > 0:
+		r.Status = Fail
+		r.Detail = fmt.Sprintf("no taska hook for %s in %s", strings.Join(missing, ", "), dir)
+		var cmds []string
+		for _, event := range missing {
+			cmds = append(cmds, fmt.Sprintf("ln -s $(which taska) %s", filepath.Join(dir, event+".taska")))
+		}
+		r.Fix = strings.Join(cmds, " && ")
+	case len(notExecutable) > 0:
+		r.Status = Fail
+		r.Detail = "hook is not executable: " + strings.Join(notExecutable, ", ")
+		r.Fix = "chmod +x " + strings.Join(notExecutable, " ")
+	case len(twice) > 0:
+		r.Status = Warn
+		r.Detail = "the on-exit hook already syncs every change, tasks are synced twice"
+		r.Fix = "rm " + strings.Join(twice, " ")
+	default:
+		r.Status = OK
+		r.Detail = dir
+	}
+	return r
+}
+
+func hooksDir(tw *taskwarrior.Client) (string, error) {
+	if dir, err := tw.Get("rc.hooks.location"); err == nil && dir != "" {
+		return expandHome(dir)
+	}
+	dir, err := tw.Get("rc.data.location")
+	if err != nil {
+		return "", err
+	}
+	if dir == "" {
+		dir = "~/.task"
+	}
+	dir, err = expandHome(dir)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "hooks"), nil
+}
+
+// findHook looks for a hook script for the event that runs taska, either by
+// name (on-add.taska) or because it links to the taska binary.
+func findHook(dir, event string) (string, bool) {
+	matches, _ := filepath.Glob(filepath.Join(dir, event+"*"))
+	self, _ := os.Executable()
+	for _, m := range matches {
+		if strings.Contains(filepath.Base(m), "taska") {
+			return m, true
+		}
+		if target, err := filepath.EvalSymlinks(m); err == nil && (target == self || filepath.Base(target) == "taska") {
+			return m, true
+		}
+	}
+	return "", false
+}
+
+func checkUDAs() Result {
+	r := Result{Name: "UDAs"}
+	tw := taskwarrior.NewClient()
+	udas := []struct {
+		name, typ string
+	}{
+		{"est", "duration"},
+		{"act", "duration"},
+		{"remind", "string"},
+	}
+
+	var missing, fixes []string
+	for _, uda := range udas {
+		typ, err := tw.Get("rc.uda." + uda.name + ".type")
+		if err != nil || typ == "" {
+			missing = append(missing, uda.name)
+			fixes = append(fixes, fmt.Sprintf("task config uda.%s.type %s", uda.name, uda.typ))
+		}
+	}
+	if len(missing) > 0 {
+		// The UDAs are optional: tasks sync without them, just with less detail.
+		r.Status = Warn
+		r.Detail = "not defined: " + strings.Join(missing, ", ")
+		r.Fix = strings.Join(fixes, " && ")
+		return r
+	}
+	r.Status = OK
+	return r
+}
+
+func checkStateFiles() Result {
+	r := Result{Name: "state files"}
+	var problems []string
+	if _, err := config.Load(); err != nil {
+		problems = append(problems, fmt.Sprintf("config: %v", err))
+	}
+	dbPath, _ := state.Path()
+	if err := state.View(func(*state.Tx) error { return nil }); err != nil {
+		r.Status = Fail
+		r.Detail = strings.Join(append(problems, fmt.Sprintf("state store: %v", err)), "; ")
+		switch {
+		case errors.Is(err, state.ErrNewerSchema):
+			r.Fix = "upgrade taska; this version cannot read the state store"
+		default:
+			r.Fix = fmt.Sprintf("move %s aside; taska rebuilds the index, schedule and colour cache", dbPath)
+		}
+		return r
+	}
+	if _, err := index.NewEventIndex(); err != nil {
+		problems = append(problems, fmt.Sprintf("event index: %v", err))
+	}
+	if _, err := scheduler.New(); err != nil {
+		problems = append(problems, fmt.Sprintf("schedule: %v", err))
+	}
+	if _, err := colors.NewColorCache(); err != nil {
+		problems = append(problems, fmt.Sprintf("colour cache: %v", err))
+	}
+	if len(problems) > 0 {
+		r.Status = Fail
+		r.Detail = strings.Join(problems, "; ")
+		r.Fix = fmt.Sprintf("fix config.json, or move %s aside; taska rebuilds the index, schedule and colour cache", dbPath)
+		return r
+	}
+	r.Status = OK
+	r.Detail = dbPath
+	return r
+}
+
+func checkCredentials() Result {
+	r := Result{Name: "credentials"}
+	dir, _ := auth.GetXdgHome()
+	redirect, problem, err := auth.CheckCredentials()
+	if err != nil {
+		r.Status = Fail
+		r.Detail = err.Error()
+		r.Fix = fmt.Sprintf("download an OAuth client (Desktop app) from the Google Cloud Console and save it as %s", filepath.Join(dir, auth.ClientSecretsFile))
+		return r
+	}
+	if problem != "" {
+		r.Status = Fail
+		r.Detail = fmt.Sprintf("%s (%s)", problem, redirect)
+		r.Fix = fmt.Sprintf("use a Desktop app OAuth client, or add http://localhost:%s/ as an authorized redirect URI and download the credentials again", auth.LocalhostAuthPort)
+		return r
+	}
+	r.Status = OK
+	r.Detail = redirect
+	return r
+}
+
+func checkServiceAccount(sa config.ServiceAccountConfig) Result {
+	r := Result{Name: "service account"}
+	email, err := auth.ServiceAccountEmail(sa)
+	if err != nil {
+		r.Status = Fail
+		r.Detail = err.Error()
+		r.Fix = "create a JSON key for the service account in the Google Cloud Console and set service_account.key_file to its path"
+		return r
+	}
+	r.Status = OK
+	r.Detail = email
+	if sa.Subject != "" {
+		r.Detail += " acting as " + sa.Subject
+	}
+	return r
+}
+
+func checkToken(ctx context.Context, cfg *config.Config) Result {
+	r := Result{Name: "token"}
+	check, err := tokenCheck(ctx, cfg)
+	if err != nil {
+		r.Status = Fail
+		r.Detail = err.Error()
+		r.Fix = "run `taska --auth` to authorize again"
+		if cfg.UsesServiceAccount() {
+			r.Fix = "check that the key is active"
+			if cfg.ServiceAccount.Subject != "" {
+				r.Fix += " and that domain-wide delegation grants the service account's client ID the scopes " + strings.Join(auth.Scopes(), ",")
+			}
+		}
+		return r
+	}
+	if len(check.MissingScopes) == 1 && check.MissingScopes[0] == calendar.CalendarAppCreatedScope {
+		// Only calendar creation needs it; syncing works without
+		r.Status = Warn
+		r.Detail = "the token predates calendar creation and cannot create calendars"
+		r.Fix = "run `taska --auth` again to allow create_missing_calendar and `taska calendars create`"
+		return r
+	}
+	if len(check.MissingScopes) > 0 {
+		r.Status = Fail
+		r.Detail = "missing scopes: " + strings.Join(check.MissingScopes, ", ")
+		r.Fix = "run `taska --auth` and grant all requested permissions"
+		return r
+	}
+	r.Status = OK
+	r.Detail = "refreshed, valid until " + check.Expiry.Local().Format("2006-01-02 15:04")
+	if len(check.Scopes) == 0 {
+		r.Status = Warn
+		r.Detail += "; Google did not report the granted scopes"
+	}
+	return r
+}
+
+// tokenCheck refreshes the token of the config's account once.
+func tokenCheck(ctx context.Context, cfg *config.Config) (*auth.TokenCheck, error) {
+	if cfg.UsesServiceAccount() {
+		return auth.CheckToken(ctx, cfg)
+	}
+	store, err := newTokenStore(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return auth.CheckStoredToken(ctx, store)
+}
+
+func checkCalendar(ctx context.Context, name string, cfg *config.Config) Result {
+	r := Result{Name: "calendar"}
+	srv, err := newService(ctx, cfg)
+	if err != nil {
+		r.Status = Fail
+		r.Detail = err.Error()
+		r.Fix = "run `taska --auth`"
+		return r
+	}
+	// Look the calendar up by the ID taska syncs to, which a rename keeps
+	id, err := google.ResolveCalendar(srv, name)
+	var entry *calendar.CalendarListEntry
+	if err == nil {
+		entry, err = google.FindCalendar(srv, id)
+	}
+	if err != nil {
+		r.Status = Fail
+		r.Detail = err.Error()
+		r.Fix = fmt.Sprintf("run `taska calendars create %q`, set create_missing_calendar, or pick an existing calendar with `taska --set-calendar <name>`", name)
+		return r
+	}
+	// Calendars outside the calendar list have no AccessRole to check
+	if entry.AccessRole != "" && entry.AccessRole != "owner" && entry.AccessRole != "writer" {
+		r.Status = Fail
+		r.Detail = fmt.Sprintf("'%s' is %s-only", name, entry.AccessRole)
+		r.Fix = "ask the calendar owner for \"Make changes to events\" access, or use another calendar"
+		return r
+	}
+	if !google.IsCalendarID(name) && entry.Summary != name {
+		r.Status = Warn
+		r.Detail = fmt.Sprintf("'%s' was renamed to '%s' (%s)", name, entry.Summary, entry.Id)
+		r.Fix = fmt.Sprintf("run `taska --set-calendar %q`", entry.Summary)
+		return r
+	}
+	role := entry.AccessRole
+	if role == "" {
+		role = "not in the calendar list"
+	}
+	r.Status = OK
+	r.Detail = fmt.Sprintf("'%s' (%s, %s)", name, entry.Id, role)
+	return r
+}
+
+func isTruthy(s string) bool {
+	switch strings.ToLower(s) {
+	case "1", "on", "yes", "y", "true":
+		return true
+	}
+	return false
+}
+
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~")), nil
+}
@@ -0,0 +1,280 @@
+package doctor
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/auth"
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/google"
+	"github.com/harrisonrobin/taska/pkg/paths"
+	"github.com/harrisonrobin/taska/pkg/state"
+	"golang.org/x/oauth2"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+func TestVersionAtLeast(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"2.6.0", true},
+		{"2.6.2", true},
+		{"3.1.0", true},
+		{"2.5.3", false},
+		{"1.9", false},
+		{"3.0.0-beta", true},
+	}
+	for _, tt := range tests {
+		if got := versionAtLeast(tt.version, minTaskVersion); got != tt.want {
+			t.Errorf("versionAtLeast(%q): expected %v, got %v", tt.version, tt.want, got)
+		}
+	}
+}
+
+// fakeTask puts a task binary on $PATH that answers `_get` from settings.
+func fakeTask(t *testing.T, settings map[string]string) {
+	t.Helper()
+	script := "#!/bin/sh\nfor a; do name=$a; done\ncase $name in\n"
+	for name, value := range settings {
+		script += fmt.Sprintf("%s) echo %q ;;\n", name, value)
+	}
+	script += "esac\n"
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "task"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+}
+
+func TestCheckHooks(t *testing.T) {
+	tests := []struct {
+		name    string
+		hooks   string
+		scripts map[string]os.FileMode
+		want    string
+		detail  string
+	}{
+		{"disabled", "off", nil, Fail, "hooks are disabled"},
+		{"none installed", "on", nil, Fail, "no taska hook for on-add, on-modify"},
+		{"one missing", "", map[string]os.FileMode{"on-add.taska": 0755}, Fail, "no taska hook for on-modify"},
+		{"per-task hooks", "on", map[string]os.FileMode{"on-add.taska": 0755, "on-modify.taska": 0755}, OK, ""},
+		{"not executable", "on", map[string]os.FileMode{"on-add.taska": 0755, "on-modify.taska": 0644}, Fail, "hook is not executable"},
+		{"batch hook", "on", map[string]os.FileMode{"on-exit.taska": 0755}, OK, ""},
+		{"batch and per-task hooks", "on", map[string]os.FileMode{"on-exit.taska": 0755, "on-add.taska": 0755}, Warn, "synced twice"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			for name, mode := range tt.scripts {
+				if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\n"), mode); err != nil {
+					t.Fatal(err)
+				}
+			}
+			fakeTask(t, map[string]string{"rc.hooks": tt.hooks, "rc.hooks.location": dir})
+
+			r := checkHooks()
+			if r.Status != tt.want || !strings.Contains(r.Detail, tt.detail) {
+				t.Errorf("checkHooks() = %s %q, want %s %q", r.Status, r.Detail, tt.want, tt.detail)
+			}
+		})
+	}
+}
+
+func TestCheckStateFiles(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		db     string
+		want   string
+		detail string
+	}{
+		{"defaults", "", "", OK, "state.db"},
+		{"config", `{"calendar":"Work"}`, "", OK, "state.db"},
+		{"malformed config", `{"calendar":`, "", Fail, "config: failed to decode config"},
+		{"corrupt state store", "", "not a database", Fail, "state store"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			dir, err := paths.ConfigDir()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.MkdirAll(dir, 0700); err != nil {
+				t.Fatal(err)
+			}
+			if tt.config != "" {
+				path, _ := config.GetConfigPath()
+				if err := os.WriteFile(path, []byte(tt.config), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.db != "" {
+				path, _ := state.Path()
+				if err := os.WriteFile(path, []byte(tt.db), 0600); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			r := checkStateFiles()
+			if r.Status != tt.want || !strings.Contains(r.Detail, tt.detail) {
+				t.Errorf("checkStateFiles() = %s %q, want %s %q", r.Status, r.Detail, tt.want, tt.detail)
+			}
+		})
+	}
+}
+
+// memStore is a token store in memory.
+type memStore struct {
+	tok *oauth2.Token
+}
+
+func (s *memStore) Load() (*oauth2.Token, error) {
+	if s.tok == nil {
+		return nil, auth.ErrNoToken
+	}
+	return s.tok, nil
+}
+
+func (s *memStore) Save(tok *oauth2.Token) error {
+	s.tok = tok
+	return nil
+}
+
+func (s *memStore) Delete() error {
+	s.tok = nil
+	return nil
+}
+
+func (s *memStore) String() string { return "memory" }
+
+func TestCheckToken(t *testing.T) {
+	// The token endpoint refuses the refresh token "revoked" and otherwise
+	// grants the scopes named by the refresh token
+	granted := map[string]string{
+		"all":         strings.Join(auth.Scopes(), " "),
+		"no-create":   calendar.CalendarEventsScope + " " + calendar.CalendarReadonlyScope,
+		"no-events":   calendar.CalendarReadonlyScope + " " + calendar.CalendarAppCreatedScope,
+		"unspecified": "",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		refresh := r.Form.Get("refresh_token")
+		if refresh == "revoked" {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprint(w, `{"error":"invalid_grant","error_description":"Token has been expired or revoked."}`)
+			return
+		}
+		fmt.Fprintf(w, `{"access_token":"fresh","token_type":"Bearer","expires_in":3600,"scope":%q}`, granted[refresh])
+	}))
+	defer server.Close()
+
+	t.Setenv("HOME", t.TempDir())
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	secrets := fmt.Sprintf(`{"installed":{"client_id":"id","client_secret":"secret","auth_uri":%q,"token_uri":%q,"redirect_uris":["http://localhost"]}}`, server.URL+"/auth", server.URL+"/token")
+	if err := os.WriteFile(filepath.Join(dir, auth.ClientSecretsFile), []byte(secrets), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	expired := time.Now().Add(-time.Hour)
+	tests := []struct {
+		name   string
+		tok    *oauth2.Token
+		want   string
+		detail string
+	}{
+		{"no token", nil, Fail, "no saved token"},
+		{"no refresh token", &oauth2.Token{AccessToken: "old", Expiry: expired}, Fail, "no refresh token"},
+		{"revoked", &oauth2.Token{RefreshToken: "revoked", Expiry: expired}, Fail, "token refresh failed"},
+		{"expired", &oauth2.Token{AccessToken: "old", RefreshToken: "all", Expiry: expired}, OK, "refreshed, valid until"},
+		{"predates calendar creation", &oauth2.Token{RefreshToken: "no-create"}, Warn, "cannot create calendars"},
+		{"missing scopes", &oauth2.Token{RefreshToken: "no-events"}, Fail, "missing scopes: " + calendar.CalendarEventsScope},
+		{"scopes not reported", &oauth2.Token{RefreshToken: "unspecified"}, Warn, "did not report the granted scopes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := &memStore{tok: tt.tok}
+			newTokenStore = func(*config.Config) (auth.TokenStore, error) { return store, nil }
+			defer func() { newTokenStore = auth.NewTokenStore }()
+
+			r := checkToken(context.Background(), nil)
+			if r.Status != tt.want || !strings.Contains(r.Detail, tt.detail) {
+				t.Errorf("checkToken() = %s %q, want %s %q", r.Status, r.Detail, tt.want, tt.detail)
+			}
+			if r.Status != Fail && store.tok.AccessToken != "fresh" {
+				t.Errorf("the refreshed token was not saved: %+v", store.tok)
+			}
+		})
+	}
+}
+
+func TestCheckCalendar(t *testing.T) {
+	tests := []struct {
+		name string
+		// before is the calendar list when the name was first resolved
+		before, list string
+		calendar     string
+		want         string
+		detail       string
+	}{
+		{"writable", "", `[{"id":"work@group.calendar.google.com","summary":"Work","accessRole":"owner"}]`, "Work", OK, "'Work' (work@group.calendar.google.com, owner)"},
+		{"read-only", "", `[{"id":"work@group.calendar.google.com","summary":"Work","accessRole":"reader"}]`, "Work", Fail, "'Work' is reader-only"},
+		{"missing", "", `[{"id":"home@group.calendar.google.com","summary":"Home","accessRole":"owner"}]`, "Work", Fail, "calendar not found"},
+		{"renamed",
+			`[{"id":"work@group.calendar.google.com","summary":"Work","accessRole":"owner"}]`,
+			`[{"id":"work@group.calendar.google.com","summary":"Job","accessRole":"owner"}]`,
+			"Work", Warn, "'Work' was renamed to 'Job'"},
+		{"shared by ID", "", `[]`, "shared@group.calendar.google.com", OK, "not in the calendar list"},
+		{"unknown ID", "", `[]`, "gone@group.calendar.google.com", Fail, "calendar not found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("HOME", t.TempDir())
+			list := tt.before
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				switch r.URL.Path {
+				case "/users/me/calendarList":
+					fmt.Fprintf(w, `{"items":%s}`, list)
+				case "/calendars/shared@group.calendar.google.com":
+					fmt.Fprint(w, `{"id":"shared@group.calendar.google.com","summary":"Shared"}`)
+				default:
+					w.WriteHeader(http.StatusNotFound)
+					fmt.Fprint(w, `{"error":{"code":404,"message":"Not Found","errors":[{"reason":"notFound"}]}}`)
+				}
+			}))
+			defer server.Close()
+			newService = func(ctx context.Context, _ *config.Config) (*calendar.Service, error) {
+				return calendar.NewService(ctx, option.WithHTTPClient(server.Client()), option.WithEndpoint(server.URL))
+			}
+			defer func() { newService = google.NewService }()
+
+			if tt.before != "" {
+				if r := checkCalendar(context.Background(), tt.calendar, nil); r.Status != OK {
+					t.Fatalf("first check = %s %q", r.Status, r.Detail)
+				}
+			}
+			list = tt.list
+			r := checkCalendar(context.Background(), tt.calendar, nil)
+			if r.Status != tt.want || !strings.Contains(r.Detail, tt.detail) {
+				t.Errorf("checkCalendar() = %s %q, want %s %q", r.Status, r.Detail, tt.want, tt.detail)
+			}
+		})
+	}
+}
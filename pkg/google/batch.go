@@ -0,0 +1,302 @@
+package google
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// maxBatchSize is the most requests the Calendar API accepts in one batch.
+const maxBatchSize = 50
+
+// Batch operations.
+const (
+	BatchCreate = "create"
+	BatchPatch  = "patch"
+	BatchDelete = "delete"
+)
+
+// BatchOp is one event operation in a batch request.
+type BatchOp struct {
+	// Kind is BatchCreate, BatchPatch or BatchDelete.
+	Kind string
+	// TaskUUID and Role identify the task event the operation is for, so
+	// results can be reported per task.
+	TaskUUID string
+	Role     string
+	// EventID is the event to patch or delete.
+	EventID string
+	// Event is the event to create, or the patch.
+	Event *calendar.Event
+	// ETag, if set, makes a patch or delete conditional on the event not
+	// having changed since.
+	ETag string
+}
+
+// BatchResult is the outcome of one BatchOp.
+type BatchResult struct {
+	Op BatchOp
+	// Event is the created or patched event.
+	Event *calendar.Event
+	// Err is the operation's own error, a *googleapi.Error when the API
+	// rejected it.
+	Err error
+}
+
+// Batch sends the operations to the Calendar batch endpoint, up to
+// maxBatchSize per HTTP request, and returns one result per operation in
+// order. Operations that fail with a retryable error, such as a rate limit,
+// are sent again in a later batch. An error is returned only if a whole
+// request failed; the results of earlier requests are returned along with it.
+func (c *CalendarClient) Batch(ctx context.Context, ops []BatchOp) ([]BatchResult, error) {
+	if c.httpClient == nil {
+		return nil, errors.New("batch requests need the client's HTTP client")
+	}
+	ops = withEventIDs(ops)
+	results := make([]BatchResult, 0, len(ops))
+	for start := 0; start < len(ops); start += maxBatchSize {
+		chunk := ops[start:min(start+maxBatchSize, len(ops))]
+		chunkResults, err := c.sendBatch(ctx, chunk)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, chunkResults...)
+	}
+	if err := c.retryItems(ctx, results); err != nil {
+		return results, err
+	}
+	c.resolveDuplicates(ctx, results)
+	return results, nil
+}
+
+// withEventIDs gives every event to create an ID, so that a batch sent again
+// after an ambiguous failure cannot create the same event twice.
+func withEventIDs(ops []BatchOp) []BatchOp {
+	withIDs := append([]BatchOp(nil), ops...)
+	for i, op := range withIDs {
+		if op.Kind == BatchCreate && op.Event != nil {
+			withIDs[i].Event = withEventID(op.Event)
+		}
+	}
+	return withIDs
+}
+
+// resolveDuplicates turns the creates that failed because their event
+// already exists, created by an earlier attempt whose response was lost, into
+// successes with that event.
+func (c *CalendarClient) resolveDuplicates(ctx context.Context, results []BatchResult) {
+	for i, res := range results {
+		if res.Op.Kind != BatchCreate || !isDuplicate(res.Err) {
+			continue
+		}
+		event, err := execute(ctx, c.exec, func(ctx context.Context) (*calendar.Event, error) {
+			return c.srv.Events.Get(c.calendarID, res.Op.Event.Id).Context(ctx).Do()
+		})
+		if err != nil {
+			results[i].Err = err
+			continue
+		}
+		results[i].Event, results[i].Err = event, nil
+	}
+}
+
+// retryItems sends the operations that failed with a retryable error (e.g. a
+// rate limit on some items of a batch) again, in place in results.
+func (c *CalendarClient) retryItems(ctx context.Context, results []BatchResult) error {
+	for attempt := 1; attempt < c.exec.maxAttempts; attempt++ {
+		var retry []int
+		var lastErr error
+		for i, res := range results {
+			if res.Err != nil && Retryable(res.Err) {
+				retry = append(retry, i)
+				lastErr = res.Err
+			}
+		}
+		if len(retry) == 0 {
+			return nil
+		}
+		if err := c.exec.sleep(ctx, c.exec.Backoff(attempt, lastErr)); err != nil {
+			return err
+		}
+		for start := 0; start < len(retry); start += maxBatchSize {
+			indices := retry[start:min(start+maxBatchSize, len(retry))]
+			ops := make([]BatchOp, len(indices))
+			for j, i := range indices {
+				ops[j] = results[i].Op
+			}
+			retried, err := c.sendBatch(ctx, ops)
+			if err != nil {
+				return err
+			}
+			for j, i := range indices {
+				results[i] = retried[j]
+			}
+		}
+	}
+	return nil
+}
+
+// sendBatch sends one multipart/mixed batch request.
+func (c *CalendarClient) sendBatch(ctx context.Context, ops []BatchOp) ([]BatchResult, error) {
+	base, err := url.Parse(c.srv.BasePath)
+	if err != nil {
+		return nil, fmt.Errorf("invalid API base path %q: %w", c.srv.BasePath, err)
+	}
+	eventsPath := base.Path + "calendars/" + url.PathEscape(c.calendarID) + "/events"
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for i, op := range ops {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type": {"application/http"},
+			"Content-Id":   {fmt.Sprintf("<item-%d>", i)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBatchItem(part, eventsPath, op); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	batchURL := base.Scheme + "://" + base.Host + "/batch" + strings.TrimSuffix(base.Path, "/")
+	var results []BatchResult
+	err = c.exec.Do(ctx, func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, batchURL, bytes.NewReader(body.Bytes()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+
+		resp, err := c.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		if err := googleapi.CheckResponse(resp); err != nil {
+			return err
+		}
+		results, err = readBatchResponse(resp, ops)
+		return err
+	})
+	return results, err
+}
+
+// writeBatchItem writes one operation as an HTTP request inside a batch part.
+func writeBatchItem(w io.Writer, eventsPath string, op BatchOp) error {
+	var method, path string
+	var payload []byte
+	switch op.Kind {
+	case BatchCreate:
+		method, path = http.MethodPost, eventsPath
+	case BatchPatch:
+		method, path = http.MethodPatch, eventsPath+"/"+url.PathEscape(op.EventID)
+	case BatchDelete:
+		method, path = http.MethodDelete, eventsPath+"/"+url.PathEscape(op.EventID)
+	default:
+		return fmt.Errorf("unknown batch operation '%s'", op.Kind)
+	}
+	if op.Kind != BatchDelete {
+		var err error
+		// MarshalJSON honours ForceSendFields, e.g. a cleared description.
+		if payload, err = op.Event.MarshalJSON(); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", method, path)
+	if op.ETag != "" {
+		fmt.Fprintf(w, "If-Match: %s\r\n", op.ETag)
+	}
+	if payload != nil {
+		fmt.Fprintf(w, "Content-Type: application/json\r\nContent-Length: %d\r\n\r\n", len(payload))
+		_, err := w.Write(payload)
+		return err
+	}
+	_, err := io.WriteString(w, "\r\n")
+	return err
+}
+
+// readBatchResponse maps the parts of a batch response back to the
+// operations by their Content-ID.
+func readBatchResponse(resp *http.Response, ops []BatchOp) ([]BatchResult, error) {
+	mediaType, params, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("unexpected batch response type %q", resp.Header.Get("Content-Type"))
+	}
+
+	results := make([]BatchResult, len(ops))
+	seen := make([]bool, len(ops))
+	mr := multipart.NewReader(resp.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read batch response: %w", err)
+		}
+		i, ok := batchItemIndex(part.Header.Get("Content-Id"))
+		if !ok || i >= len(ops) {
+			return nil, fmt.Errorf("batch response has an unknown part %q", part.Header.Get("Content-Id"))
+		}
+		seen[i] = true
+		results[i] = readBatchItem(part, ops[i])
+	}
+
+	for i, ok := range seen {
+		if !ok {
+			results[i] = BatchResult{Op: ops[i], Err: errors.New("no response in batch")}
+		}
+	}
+	return results, nil
+}
+
+// readBatchItem parses the HTTP response inside one batch part.
+func readBatchItem(part io.Reader, op BatchOp) BatchResult {
+	res := BatchResult{Op: op}
+	itemResp, err := http.ReadResponse(bufio.NewReader(part), nil)
+	if err != nil {
+		res.Err = fmt.Errorf("could not read batch item: %w", err)
+		return res
+	}
+	defer itemResp.Body.Close()
+	if err := googleapi.CheckResponse(itemResp); err != nil {
+		res.Err = err
+		return res
+	}
+	if op.Kind == BatchDelete {
+		return res
+	}
+	res.Event = new(calendar.Event)
+	if err := json.NewDecoder(itemResp.Body).Decode(res.Event); err != nil {
+		res.Event = nil
+		res.Err = fmt.Errorf("could not decode batch item: %w", err)
+	}
+	return res
+}
+
+// batchItemIndex parses the Content-ID of a response part, "<response-item-N>".
+func batchItemIndex(contentID string) (int, bool) {
+	id := strings.TrimSuffix(strings.TrimPrefix(contentID, "<"), ">")
+	id = strings.TrimPrefix(id, "response-")
+	n, err := strconv.Atoi(strings.TrimPrefix(id, "item-"))
+	return n, err == nil && n >= 0
+}
@@ -0,0 +1,206 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+func TestBatch(t *testing.T) {
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			var e calendar.Event
+			json.NewDecoder(r.Body).Decode(&e)
+			reply(w, http.StatusOK, fmt.Sprintf(`{"id":"new","etag":"\"1\"","summary":%q}`, e.Summary))
+		case http.MethodPatch:
+			apiError(w, http.StatusPreconditionFailed, "conditionNotMet")
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	c := api.client(teamCalendar, nil, nil)
+
+	ops := []BatchOp{
+		{Kind: BatchCreate, TaskUUID: "u1", Event: &calendar.Event{Summary: "Write report"}},
+		{Kind: BatchPatch, TaskUUID: "u2", EventID: "ev2", ETag: `"7"`, Event: &calendar.Event{Summary: "! Call"}},
+		{Kind: BatchDelete, TaskUUID: "u3", Role: "deadline", EventID: "ev3"},
+	}
+	results, err := c.Batch(context.Background(), ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	items := api.batches[0]
+	if len(api.batches) != 1 || len(items) != 3 {
+		t.Fatalf("expected one batch of 3, got %d batches", len(api.batches))
+	}
+	wantPath := "/calendar/v3/calendars/" + teamCalendar + "/events"
+	if items[0].req.Method != http.MethodPost || items[0].req.URL.Path != wantPath {
+		t.Errorf("create sent as %s %s", items[0].req.Method, items[0].req.URL.Path)
+	}
+	if items[1].req.URL.Path != wantPath+"/ev2" || items[1].req.Header.Get("If-Match") != `"7"` {
+		t.Errorf("patch sent to %s with If-Match %q", items[1].req.URL.Path, items[1].req.Header.Get("If-Match"))
+	}
+	if items[2].req.Method != http.MethodDelete || items[2].body != "" {
+		t.Errorf("delete sent as %s with body %q", items[2].req.Method, items[2].body)
+	}
+
+	if results[0].Err != nil || results[0].Event.Id != "new" || results[0].Event.Summary != "Write report" || results[0].Op.TaskUUID != "u1" {
+		t.Errorf("create: got %+v, %v", results[0].Event, results[0].Err)
+	}
+	var apiErr *googleapi.Error
+	if !errors.As(results[1].Err, &apiErr) || apiErr.Code != http.StatusPreconditionFailed || results[1].Op.TaskUUID != "u2" {
+		t.Errorf("patch: expected a 412 for u2, got %v", results[1].Err)
+	}
+	if results[2].Err != nil || results[2].Event != nil || results[2].Op.Role != "deadline" {
+		t.Errorf("delete: got %+v, %v", results[2].Event, results[2].Err)
+	}
+}
+
+func TestBatchSplitsLargeBatches(t *testing.T) {
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	c := api.client(teamCalendar, nil, nil)
+
+	ops := make([]BatchOp, maxBatchSize+1)
+	for i := range ops {
+		ops[i] = BatchOp{Kind: BatchDelete, TaskUUID: fmt.Sprintf("u%d", i), EventID: fmt.Sprintf("ev%d", i)}
+	}
+	results, err := c.Batch(context.Background(), ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(api.batches) != 2 || len(api.batches[0]) != maxBatchSize || len(api.batches[1]) != 1 {
+		t.Fatalf("expected batches of %d and 1, got %d batches", maxBatchSize, len(api.batches))
+	}
+	if len(results) != len(ops) || results[maxBatchSize].Op.EventID != fmt.Sprintf("ev%d", maxBatchSize) {
+		t.Errorf("results out of order: %d results", len(results))
+	}
+}
+
+func TestBatchRetriesRateLimitedItems(t *testing.T) {
+	calls := 0
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if r.URL.Path == "/calendar/v3/calendars/"+teamCalendar+"/events/ev2" && calls <= 2 {
+			apiError(w, http.StatusTooManyRequests, "rateLimitExceeded")
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+	c := api.client(teamCalendar, nil, nil)
+
+	ops := []BatchOp{
+		{Kind: BatchDelete, TaskUUID: "u1", EventID: "ev1"},
+		{Kind: BatchDelete, TaskUUID: "u2", EventID: "ev2"},
+	}
+	results, err := c.Batch(context.Background(), ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(api.batches) != 2 || len(api.batches[1]) != 1 {
+		t.Fatalf("expected the rate limited item to be sent again alone, got %d batches", len(api.batches))
+	}
+	for _, res := range results {
+		if res.Err != nil {
+			t.Errorf("%s: %v", res.Op.TaskUUID, res.Err)
+		}
+	}
+}
+
+func TestBatchCreateIsNotDuplicated(t *testing.T) {
+	var sent []string
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodPost:
+			// The first attempt created the event, but its response was lost
+			var e calendar.Event
+			json.NewDecoder(r.Body).Decode(&e)
+			sent = append(sent, e.Id)
+			if len(sent) == 1 {
+				apiError(w, http.StatusServiceUnavailable, "backendError")
+				return
+			}
+			apiError(w, http.StatusConflict, "duplicate")
+		case http.MethodGet:
+			reply(w, http.StatusOK, fmt.Sprintf(`{"id":%q,"etag":"\"1\"","summary":"Write report"}`, path.Base(r.URL.Path)))
+		default:
+			notFound(w)
+		}
+	})
+	c := api.client(teamCalendar, nil, nil)
+
+	event := &calendar.Event{Summary: "Write report"}
+	results, err := c.Batch(context.Background(), []BatchOp{{Kind: BatchCreate, TaskUUID: "u1", Event: event}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 2 || sent[0] == "" || sent[0] != sent[1] {
+		t.Fatalf("the retried create must reuse its event ID, sent %q", sent)
+	}
+	if event.Id != "" {
+		t.Error("Batch set the ID on the caller's event")
+	}
+	if res := results[0]; res.Err != nil || res.Event == nil || res.Event.Id != sent[0] {
+		t.Errorf("the duplicate create should return the existing event, got %+v, %v", res.Event, res.Err)
+	}
+}
+
+func TestRefreshSummaries(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		reply(w, http.StatusOK, `{"id":"ev1","etag":"\"2\""}`)
+	})
+	idx, err := index.NewEventIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := api.client(teamCalendar, idx, nil)
+	idx.Put("u1", index.Entry{EventID: "ev1", ETag: `"1"`, Hash: "h"})
+
+	past := &taskwarrior.CustomTime{Time: time.Now().Add(-time.Minute)}
+	task := taskwarrior.Task{UUID: "u1", Description: "Write report", Status: taskwarrior.PENDING, Scheduled: past}
+	// u2 has no event on this calendar, so nothing is sent for it
+	other := taskwarrior.Task{UUID: "u2", Description: "Other", Status: taskwarrior.PENDING, Scheduled: past}
+	if errs := c.RefreshSummaries(context.Background(), []taskwarrior.Task{task, other}); len(errs) != 0 {
+		t.Fatal(errs)
+	}
+
+	if len(api.batches) != 1 || len(api.batches[0]) != 1 {
+		t.Fatalf("expected one patch, got %v", api.batches)
+	}
+	item := api.batches[0][0]
+	if item.req.Method != http.MethodPatch || item.body != `{"summary":"! Write report"}` || item.req.Header.Get("If-Match") != `"1"` {
+		t.Errorf("sent %s %s If-Match %s", item.req.Method, item.body, item.req.Header.Get("If-Match"))
+	}
+	if entry, _ := idx.Entry("u1"); entry.ETag != `"2"` || entry.Hash != "" {
+		t.Errorf("index entry not updated: %+v", entry)
+	}
+}
+
+func TestFullPatchClearsColor(t *testing.T) {
+	body, err := fullPatch(&calendar.Event{Summary: "Write"}).MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"colorId":null`) {
+		t.Errorf("patch keeps the old colour: %s", body)
+	}
+	body, _ = fullPatch(&calendar.Event{Summary: "Write", ColorId: "3"}).MarshalJSON()
+	if !strings.Contains(string(body), `"colorId":"3"`) {
+		t.Errorf("patch drops the colour: %s", body)
+	}
+}
@@ -0,0 +1,289 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/logging"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"github.com/harrisonrobin/taska/pkg/util"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// PatchEvents applies patches outside a task sync, such as the overdue marks,
+// in batches. Indexed events take their new etags as with PatchEvent. It
+// returns the errors of the failed patches by task UUID.
+func (c *CalendarClient) PatchEvents(ctx context.Context, ops []BatchOp) map[string]error {
+	errs := make(map[string]error)
+	results, err := c.Batch(ctx, ops)
+	for _, res := range results {
+		if res.Err != nil {
+			errs[res.Op.TaskUUID] = res.Err
+			continue
+		}
+		c.notePatched(res.Event)
+	}
+	if err != nil {
+		// The operations after a failed request never got a result
+		for _, op := range ops[len(results):] {
+			errs[op.TaskUUID] = err
+		}
+	}
+	return errs
+}
+
+// RefreshSummaries patches the summaries of the tasks' indexed events to what
+// the tasks render to now. A summary changes without the task changing when
+// time passes, e.g. it gets the overdue mark once the scheduled date is past.
+// Patches only apply to events unchanged since taska last wrote them; an
+// event edited on the calendar is left to the next sync of its task. It
+// returns the errors of the failed tasks by UUID.
+func (c *CalendarClient) RefreshSummaries(ctx context.Context, tasks []taskwarrior.Task) map[string]error {
+	errs := make(map[string]error)
+	var ops []BatchOp
+	for _, task := range tasks {
+		events, err := c.RenderTaskEvents(task)
+		if err != nil {
+			errs[task.UUID] = err
+			continue
+		}
+		for role, event := range events {
+			entry, ok := c.IndexEntry(index.Key(task.UUID, role))
+			if !ok {
+				continue
+			}
+			ops = append(ops, BatchOp{
+				Kind:     BatchPatch,
+				TaskUUID: task.UUID,
+				Role:     role,
+				EventID:  entry.EventID,
+				Event:    &calendar.Event{Summary: event.Summary},
+				ETag:     entry.ETag,
+			})
+		}
+	}
+	if len(ops) == 0 {
+		return errs
+	}
+	for uuid, err := range c.PatchEvents(ctx, ops) {
+		errs[uuid] = err
+	}
+	return errs
+}
+
+// SyncTasks syncs many tasks at once, like SyncEvent, SyncDeadlineEvent and
+// DeleteTaskEvents for each of them but with far fewer requests. Events are
+// created, patched and deleted in batches. A patch of an indexed event is the
+// full rendering of the task and only applies if the event still has its
+// indexed etag. Events that are not indexed are looked up with a single
+// listing of the calendar. Events that were edited on the calendar or lost
+// fall back to the per-task sync, which handles conflicts. It returns the
+// errors of the tasks that failed by UUID.
+func (c *CalendarClient) SyncTasks(ctx context.Context, tasks []taskwarrior.Task) map[string]error {
+	s := &bulkSync{c: c, errs: make(map[string]error), tasks: make(map[string]taskwarrior.Task), hashes: make(map[string]string)}
+
+	var unindexed []roleEvent
+	for _, task := range tasks {
+		s.tasks[task.UUID] = task
+		events, err := c.RenderTaskEvents(task)
+		if err != nil {
+			s.errs[task.UUID] = err
+			continue
+		}
+		for _, role := range index.Roles {
+			key := index.Key(task.UUID, role)
+			entry, indexed := c.IndexEntry(key)
+			event := events[role]
+			switch {
+			case !indexed:
+				if event != nil || !task.WantsEvent() {
+					unindexed = append(unindexed, roleEvent{task.UUID, role, event})
+				}
+			case event == nil:
+				s.add(BatchOp{Kind: BatchDelete, TaskUUID: task.UUID, Role: role, EventID: entry.EventID}, "")
+			default:
+				hash := util.EventHash(event)
+				if hash != "" && hash == entry.Hash && entry.CalendarID == c.calendarID {
+					continue
+				}
+				s.add(BatchOp{Kind: BatchPatch, TaskUUID: task.UUID, Role: role, EventID: entry.EventID, Event: fullPatch(event), ETag: entry.ETag}, hash)
+			}
+		}
+	}
+	s.lookUp(unindexed)
+
+	results, err := c.Batch(ctx, s.ops)
+	if err != nil {
+		slog.Warn("batch request failed, syncing tasks one by one", logging.Op("batch"), "error", err)
+		for _, op := range s.ops[len(results):] {
+			s.slow(op.TaskUUID)
+		}
+	}
+	for _, res := range results {
+		s.apply(res)
+	}
+
+	for _, uuid := range s.fallback {
+		if s.errs[uuid] != nil {
+			continue
+		}
+		if err := c.syncTask(s.tasks[uuid]); err != nil {
+			s.errs[uuid] = err
+		}
+	}
+	return s.errs
+}
+
+// roleEvent is a task's rendered event with the given role, or nil if the
+// task should not have one.
+type roleEvent struct {
+	uuid  string
+	role  string
+	event *calendar.Event
+}
+
+// bulkSync is the state of one SyncTasks run.
+type bulkSync struct {
+	c      *CalendarClient
+	tasks  map[string]taskwarrior.Task
+	ops    []BatchOp
+	hashes map[string]string
+	errs   map[string]error
+	// fallback lists the tasks left to the per-task sync.
+	fallback []string
+}
+
+func (s *bulkSync) add(op BatchOp, hash string) {
+	s.ops = append(s.ops, op)
+	s.hashes[index.Key(op.TaskUUID, op.Role)] = hash
+}
+
+func (s *bulkSync) slow(uuid string) {
+	for _, queued := range s.fallback {
+		if queued == uuid {
+			return
+		}
+	}
+	s.fallback = append(s.fallback, uuid)
+}
+
+// lookUp finds the events the index doesn't know. A single one is left to
+// the per-task sync, which searches for it; more are matched against one
+// listing of the calendar's task events.
+func (s *bulkSync) lookUp(unindexed []roleEvent) {
+	if len(unindexed) == 0 {
+		return
+	}
+	var remote map[string]*calendar.Event
+	if len(unindexed) > 1 {
+		events, err := s.c.ListTaskEvents()
+		if err != nil {
+			slog.Warn("could not list task events, searching one by one", logging.Op("batch"), "error", err)
+		} else {
+			remote = make(map[string]*calendar.Event, len(events))
+			for _, e := range events {
+				remote[index.Key(EventTaskID(e), EventRole(e))] = e
+			}
+		}
+	}
+	if remote == nil {
+		for _, u := range unindexed {
+			s.slow(u.uuid)
+		}
+		return
+	}
+
+	for _, u := range unindexed {
+		key := index.Key(u.uuid, u.role)
+		existing := remote[key]
+		switch {
+		case u.event == nil && existing == nil:
+		case u.event == nil:
+			s.add(BatchOp{Kind: BatchDelete, TaskUUID: u.uuid, Role: u.role, EventID: existing.Id}, "")
+		case existing == nil:
+			s.add(BatchOp{Kind: BatchCreate, TaskUUID: u.uuid, Role: u.role, Event: u.event}, util.EventHash(u.event))
+		default:
+			task := s.tasks[u.uuid]
+			patch, err := util.EventNeedsUpdate(&task, existing, u.event)
+			if err != nil {
+				s.slow(u.uuid)
+				continue
+			}
+			if patch == nil {
+				s.c.recordSync(key, existing, util.EventHash(u.event))
+				continue
+			}
+			s.add(BatchOp{Kind: BatchPatch, TaskUUID: u.uuid, Role: u.role, EventID: existing.Id, Event: patch, ETag: existing.Etag}, util.EventHash(u.event))
+		}
+	}
+}
+
+// apply records the outcome of one batch operation.
+func (s *bulkSync) apply(res BatchResult) {
+	op := res.Op
+	key := index.Key(op.TaskUUID, op.Role)
+	switch {
+	case res.Err == nil && op.Kind == BatchDelete:
+		slog.Info("deleted event", logging.Op("delete"), logging.Task(op.TaskUUID), logging.Event(op.EventID), "role", index.RoleName(op.Role))
+		s.forget(key)
+	case res.Err == nil:
+		msg := "patched event"
+		if op.Kind == BatchCreate {
+			msg = "created event"
+		}
+		slog.Info(msg, logging.Op(op.Kind), logging.Task(op.TaskUUID), logging.Event(res.Event.Id), "role", index.RoleName(op.Role))
+		s.c.recordSync(key, res.Event, s.hashes[key])
+	case op.Kind == BatchDelete && isGone(res.Err):
+		s.forget(key)
+	case isPreconditionFailed(res.Err) || isGone(res.Err):
+		s.slow(op.TaskUUID)
+	default:
+		slog.Error("batch operation failed", logging.Op(op.Kind), logging.Task(op.TaskUUID), logging.Event(op.EventID), "role", index.RoleName(op.Role), "error", res.Err)
+		s.errs[op.TaskUUID] = res.Err
+	}
+}
+
+func (s *bulkSync) forget(key string) {
+	if s.c.index != nil {
+		s.c.index.Remove(key)
+	}
+}
+
+// syncTask brings all of a task's events in line with the task.
+func (c *CalendarClient) syncTask(task taskwarrior.Task) error {
+	if !task.WantsEvent() {
+		return c.DeleteTaskEvents(task.UUID)
+	}
+	if _, err := c.SyncEvent(task); err != nil {
+		return err
+	}
+	_, err := c.SyncDeadlineEvent(task)
+	return err
+}
+
+// fullPatch turns a rendered event into a patch that also sets the fields a
+// redacted event clears.
+func fullPatch(event *calendar.Event) *calendar.Event {
+	patch := *event
+	patch.ForceSendFields = append([]string{"Description"}, event.ForceSendFields...)
+	if patch.Visibility == "" {
+		patch.Visibility = "default"
+	}
+	if patch.Transparency == "" {
+		patch.Transparency = "opaque"
+	}
+	if patch.ColorId == "" {
+		patch.NullFields = append(append([]string(nil), event.NullFields...), "ColorId")
+	}
+	return &patch
+}
+
+// isGone reports whether the event no longer exists.
+func isGone(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && (apiErr.Code == http.StatusNotFound || apiErr.Code == http.StatusGone)
+}
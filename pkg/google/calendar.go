@@ -1,110 +1,454 @@
 package google
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"net/http"
+	"strings"
 	"time"
 
+	"github.com/harrisonrobin/taska/pkg/config"
 	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/logging"
 	"github.com/harrisonrobin/taska/pkg/taskwarrior"
 	"github.com/harrisonrobin/taska/pkg/util"
 	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
 )
 
 // CalendarClient is a Google Calendar API client.
 type CalendarClient struct {
-	srv        *calendar.Service
-	calendarID string
-	index      *index.EventIndex
+	srv          *calendar.Service
+	calendarID   string
+	calendarName string
+	index        *index.EventIndex
+	cfg          *config.Config
+	exec         *Executor
+	// httpClient sends batch requests; set by NewClient.
+	httpClient *http.Client
+	// renameWarned is set once the calendar was found renamed.
+	renameWarned bool
 }
 
 // NewCalendarClient creates a new Google Calendar client.
-func NewCalendarClient(srv *calendar.Service, calendarID string, idx *index.EventIndex) *CalendarClient {
-	return &CalendarClient{srv: srv, calendarID: calendarID, index: idx}
+// cfg controls how tasks are rendered and may be nil to use the defaults.
+func NewCalendarClient(srv *calendar.Service, calendarID string, idx *index.EventIndex, cfg *config.Config) *CalendarClient {
+	return &CalendarClient{srv: srv, calendarID: calendarID, index: idx, cfg: cfg, exec: defaultExecutor}
 }
 
 // SyncEvent creates a new event or updates an existing one.
 func (c *CalendarClient) SyncEvent(task taskwarrior.Task) (*calendar.Event, error) {
-	event, err := util.ConvertTaskToCalendarEvent(&task)
+	event, err := util.ConvertTaskToCalendarEvent(&task, c.cfg)
 	if err != nil {
 		return nil, err
 	}
+	c.redact(&task, event)
+	return c.syncRoleEvent(task, index.RolePrimary, event)
+}
 
-	var existingEvent *calendar.Event
-	// 1. Try local index first
-	if c.index != nil {
-		eventID := c.index.Get(task.UUID)
-		if eventID != "" {
-			existingEvent, err = c.srv.Events.Get(c.calendarID, eventID).Do()
-			if err != nil {
-				// If not found or error, fallback to search
-				existingEvent = nil
-			}
-		}
+// SyncDeadlineEvent creates or updates the deadline marker of a task, or
+// removes it when the task no longer needs one (e.g. it was completed).
+// It returns a nil event when the task has no marker.
+func (c *CalendarClient) SyncDeadlineEvent(task taskwarrior.Task) (*calendar.Event, error) {
+	event, err := util.ConvertTaskToDeadlineEvent(&task, c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	if event == nil {
+		return nil, c.deleteRoleEvent(task.UUID, index.RoleDeadline)
 	}
+	c.redact(&task, event)
+	return c.syncRoleEvent(task, index.RoleDeadline, event)
+}
 
-	// 2. Fallback to API search if not found in index or index failed
-	if existingEvent == nil {
-		existingEvent, err = c.GetEventByTaskID(task.UUID)
+// RenderTaskEvents renders the events a task should have on this calendar,
+// keyed by role. It returns an error if the task cannot be synced (no dates).
+func (c *CalendarClient) RenderTaskEvents(task taskwarrior.Task) (map[string]*calendar.Event, error) {
+	events := make(map[string]*calendar.Event)
+	if !task.WantsEvent() {
+		return events, nil
+	}
+
+	event, err := util.ConvertTaskToCalendarEvent(&task, c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	c.redact(&task, event)
+	events[index.RolePrimary] = event
+
+	deadline, err := util.ConvertTaskToDeadlineEvent(&task, c.cfg)
+	if err != nil {
+		return nil, err
+	}
+	if deadline != nil {
+		c.redact(&task, deadline)
+		events[index.RoleDeadline] = deadline
+	}
+	return events, nil
+}
+
+// redact applies the privacy level configured for the task on this calendar.
+func (c *CalendarClient) redact(task *taskwarrior.Task, event *calendar.Event) {
+	if c.cfg == nil {
+		return
+	}
+	level := c.cfg.PrivacyLevel(task, c.calendarName, c.calendarID)
+	util.ApplyPrivacy(event, level, c.cfg.Privacy.BusyTransparency)
+}
+
+// syncRoleEvent creates or patches the task's event with the given role so
+// that it matches the rendered event. When the task renders exactly as at its
+// last sync the API is not called at all, and the returned event only carries
+// the ID and etag from the index.
+func (c *CalendarClient) syncRoleEvent(task taskwarrior.Task, role string, event *calendar.Event) (*calendar.Event, error) {
+	key := index.Key(task.UUID, role)
+	hash := util.EventHash(event)
+	if entry, ok := c.IndexEntry(key); ok && hash != "" && entry.Hash == hash && entry.CalendarID == c.calendarID {
+		slog.Debug("event unchanged since last sync", logging.Op("skip"), logging.Task(task.UUID), logging.Event(entry.EventID), "role", index.RoleName(role))
+		return &calendar.Event{Id: entry.EventID, Etag: entry.ETag}, nil
+	}
+
+	for attempt := 1; ; attempt++ {
+		existingEvent, err := c.findEvent(task.UUID, role)
 		if err != nil {
 			return nil, fmt.Errorf("error searching for event: %w", err)
 		}
-	}
 
-	if existingEvent != nil {
-		patch, err := util.EventNeedsUpdate(&task, existingEvent, event)
+		if existingEvent != nil {
+			patch, err := util.EventNeedsUpdate(&task, existingEvent, event)
+			if err != nil {
+				slog.Error("could not compare task with its calendar event",
+					logging.Op("compare"), logging.Task(task.UUID), logging.Event(existingEvent.Id), "role", index.RoleName(role), "error", err)
+				return nil, err
+			}
+			if patch != nil && c.editedOnCalendar(key, existingEvent) {
+				keepCalendar, err := c.handleConflict(task, role, key, existingEvent, event)
+				if err != nil {
+					return nil, err
+				}
+				if keepCalendar {
+					return existingEvent, nil
+				}
+			}
+			if patch != nil {
+				// Surgical Patch, only if nobody changed the event since we read it
+				updatedEvent, err := c.patchEventIfMatch(existingEvent.Id, patch, existingEvent.Etag)
+				if isPreconditionFailed(err) && attempt == 1 {
+					slog.Info("event changed while syncing, retrying", logging.Op("patch"), logging.Task(task.UUID), logging.Event(existingEvent.Id), "role", index.RoleName(role))
+					continue
+				}
+				if err != nil {
+					return nil, err
+				}
+				slog.Info("patched event", logging.Op("patch"), logging.Task(task.UUID), logging.Event(updatedEvent.Id), "role", index.RoleName(role))
+				c.recordSync(key, updatedEvent, hash)
+				return updatedEvent, nil
+			}
+			slog.Debug("event up to date", logging.Op("noop"), logging.Task(task.UUID), logging.Event(existingEvent.Id), "role", index.RoleName(role))
+			c.recordSync(key, existingEvent, hash)
+			return existingEvent, nil
+		}
+
+		createdEvent, err := c.insertEvent(event)
 		if err != nil {
-			log.Printf("could not compare task with its calendar event: %v", err)
 			return nil, err
 		}
-		if patch != nil {
-			// Surgical Patch
-			updatedEvent, err := c.PatchEvent(existingEvent.Id, patch)
-			if err == nil && c.index != nil {
-				c.index.Set(task.UUID, updatedEvent.Id)
-			}
-			return updatedEvent, err
+		slog.Info("created event", logging.Op("create"), logging.Task(task.UUID), logging.Event(createdEvent.Id), "role", index.RoleName(role))
+		c.recordSync(key, createdEvent, hash)
+		return createdEvent, nil
+	}
+}
+
+// IndexEntry returns the index entry under key if it belongs to this
+// calendar. Entries written before calendars were recorded are trusted too.
+func (c *CalendarClient) IndexEntry(key string) (index.Entry, bool) {
+	if c.index == nil {
+		return index.Entry{}, false
+	}
+	entry, ok := c.index.Entry(key)
+	if !ok || entry.EventID == "" || (entry.CalendarID != "" && entry.CalendarID != c.calendarID) {
+		return index.Entry{}, false
+	}
+	return entry, true
+}
+
+// recordSync stores the event and the hash of the rendering it now matches.
+func (c *CalendarClient) recordSync(key string, event *calendar.Event, hash string) {
+	if c.index == nil {
+		return
+	}
+	c.index.Put(key, index.Entry{
+		EventID:    event.Id,
+		CalendarID: c.calendarID,
+		ETag:       event.Etag,
+		Hash:       hash,
+		SyncedAt:   time.Now(),
+	})
+}
+
+// findEvent looks up the task's event with the given role, first through the
+// local index and then by searching the calendar. It returns nil if there is none.
+func (c *CalendarClient) findEvent(taskID, role string) (*calendar.Event, error) {
+	// 1. Try local index first
+	if entry, ok := c.IndexEntry(index.Key(taskID, role)); ok {
+		existingEvent, err := c.getEvent(entry.EventID)
+		// If not found, deleted or error, fallback to search
+		if err == nil && existingEvent.Status != "cancelled" {
+			return existingEvent, nil
+		}
+	}
+
+	// 2. Fallback to API search if not found in index or index failed
+	return c.GetEventByTaskRole(taskID, role)
+}
+
+// deleteRoleEvent deletes the task's event with the given role, if any, and
+// forgets its mapping. An event already gone counts as deleted: a delete
+// whose response was lost gets 404 or 410 when retried.
+func (c *CalendarClient) deleteRoleEvent(taskID, role string) error {
+	event, err := c.findEvent(taskID, role)
+	if err != nil {
+		return err
+	}
+	if event != nil {
+		err := c.DeleteEvent(event.Id)
+		switch {
+		case isGone(err):
+			slog.Info("event already deleted", logging.Op("delete"), logging.Task(taskID), logging.Event(event.Id), "role", index.RoleName(role))
+		case err != nil:
+			return err
+		default:
+			slog.Info("deleted event", logging.Op("delete"), logging.Task(taskID), logging.Event(event.Id), "role", index.RoleName(role))
 		}
-		return existingEvent, nil
 	}
+	if c.index != nil {
+		c.index.Remove(index.Key(taskID, role))
+	}
+	return nil
+}
 
-	createdEvent, err := c.srv.Events.Insert(c.calendarID, event).Do()
-	if err == nil && c.index != nil {
-		c.index.Set(task.UUID, createdEvent.Id)
+// DeleteTaskEvents deletes every event a task owns (work block and deadline
+// marker) and removes them from the index.
+func (c *CalendarClient) DeleteTaskEvents(taskID string) error {
+	var errs []error
+	for _, role := range index.Roles {
+		if err := c.deleteRoleEvent(taskID, role); err != nil {
+			errs = append(errs, fmt.Errorf("deleting %s event: %w", index.RoleName(role), err))
+		}
 	}
-	return createdEvent, err
+	return errors.Join(errs...)
 }
 
-// PatchEvent performs a partial update on an event.
+// PatchEvent performs a partial update on an event. If the event is indexed,
+// its entry takes the new etag and no longer counts as matching its task, so
+// the next sync compares it with the calendar again.
 func (c *CalendarClient) PatchEvent(eventID string, patch *calendar.Event) (*calendar.Event, error) {
-	return c.srv.Events.Patch(c.calendarID, eventID, patch).Do()
+	updated, err := execute(context.Background(), c.exec, func(ctx context.Context) (*calendar.Event, error) {
+		return c.srv.Events.Patch(c.calendarID, eventID, patch).Context(ctx).Do()
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.notePatched(updated)
+	return updated, nil
+}
+
+// notePatched records the new etag of an event patched outside a task sync.
+func (c *CalendarClient) notePatched(updated *calendar.Event) {
+	if c.index == nil {
+		return
+	}
+	if key, ok := c.index.KeyForEvent(updated.Id); ok {
+		entry, _ := c.index.Entry(key)
+		entry.ETag = updated.Etag
+		entry.Hash = ""
+		c.index.Put(key, entry)
+	}
+}
+
+// patchEventIfMatch patches an event only if it still has the given etag.
+func (c *CalendarClient) patchEventIfMatch(eventID string, patch *calendar.Event, etag string) (*calendar.Event, error) {
+	return execute(context.Background(), c.exec, func(ctx context.Context) (*calendar.Event, error) {
+		call := c.srv.Events.Patch(c.calendarID, eventID, patch).Context(ctx)
+		if etag != "" {
+			call.Header().Set("If-Match", etag)
+		}
+		return call.Do()
+	})
+}
+
+// getEvent fetches an event by ID.
+func (c *CalendarClient) getEvent(eventID string) (*calendar.Event, error) {
+	return execute(context.Background(), c.exec, func(ctx context.Context) (*calendar.Event, error) {
+		return c.srv.Events.Get(c.calendarID, eventID).Context(ctx).Do()
+	})
+}
+
+// insertEvent creates an event under an ID chosen here, so that retrying an
+// insert whose first attempt did reach the server cannot create the event
+// twice: the retry fails with 409 and the event already created is returned.
+func (c *CalendarClient) insertEvent(event *calendar.Event) (*calendar.Event, error) {
+	event = withEventID(event)
+	created, err := onCalendar(c, func(ctx context.Context) (*calendar.Event, error) {
+		return c.srv.Events.Insert(c.calendarID, event).Context(ctx).Do()
+	})
+	if isDuplicate(err) {
+		return c.getEvent(event.Id)
+	}
+	return created, err
+}
+
+// withEventID returns the event with a new random ID if it has none.
+func withEventID(event *calendar.Event) *calendar.Event {
+	if event.Id != "" {
+		return event
+	}
+	withID := *event
+	withID.Id = newEventID()
+	return &withID
+}
+
+// newEventID returns a random event ID. Event IDs are 5 to 1024 characters
+// of lowercase base32hex (0-9 and a-v).
+func newEventID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return strings.ToLower(base32.HexEncoding.WithPadding(base32.NoPadding).EncodeToString(b))
+}
+
+// isDuplicate reports whether an insert failed because an event with its ID
+// already exists.
+func isDuplicate(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusConflict
+}
+
+// isPreconditionFailed reports whether an If-Match condition failed.
+func isPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
 }
 
 // DeleteEvent deletes an event from the calendar.
 func (c *CalendarClient) DeleteEvent(eventID string) error {
-	return c.srv.Events.Delete(c.calendarID, eventID).Do()
+	return c.exec.Do(context.Background(), func(ctx context.Context) error {
+		return c.srv.Events.Delete(c.calendarID, eventID).Context(ctx).Do()
+	})
 }
 
-// ListEvents fetches events from the calendar within a given time range.
+// ListEvents returns the events of the calendar that end after timeMin, in
+// order of their start. Recurring events are expanded into their instances.
 func (c *CalendarClient) ListEvents(timeMin time.Time) ([]*calendar.Event, error) {
-	events, err := c.srv.Events.List(c.calendarID).TimeMin(timeMin.Format(time.RFC3339)).Do()
-	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve events from calendar: %w", err)
+	var events []*calendar.Event
+	pageToken := ""
+	for {
+		page, err := onCalendar(c, func(ctx context.Context) (*calendar.Events, error) {
+			call := c.srv.Events.List(c.calendarID).TimeMin(timeMin.Format(time.RFC3339)).
+				SingleEvents(true).OrderBy("startTime").MaxResults(2500).Context(ctx)
+			if pageToken != "" {
+				call.PageToken(pageToken)
+			}
+			return call.Do()
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve events from calendar: %w", err)
+		}
+		c.noteSummary(page.Summary)
+		events = append(events, page.Items...)
+		if page.NextPageToken == "" {
+			return events, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// ListTaskEvents returns every event on the calendar that taska manages,
+// i.e. that carries a task UUID in its private extended properties.
+func (c *CalendarClient) ListTaskEvents() ([]*calendar.Event, error) {
+	var events []*calendar.Event
+	pageToken := ""
+	for {
+		// Pages are fetched one by one so a retry repeats only the failed page
+		page, err := onCalendar(c, func(ctx context.Context) (*calendar.Events, error) {
+			call := c.srv.Events.List(c.calendarID).ShowDeleted(false).MaxResults(2500).Context(ctx)
+			if pageToken != "" {
+				call.PageToken(pageToken)
+			}
+			return call.Do()
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve events from calendar: %w", err)
+		}
+		c.noteSummary(page.Summary)
+		for _, event := range page.Items {
+			if EventTaskID(event) != "" {
+				events = append(events, event)
+			}
+		}
+		if page.NextPageToken == "" {
+			return events, nil
+		}
+		pageToken = page.NextPageToken
 	}
-	return events.Items, nil
 }
 
-// GetEventByTaskID searches for an event with the given Taskwarrior ID in extended properties.
+// EventTaskID returns the UUID of the task an event belongs to, or "" if the
+// event was not created by taska.
+func EventTaskID(event *calendar.Event) string {
+	if event.ExtendedProperties == nil {
+		return ""
+	}
+	return event.ExtendedProperties.Private[util.TaskIDProperty]
+}
+
+// CalendarName returns the name the client was opened with, or its ID.
+func (c *CalendarClient) CalendarName() string {
+	if c.calendarName != "" {
+		return c.calendarName
+	}
+	return c.calendarID
+}
+
+// GetEventByTaskID searches for the primary event of the task with the given
+// Taskwarrior ID in extended properties.
 func (c *CalendarClient) GetEventByTaskID(taskID string) (*calendar.Event, error) {
+	return c.GetEventByTaskRole(taskID, index.RolePrimary)
+}
+
+// GetEventByTaskRole searches for the task's event with the given role in
+// extended properties.
+func (c *CalendarClient) GetEventByTaskRole(taskID, role string) (*calendar.Event, error) {
 	// Look for private extended property 'taskwarrior_id'
-	events, err := c.srv.Events.List(c.calendarID).
-		PrivateExtendedProperty(fmt.Sprintf("taskwarrior_id=%s", taskID)).
-		Do()
+	filters := []string{fmt.Sprintf("%s=%s", util.TaskIDProperty, taskID)}
+	if role != index.RolePrimary {
+		filters = append(filters, fmt.Sprintf("%s=%s", util.RoleProperty, role))
+	}
+	events, err := onCalendar(c, func(ctx context.Context) (*calendar.Events, error) {
+		return c.srv.Events.List(c.calendarID).
+			PrivateExtendedProperty(filters...).
+			Context(ctx).
+			Do()
+	})
 	if err != nil {
 		return nil, err
 	}
-	if len(events.Items) > 0 {
-		return events.Items[0], nil
+	c.noteSummary(events.Summary)
+	for _, event := range events.Items {
+		if EventRole(event) == role {
+			return event, nil
+		}
 	}
 	return nil, nil
 }
+
+// EventRole returns the role stored on an event taska created.
+func EventRole(event *calendar.Event) string {
+	if event.ExtendedProperties == nil {
+		return index.RolePrimary
+	}
+	return event.ExtendedProperties.Private[util.RoleProperty]
+}
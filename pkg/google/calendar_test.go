@@ -0,0 +1,315 @@
+package google
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"github.com/harrisonrobin/taska/pkg/util"
+	"google.golang.org/api/calendar/v3"
+)
+
+// eventStore is a fake events collection of one calendar. Events are kept as
+// JSON objects so that patches merge as on the real API, and every write
+// bumps the event's etag. Patches with a stale If-Match fail with 412.
+type eventStore struct {
+	t      *testing.T
+	events map[string]map[string]any
+	// requests lists the requests received, "METHOD id" or "METHOD" for the
+	// collection, with " If-Match: <etag>" when a patch had one.
+	requests []string
+	// beforePatch, if set, runs before a patch is applied, e.g. to edit the
+	// event as someone else would in the meantime.
+	beforePatch func(id string)
+	// loseDeletes makes deletes fail with 503 after deleting the event, as
+	// when the response is lost on the way.
+	loseDeletes bool
+}
+
+func newEventStore(t *testing.T) *eventStore {
+	return &eventStore{t: t, events: make(map[string]map[string]any)}
+}
+
+// handler serves the events of calendarID from the store.
+func (s *eventStore) handler(calendarID string) http.HandlerFunc {
+	prefix := "/calendar/v3/calendars/" + calendarID + "/events"
+	return func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.URL.Path, prefix) {
+			notFound(w)
+			return
+		}
+		s.serve(w, r, strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, prefix), "/"))
+	}
+}
+
+func (s *eventStore) serve(w http.ResponseWriter, r *http.Request, id string) {
+	w.Header().Set("Content-Type", "application/json")
+	request := r.Method
+	if id != "" {
+		request += " " + id
+	}
+	if etag := r.Header.Get("If-Match"); etag != "" {
+		request += " If-Match: " + etag
+	}
+	s.requests = append(s.requests, request)
+
+	switch {
+	case id == "" && r.Method == http.MethodGet:
+		var items []map[string]any
+		for _, event := range s.events {
+			if matchesProperties(event, r.URL.Query()["privateExtendedProperty"]) {
+				items = append(items, event)
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]any{"items": items})
+	case id == "" && r.Method == http.MethodPost:
+		var event map[string]any
+		json.NewDecoder(r.Body).Decode(&event)
+		if event["id"] == nil {
+			event["id"] = fmt.Sprintf("ev%d", len(s.events)+1)
+		}
+		event["etag"] = `"1"`
+		s.events[event["id"].(string)] = event
+		json.NewEncoder(w).Encode(event)
+	case s.events[id] == nil:
+		notFound(w)
+	case r.Method == http.MethodGet:
+		json.NewEncoder(w).Encode(s.events[id])
+	case r.Method == http.MethodPatch:
+		if s.beforePatch != nil {
+			s.beforePatch(id)
+		}
+		if etag := r.Header.Get("If-Match"); etag != "" && etag != s.events[id]["etag"] {
+			w.WriteHeader(http.StatusPreconditionFailed)
+			w.Write([]byte(`{"error":{"code":412,"message":"Precondition Failed","errors":[{"reason":"conditionNotMet"}]}}`))
+			return
+		}
+		var patch map[string]any
+		json.NewDecoder(r.Body).Decode(&patch)
+		s.edit(id, patch)
+		json.NewEncoder(w).Encode(s.events[id])
+	case r.Method == http.MethodDelete:
+		delete(s.events, id)
+		if s.loseDeletes {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte(`{"error":{"code":503,"message":"Backend Error"}}`))
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// put stores an event as it is on the calendar, with the given etag.
+func (s *eventStore) put(event *calendar.Event, etag string) {
+	s.t.Helper()
+	b, err := json.Marshal(event)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	var stored map[string]any
+	json.Unmarshal(b, &stored)
+	stored["etag"] = etag
+	s.events[event.Id] = stored
+}
+
+// edit changes fields of a stored event, removing those set to nil, and bumps
+// its etag.
+func (s *eventStore) edit(id string, fields map[string]any) {
+	event := s.events[id]
+	for k, v := range fields {
+		if v == nil {
+			delete(event, k)
+		} else {
+			event[k] = v
+		}
+	}
+	n, _ := strconv.Atoi(strings.Trim(event["etag"].(string), `"`))
+	event["etag"] = fmt.Sprintf(`"%d"`, n+1)
+}
+
+// get returns a stored event.
+func (s *eventStore) get(id string) *calendar.Event {
+	s.t.Helper()
+	b, _ := json.Marshal(s.events[id])
+	var event calendar.Event
+	if err := json.Unmarshal(b, &event); err != nil {
+		s.t.Fatal(err)
+	}
+	return &event
+}
+
+// count returns how many requests started with the method.
+func (s *eventStore) count(method string) int {
+	n := 0
+	for _, r := range s.requests {
+		if strings.HasPrefix(r, method+" ") || r == method {
+			n++
+		}
+	}
+	return n
+}
+
+func matchesProperties(event map[string]any, filters []string) bool {
+	props, _ := event["extendedProperties"].(map[string]any)
+	private, _ := props["private"].(map[string]any)
+	for _, f := range filters {
+		k, v, _ := strings.Cut(f, "=")
+		if private[k] != v {
+			return false
+		}
+	}
+	return true
+}
+
+// syncedTask sets up a task whose event was synced before, with the index
+// entry's hash and etag as recorded then.
+func syncedTask(t *testing.T) (*CalendarClient, *eventStore, taskwarrior.Task, *calendar.Event) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	idx, err := index.NewEventIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{Colors: config.ColorConfig{Strategy: config.ColorHash}}
+	store := newEventStore(t)
+	c := newFakeAPI(t, store.handler(teamCalendar)).client(teamCalendar, idx, cfg)
+
+	task := taskwarrior.Task{
+		UUID:        "u1",
+		Description: "Write report",
+		Status:      taskwarrior.PENDING,
+		Scheduled:   &taskwarrior.CustomTime{Time: time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)},
+	}
+	events, err := c.RenderTaskEvents(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered := events[index.RolePrimary]
+	synced := *rendered
+	synced.Id = "ev1"
+	store.put(&synced, `"1"`)
+	idx.Put("u1", index.Entry{EventID: "ev1", CalendarID: c.CalendarID(), ETag: `"1"`, Hash: util.EventHash(rendered)})
+	return c, store, task, rendered
+}
+
+func TestSyncRoleEventSkipsUnchangedTasks(t *testing.T) {
+	c, store, task, rendered := syncedTask(t)
+
+	event, err := c.syncRoleEvent(task, index.RolePrimary, rendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(store.requests) != 0 {
+		t.Errorf("an unchanged task made requests: %q", store.requests)
+	}
+	if event.Id != "ev1" || event.Etag != `"1"` {
+		t.Errorf("got %s %s, want the indexed event", event.Id, event.Etag)
+	}
+}
+
+func TestSyncRoleEventRetriesPreconditionFailed(t *testing.T) {
+	c, store, task, rendered := syncedTask(t)
+	task.Description = "Write the report"
+	rendered.Summary = "Write the report"
+
+	// The event is moved to another room between taska's read and its patch
+	store.beforePatch = func(id string) {
+		store.edit(id, map[string]any{"location": "Room 2"})
+		store.beforePatch = nil
+	}
+	event, err := c.syncRoleEvent(task, index.RolePrimary, rendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{`GET ev1`, `PATCH ev1 If-Match: "1"`, `GET ev1`, `PATCH ev1 If-Match: "2"`}
+	if strings.Join(store.requests, "\n") != strings.Join(want, "\n") {
+		t.Errorf("requests:\n%s\nwant:\n%s", strings.Join(store.requests, "\n"), strings.Join(want, "\n"))
+	}
+	stored := store.get("ev1")
+	if stored.Summary != "Write the report" || stored.Location != "Room 2" {
+		t.Errorf("calendar has %q in %q", stored.Summary, stored.Location)
+	}
+
+	// The index takes the etag the server returned for the patch
+	entry, _ := c.index.Entry("u1")
+	if event.Etag != stored.Etag || entry.ETag != stored.Etag || entry.Hash != util.EventHash(rendered) {
+		t.Errorf("entry %+v, returned etag %s, server etag %s", entry, event.Etag, stored.Etag)
+	}
+}
+
+func TestSyncRoleEventGivesUpAfterOneRetry(t *testing.T) {
+	c, store, task, rendered := syncedTask(t)
+	rendered.Summary = "Write the report"
+
+	// Someone keeps editing the event
+	store.beforePatch = func(id string) {
+		store.edit(id, map[string]any{"location": "Room " + store.get(id).Etag})
+	}
+	_, err := c.syncRoleEvent(task, index.RolePrimary, rendered)
+	if !isPreconditionFailed(err) {
+		t.Fatalf("got %v, want 412", err)
+	}
+	if n := store.count("PATCH"); n != 2 {
+		t.Errorf("sent %d patches, want 2", n)
+	}
+	if entry, _ := c.index.Entry("u1"); entry.ETag != `"1"` {
+		t.Errorf("a failed sync changed the index: %+v", entry)
+	}
+}
+
+func TestDeleteTaskEventsAlreadyGone(t *testing.T) {
+	c, store, task, _ := syncedTask(t)
+	store.loseDeletes = true
+
+	if err := c.DeleteTaskEvents(task.UUID); err != nil {
+		t.Fatal(err)
+	}
+	if n := store.count("DELETE"); n != 2 {
+		t.Errorf("sent %d deletes, want the lost one and its retry", n)
+	}
+	if _, ok := c.index.Entry("u1"); ok {
+		t.Error("the deleted event is still indexed")
+	}
+}
+
+func TestListEventsPages(t *testing.T) {
+	var queries []string
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		queries = append(queries, q.Encode())
+		if q.Get("pageToken") == "" {
+			reply(w, http.StatusOK, `{"summary":"Team","items":[{"id":"ev1"},{"id":"ev2"}],"nextPageToken":"p2"}`)
+			return
+		}
+		reply(w, http.StatusOK, `{"summary":"Team","items":[{"id":"ev3"}]}`)
+	})
+	c := api.client(teamCalendar, nil, nil)
+
+	events, err := c.ListEvents(time.Date(2026, 3, 2, 0, 0, 0, 0, time.UTC))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 3 || events[2].Id != "ev3" {
+		t.Fatalf("got %d events, want all 3 from both pages", len(events))
+	}
+	if len(queries) != 2 {
+		t.Fatalf("made %d requests, want 2", len(queries))
+	}
+	for _, want := range []string{"singleEvents=true", "orderBy=startTime", "timeMin=2026-03-02T00%3A00%3A00Z"} {
+		if !strings.Contains(queries[0], want) {
+			t.Errorf("query %s lacks %s", queries[0], want)
+		}
+	}
+	if !strings.Contains(queries[1], "pageToken=p2") {
+		t.Errorf("second query %s does not ask for the next page", queries[1])
+	}
+}
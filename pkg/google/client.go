@@ -2,47 +2,112 @@ package google
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 
 	"github.com/harrisonrobin/taska/pkg/auth"
+	"github.com/harrisonrobin/taska/pkg/config"
 	"github.com/harrisonrobin/taska/pkg/index"
 	"google.golang.org/api/calendar/v3"
 	"google.golang.org/api/option"
 )
 
-// NewClient creates a new Google Calendar client.
-func NewClient(calendarName string, idx *index.EventIndex) (*CalendarClient, error) {
-	ctx := context.Background()
-	scopes := []string{
-		calendar.CalendarEventsScope,
-		calendar.CalendarReadonlyScope,
+// NewClient creates a new Google Calendar client for a calendar given by name
+// or ID. A name is resolved to its ID once and then taken from the state
+// store, so a renamed calendar keeps syncing. A missing calendar is created if
+// the config asks for it.
+func NewClient(calendarName string, idx *index.EventIndex, cfg *config.Config) (*CalendarClient, error) {
+	srv, httpClient, err := newService(context.Background(), cfg)
+	if err != nil {
+		return nil, err
 	}
-	client, err := auth.GetClient(ctx, scopes)
+
+	calendarID, err := resolveCalendar(srv, calendarName, cfg)
 	if err != nil {
 		return nil, err
 	}
 
+	calClient := NewCalendarClient(srv, calendarID, idx, cfg)
+	calClient.calendarName = calendarName
+	calClient.httpClient = httpClient
+	return calClient, nil
+}
+
+// NewService creates an authenticated Calendar API service.
+func NewService(ctx context.Context, cfg *config.Config) (*calendar.Service, error) {
+	srv, _, err := newService(ctx, cfg)
+	return srv, err
+}
+
+// newService also returns the authenticated HTTP client, which batch
+// requests are sent with.
+func newService(ctx context.Context, cfg *config.Config) (*calendar.Service, *http.Client, error) {
+	client, err := auth.GetClient(ctx, cfg, auth.Scopes())
+	if err != nil {
+		return nil, nil, err
+	}
+
 	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve Calendar client: %v", err)
+		return nil, nil, fmt.Errorf("unable to retrieve Calendar client: %v", err)
 	}
+	return srv, client, nil
+}
 
-	calendarList, err := srv.CalendarList.List().Do()
+// FindCalendar looks up a calendar by name or ID in the user's calendar list.
+// A calendar ID that is not in the list, such as one shared with a service
+// account, is looked up directly; its entry has no AccessRole. The lookup
+// changes nothing.
+func FindCalendar(srv *calendar.Service, calendarName string) (*calendar.CalendarListEntry, error) {
+	calendars, err := ListCalendars(srv)
 	if err != nil {
-		return nil, fmt.Errorf("unable to retrieve calendar list: %v", err)
+		return nil, err
 	}
 
-	var calendarID string
-	for _, item := range calendarList.Items {
-		if item.Summary == calendarName {
-			calendarID = item.Id
-			break
+	for _, item := range calendars {
+		if item.Summary == calendarName || item.Id == calendarName || (calendarName == "primary" && item.Primary) {
+			return item, nil
 		}
 	}
 
-	if calendarID == "" {
-		return nil, fmt.Errorf("calendar '%s' not found", calendarName)
+	if strings.Contains(calendarName, "@") {
+		cal, err := execute(context.Background(), defaultExecutor, func(ctx context.Context) (*calendar.Calendar, error) {
+			return srv.Calendars.Get(calendarName).Context(ctx).Do()
+		})
+		if err == nil {
+			return &calendar.CalendarListEntry{Id: cal.Id, Summary: cal.Summary, Description: cal.Description, TimeZone: cal.TimeZone}, nil
+		}
+		if !isGone(err) {
+			return nil, fmt.Errorf("unable to look up calendar '%s': %w", calendarName, err)
+		}
 	}
+	return nil, fmt.Errorf("%w: '%s'", ErrCalendarNotFound, calendarName)
+}
 
-	return NewCalendarClient(srv, calendarID, idx), nil
+// ErrCalendarNotFound is returned when no calendar has the given name or ID.
+var ErrCalendarNotFound = errors.New("calendar not found")
+
+// ListCalendars returns every calendar in the user's calendar list.
+func ListCalendars(srv *calendar.Service) ([]*calendar.CalendarListEntry, error) {
+	var calendars []*calendar.CalendarListEntry
+	pageToken := ""
+	for {
+		page, err := execute(context.Background(), defaultExecutor, func(ctx context.Context) (*calendar.CalendarList, error) {
+			call := srv.CalendarList.List().Context(ctx)
+			if pageToken != "" {
+				call.PageToken(pageToken)
+			}
+			return call.Do()
+		})
+		if err != nil {
+			return nil, fmt.Errorf("unable to retrieve calendar list: %v", err)
+		}
+		calendars = append(calendars, page.Items...)
+		if page.NextPageToken == "" {
+			return calendars, nil
+		}
+		pageToken = page.NextPageToken
+	}
 }
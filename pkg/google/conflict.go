@@ -0,0 +1,157 @@
+package google
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/conflicts"
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/logging"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"google.golang.org/api/calendar/v3"
+)
+
+// editedOnCalendar reports whether the event changed since taska last wrote
+// or checked it, i.e. its etag is not the one in the index.
+func (c *CalendarClient) editedOnCalendar(key string, event *calendar.Event) bool {
+	entry, ok := c.IndexEntry(key)
+	return ok && entry.ETag != "" && entry.EventID == event.Id && entry.ETag != event.Etag
+}
+
+// handleConflict applies the conflict policy to an event that was edited on
+// the calendar and no longer matches its task, and records the conflict. It
+// reports whether the calendar's version is kept, in which case the event must
+// not be patched.
+func (c *CalendarClient) handleConflict(task taskwarrior.Task, role, key string, remote, rendered *calendar.Event) (bool, error) {
+	previous, ok, err := conflicts.Get(key)
+	if err != nil {
+		return false, err
+	}
+	if ok && previous.Pending() {
+		// Still waiting for `taska conflicts resolve`
+		slog.Debug("event has a pending conflict", logging.Op("conflict"), logging.Task(task.UUID), logging.Event(remote.Id), "role", index.RoleName(role))
+		return true, nil
+	}
+
+	policy := c.cfg.Conflicts()
+	cf := conflicts.Conflict{
+		Key:        key,
+		TaskUUID:   task.UUID,
+		Role:       role,
+		EventID:    remote.Id,
+		CalendarID: c.calendarID,
+		Policy:     policy,
+		DetectedAt: time.Now(),
+		Task:       conflicts.VersionOf(rendered),
+		Calendar:   conflicts.VersionOf(remote),
+	}
+	if task.Modified != nil {
+		cf.Task.Updated = task.Modified.Time
+	}
+
+	switch policy {
+	case config.ConflictTaskWins:
+		cf.Resolution = conflicts.KeptTask
+	case config.ConflictCalendarWins:
+		cf.Resolution = conflicts.KeptCalendar
+	case config.ConflictNewestWins:
+		cf.Resolution = conflicts.KeptTask
+		if cf.Calendar.Updated.After(cf.Task.Updated) {
+			cf.Resolution = conflicts.KeptCalendar
+		}
+	default:
+		cf.Resolution = conflicts.Pending
+	}
+
+	switch cf.Resolution {
+	case conflicts.KeptCalendar:
+		// The hook runs in the background of the change that caused it, so the
+		// task is updated without running taska again.
+		if err := c.keepCalendarVersion(task, role, key, remote, rendered, false); err != nil {
+			return false, err
+		}
+	case conflicts.Pending:
+		note := fmt.Sprintf("taska: %s event was edited on the calendar, see taska conflicts", index.RoleName(role))
+		if err := taskwarrior.NewClient().Annotate(task.UUID, note); err != nil {
+			slog.Warn("could not annotate task with conflict", logging.Op("conflict"), logging.Task(task.UUID), "error", err)
+		}
+	}
+
+	kept := cf.Resolution
+	if cf.Pending() {
+		kept = "none"
+	}
+	slog.Warn("event was edited on the calendar since the last sync", logging.Op("conflict"), logging.Task(task.UUID), logging.Event(remote.Id),
+		"role", index.RoleName(role), "policy", policy, "kept", kept)
+	if err := conflicts.Put(cf); err != nil {
+		return false, err
+	}
+	return cf.Resolution != conflicts.KeptTask, nil
+}
+
+// keepCalendarVersion carries the calendar's version of an event over to its
+// task and accepts the event as synced. With hooks, taska runs for the
+// modified task and syncs it like any other change; the index is saved first
+// so that run does not see the conflict again.
+func (c *CalendarClient) keepCalendarVersion(task taskwarrior.Task, role, key string, remote, rendered *calendar.Event, hooks bool) error {
+	mods, err := conflicts.TaskChanges(&task, role, remote, rendered)
+	if err != nil {
+		return err
+	}
+	c.recordSync(key, remote, "")
+	if hooks && c.index != nil {
+		if err := c.index.Save(); err != nil {
+			return err
+		}
+	}
+	if len(mods) == 0 {
+		return nil
+	}
+	slog.Info("updating task from its calendar event", logging.Op("conflict"), logging.Task(task.UUID), logging.Event(remote.Id), "changes", mods)
+	return taskwarrior.NewClient().Modify(task.UUID, hooks, mods...)
+}
+
+// ResolveConflict settles a recorded conflict by keeping the task's or the
+// calendar's version of the event and forgets it. Conflicts that a policy
+// already settled can be resolved again, e.g. to restore a calendar edit that
+// task-wins overwrote: the calendar version recorded with the conflict is
+// then used, since the event itself may have been overwritten.
+func (c *CalendarClient) ResolveConflict(task taskwarrior.Task, cf conflicts.Conflict, keep string) error {
+	events, err := c.RenderTaskEvents(task)
+	if err != nil {
+		return err
+	}
+	rendered := events[cf.Role]
+	if rendered == nil {
+		// The task no longer has this event; nothing is left to resolve.
+		return conflicts.Delete(cf.Key)
+	}
+
+	remote, err := c.getEvent(cf.EventID)
+	if err != nil {
+		return fmt.Errorf("could not fetch event %s: %w", cf.EventID, err)
+	}
+
+	switch keep {
+	case conflicts.KeptCalendar:
+		version := remote
+		if !cf.Pending() {
+			version = cf.Calendar.Event()
+			version.Id, version.Etag = remote.Id, remote.Etag
+		}
+		if err := c.keepCalendarVersion(task, cf.Role, cf.Key, version, rendered, true); err != nil {
+			return err
+		}
+	case conflicts.KeptTask:
+		c.recordSync(cf.Key, remote, "")
+		if _, err := c.syncRoleEvent(task, cf.Role, rendered); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown side '%s', expected task or calendar", keep)
+	}
+	slog.Info("resolved conflict", logging.Op("conflict"), logging.Task(task.UUID), logging.Event(remote.Id), "role", index.RoleName(cf.Role), "kept", keep)
+	return conflicts.Delete(cf.Key)
+}
@@ -0,0 +1,178 @@
+package google
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/conflicts"
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"google.golang.org/api/calendar/v3"
+)
+
+// fakeTaskBinary puts a `task` on $PATH that only records its arguments, and
+// returns a function listing the commands it ran.
+func fakeTaskBinary(t *testing.T) func() []string {
+	t.Helper()
+	dir := t.TempDir()
+	log := filepath.Join(dir, "commands")
+	script := "#!/bin/sh\necho \"$*\" >> " + log + "\n"
+	if err := os.WriteFile(filepath.Join(dir, "task"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+	return func() []string {
+		b, _ := os.ReadFile(log)
+		if len(b) == 0 {
+			return nil
+		}
+		return strings.Split(strings.TrimSuffix(string(b), "\n"), "\n")
+	}
+}
+
+// editedEvent sets up a task whose event was retitled on the calendar since
+// taska last wrote it: the index still has etag "1", the calendar "2".
+func editedEvent(t *testing.T, policy string) (*CalendarClient, *eventStore, taskwarrior.Task, *calendar.Event) {
+	t.Helper()
+	t.Setenv("HOME", t.TempDir())
+	idx, err := index.NewEventIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.Config{ConflictPolicy: policy, Colors: config.ColorConfig{Strategy: config.ColorHash}}
+	store := newEventStore(t)
+	c := newFakeAPI(t, store.handler(teamCalendar)).client(teamCalendar, idx, cfg)
+
+	task := taskwarrior.Task{
+		UUID:        "u1",
+		Description: "Write report",
+		Status:      taskwarrior.PENDING,
+		Scheduled:   &taskwarrior.CustomTime{Time: time.Date(2026, 3, 2, 9, 0, 0, 0, time.UTC)},
+	}
+	events, err := c.RenderTaskEvents(task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rendered := events[index.RolePrimary]
+
+	remote := *rendered
+	remote.Id = "ev1"
+	remote.Summary = "Write the report"
+	store.put(&remote, `"2"`)
+	idx.Put("u1", index.Entry{EventID: "ev1", CalendarID: teamCalendar, ETag: `"1"`})
+	return c, store, task, rendered
+}
+
+func TestEditedOnCalendar(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	idx, err := index.NewEventIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := NewCalendarClient(nil, teamCalendar, idx, nil)
+	idx.Put("u1", index.Entry{EventID: "ev1", CalendarID: teamCalendar, ETag: `"1"`})
+	idx.Put("u2", index.Entry{EventID: "ev2", CalendarID: teamCalendar})
+	idx.Put("u3", index.Entry{EventID: "ev3", CalendarID: "other@group.calendar.google.com", ETag: `"1"`})
+
+	tests := []struct {
+		key   string
+		event *calendar.Event
+		want  bool
+	}{
+		{"u1", &calendar.Event{Id: "ev1", Etag: `"1"`}, false},
+		{"u1", &calendar.Event{Id: "ev1", Etag: `"2"`}, true},
+		{"u1", &calendar.Event{Id: "ev9", Etag: `"2"`}, false}, // another event
+		{"u2", &calendar.Event{Id: "ev2", Etag: `"2"`}, false}, // no etag recorded
+		{"u3", &calendar.Event{Id: "ev3", Etag: `"2"`}, false}, // on another calendar
+		{"u4", &calendar.Event{Id: "ev4", Etag: `"2"`}, false}, // not indexed
+	}
+	for _, tt := range tests {
+		if got := c.editedOnCalendar(tt.key, tt.event); got != tt.want {
+			t.Errorf("%s %s etag %s: got %v, want %v", tt.key, tt.event.Id, tt.event.Etag, got, tt.want)
+		}
+	}
+}
+
+func TestConflictTaskWins(t *testing.T) {
+	commands := fakeTaskBinary(t)
+	c, store, task, rendered := editedEvent(t, config.ConflictTaskWins)
+
+	if _, err := c.syncRoleEvent(task, index.RolePrimary, rendered); err != nil {
+		t.Fatal(err)
+	}
+	if store.count("PATCH") != 1 || !strings.HasSuffix(store.requests[len(store.requests)-1], `PATCH ev1 If-Match: "2"`) {
+		t.Errorf("expected one patch conditional on the edited event, got %q", store.requests)
+	}
+	if got := store.get("ev1").Summary; got != rendered.Summary {
+		t.Errorf("calendar title = %q, want the task's %q", got, rendered.Summary)
+	}
+	if cmds := commands(); len(cmds) != 0 {
+		t.Errorf("task-wins changed the task: %q", cmds)
+	}
+	cf, ok, err := conflicts.Get("u1")
+	if err != nil || !ok || cf.Resolution != conflicts.KeptTask || cf.Calendar.Summary != "Write the report" {
+		t.Errorf("conflict = %+v, %v, %v", cf, ok, err)
+	}
+	if entry, _ := c.index.Entry("u1"); entry.ETag != store.get("ev1").Etag {
+		t.Errorf("index etag %s, calendar %s", entry.ETag, store.get("ev1").Etag)
+	}
+}
+
+func TestConflictCalendarWins(t *testing.T) {
+	commands := fakeTaskBinary(t)
+	c, store, task, rendered := editedEvent(t, config.ConflictCalendarWins)
+
+	event, err := c.syncRoleEvent(task, index.RolePrimary, rendered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if store.count("PATCH") != 0 {
+		t.Errorf("calendar-wins patched the event: %q", store.requests)
+	}
+	if event.Summary != "Write the report" {
+		t.Errorf("returned %q, want the calendar's version", event.Summary)
+	}
+	cmds := commands()
+	if len(cmds) != 1 || !strings.Contains(cmds[0], "rc.hooks=0 u1 modify description:Write the report") {
+		t.Errorf("task not updated from the calendar: %q", cmds)
+	}
+	cf, ok, _ := conflicts.Get("u1")
+	if !ok || cf.Resolution != conflicts.KeptCalendar {
+		t.Errorf("conflict = %+v", cf)
+	}
+	if entry, _ := c.index.Entry("u1"); entry.ETag != `"2"` {
+		t.Errorf("the calendar's version was not accepted as synced: etag %s", entry.ETag)
+	}
+}
+
+func TestConflictKeepBoth(t *testing.T) {
+	commands := fakeTaskBinary(t)
+	c, store, task, rendered := editedEvent(t, config.ConflictKeepBoth)
+
+	for run := 1; run <= 2; run++ {
+		if _, err := c.syncRoleEvent(task, index.RolePrimary, rendered); err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		if store.count("PATCH") != 0 {
+			t.Fatalf("run %d: keep-both patched the event: %q", run, store.requests)
+		}
+		cf, ok, _ := conflicts.Get("u1")
+		if !ok || !cf.Pending() {
+			t.Fatalf("run %d: conflict = %+v, want pending", run, cf)
+		}
+	}
+
+	// Only the first run annotates the task; the second skips the pending
+	// conflict
+	cmds := commands()
+	if len(cmds) != 1 || !strings.Contains(cmds[0], "u1 annotate") {
+		t.Errorf("task commands: %q", cmds)
+	}
+	if got := store.get("ev1").Summary; got != "Write the report" {
+		t.Errorf("calendar title changed to %q", got)
+	}
+}
@@ -0,0 +1,180 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang.org/x/time/rate"
+	"google.golang.org/api/googleapi"
+)
+
+// Executor defaults. The Calendar API allows roughly ten requests per second
+// per user; staying below that leaves room for other clients.
+const (
+	defaultRate        = 5
+	defaultBurst       = 10
+	defaultMaxAttempts = 5
+	defaultBaseDelay   = 500 * time.Millisecond
+	defaultMaxDelay    = 32 * time.Second
+	// defaultAttemptTimeout bounds a single request.
+	defaultAttemptTimeout = 30 * time.Second
+	// defaultTimeout bounds a call and its retries when the caller's
+	// context has no deadline.
+	defaultTimeout = 2 * time.Minute
+)
+
+// Executor runs Google API calls under a token-bucket rate limit and retries
+// the failures that are worth retrying: rate limits, server errors and
+// network errors. Waits grow exponentially with jitter, and a Retry-After
+// header is honoured when the server sends one.
+type Executor struct {
+	limiter        *rate.Limiter
+	maxAttempts    int
+	baseDelay      time.Duration
+	maxDelay       time.Duration
+	attemptTimeout time.Duration
+	timeout        time.Duration
+
+	// sleep waits between attempts; replaced in tests.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewExecutor creates an executor that allows rps requests per second with
+// bursts of up to burst requests.
+func NewExecutor(rps float64, burst int) *Executor {
+	return &Executor{
+		limiter:        rate.NewLimiter(rate.Limit(rps), burst),
+		maxAttempts:    defaultMaxAttempts,
+		baseDelay:      defaultBaseDelay,
+		maxDelay:       defaultMaxDelay,
+		attemptTimeout: defaultAttemptTimeout,
+		timeout:        defaultTimeout,
+		sleep:          sleepContext,
+	}
+}
+
+// defaultExecutor is shared by every client in the process, so they draw on
+// the same rate limit. The limit is per process: hook runs started at once
+// each have their own. Bulk work therefore goes through Batch, which sends up
+// to 50 operations in one request: the on-exit hook hands all of a command's
+// tasks to a single background run, and `taska sync` and the overdue sweep
+// batch as well. Whatever still exceeds the quota is retried with backoff.
+var defaultExecutor = NewExecutor(defaultRate, defaultBurst)
+
+// Do runs call until it succeeds, fails with an error that is not worth
+// retrying, runs out of attempts or ctx is done. Each attempt gets its own
+// deadline through the context passed to call.
+func (e *Executor) Do(ctx context.Context, call func(ctx context.Context) error) error {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, e.timeout)
+		defer cancel()
+	}
+
+	for attempt := 1; ; attempt++ {
+		if err := e.limiter.Wait(ctx); err != nil {
+			return err
+		}
+		attemptCtx, cancel := context.WithTimeout(ctx, e.attemptTimeout)
+		err := call(attemptCtx)
+		cancel()
+		if err == nil || !Retryable(err) || ctx.Err() != nil || attempt >= e.maxAttempts {
+			return err
+		}
+		delay := e.Backoff(attempt, err)
+		slog.Debug("retrying Google API call", "attempt", attempt, "delay", delay, "error", err)
+		if err := e.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Backoff returns how long to wait before the attempt after the given one:
+// the server's Retry-After if it sent one, else an exponentially growing
+// delay with jitter.
+func (e *Executor) Backoff(attempt int, err error) time.Duration {
+	if d, ok := retryAfter(err); ok {
+		return d
+	}
+	d := e.baseDelay << (attempt - 1)
+	if d > e.maxDelay || d <= 0 {
+		d = e.maxDelay
+	}
+	// Equal jitter: at least half the delay, so retries still back off
+	return d/2 + rand.N(d/2+1)
+}
+
+// Retryable reports whether a failed call may succeed when repeated.
+func Retryable(err error) bool {
+	if errors.Is(err, context.Canceled) {
+		return false
+	}
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		switch apiErr.Code {
+		case http.StatusTooManyRequests, http.StatusRequestTimeout,
+			http.StatusInternalServerError, http.StatusBadGateway,
+			http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		case http.StatusForbidden:
+			// 403 is also used for real permission problems
+			for _, item := range apiErr.Errors {
+				if item.Reason == "rateLimitExceeded" || item.Reason == "userRateLimitExceeded" {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// retryAfter reads the Retry-After header of a failed call, in seconds or as
+// an HTTP date.
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Header == nil {
+		return 0, false
+	}
+	value := apiErr.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return max(time.Until(at), 0), true
+	}
+	return 0, false
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// execute runs an API call that returns a result through the executor.
+func execute[T any](ctx context.Context, e *Executor, call func(ctx context.Context) (T, error)) (T, error) {
+	var result T
+	err := e.Do(ctx, func(ctx context.Context) error {
+		var err error
+		result, err = call(ctx)
+		return err
+	})
+	return result, err
+}
@@ -0,0 +1,187 @@
+package google
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// failure is an injected API error.
+type failure struct {
+	status     int
+	reason     string
+	retryAfter string
+}
+
+// failing answers the first requests with the given failures, then serves
+// an event.
+func failing(failures ...failure) http.HandlerFunc {
+	var n int32
+	return func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(&n, 1))
+		if i > len(failures) {
+			reply(w, http.StatusOK, `{"id":"ev1","etag":"\"1\""}`)
+			return
+		}
+		if f := failures[i-1]; f.retryAfter != "" {
+			w.Header().Set("Retry-After", f.retryAfter)
+		}
+		apiError(w, failures[i-1].status, failures[i-1].reason)
+	}
+}
+
+// recordSleeps makes the client's executor record its waits instead of
+// sleeping.
+func recordSleeps(c *CalendarClient) *[]time.Duration {
+	var sleeps []time.Duration
+	c.exec.sleep = func(ctx context.Context, d time.Duration) error {
+		sleeps = append(sleeps, d)
+		return ctx.Err()
+	}
+	return &sleeps
+}
+
+func TestExecutorRetriesTransientErrors(t *testing.T) {
+	api := newFakeAPI(t, failing(
+		failure{status: http.StatusServiceUnavailable, reason: "backendError"},
+		failure{status: http.StatusForbidden, reason: "rateLimitExceeded", retryAfter: "3"},
+		failure{status: http.StatusTooManyRequests, reason: "rateLimitExceeded"},
+	))
+	c := api.client("primary", nil, nil)
+	sleeps := recordSleeps(c)
+
+	event, err := c.getEvent("ev1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if event.Id != "ev1" || len(api.requests) != 4 {
+		t.Fatalf("expected the event after 4 requests, got %v after %d", event.Id, len(api.requests))
+	}
+	if len(*sleeps) != 3 {
+		t.Fatalf("expected 3 waits, got %v", *sleeps)
+	}
+	if d := (*sleeps)[0]; d < defaultBaseDelay/2 || d > defaultBaseDelay {
+		t.Errorf("first backoff %v outside [%v, %v]", d, defaultBaseDelay/2, defaultBaseDelay)
+	}
+	if d := (*sleeps)[1]; d != 3*time.Second {
+		t.Errorf("Retry-After not honoured: waited %v", d)
+	}
+	if d := (*sleeps)[2]; d < defaultBaseDelay*2 || d > defaultBaseDelay*4 {
+		t.Errorf("third backoff %v outside [%v, %v]", d, defaultBaseDelay*2, defaultBaseDelay*4)
+	}
+}
+
+func TestExecutorStopsOnFatalErrors(t *testing.T) {
+	tests := []failure{
+		{status: http.StatusNotFound, reason: "notFound"},
+		{status: http.StatusForbidden, reason: "forbidden"},
+		{status: http.StatusForbidden, reason: "quotaExceeded"},
+		{status: http.StatusBadRequest, reason: "invalid"},
+	}
+	for _, f := range tests {
+		api := newFakeAPI(t, failing(f))
+		_, err := api.client("primary", nil, nil).getEvent("ev1")
+		var apiErr *googleapi.Error
+		if !errors.As(err, &apiErr) || apiErr.Code != f.status {
+			t.Errorf("%d %s: expected the API error, got %v", f.status, f.reason, err)
+		}
+		if len(api.requests) != 1 {
+			t.Errorf("%d %s: retried, %d requests", f.status, f.reason, len(api.requests))
+		}
+	}
+}
+
+func TestExecutorGivesUp(t *testing.T) {
+	var failures []failure
+	for range defaultMaxAttempts + 1 {
+		failures = append(failures, failure{status: http.StatusInternalServerError, reason: "backendError"})
+	}
+	api := newFakeAPI(t, failing(failures...))
+
+	if _, err := api.client("primary", nil, nil).getEvent("ev1"); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(api.requests) != defaultMaxAttempts {
+		t.Errorf("expected %d attempts, got %d", defaultMaxAttempts, len(api.requests))
+	}
+}
+
+func TestExecutorHonoursContextDeadline(t *testing.T) {
+	api := newFakeAPI(t, failing(failure{status: http.StatusServiceUnavailable, reason: "backendError", retryAfter: "60"}))
+	c := api.client("primary", nil, nil)
+	c.exec.sleep = sleepContext
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	err := c.exec.Do(ctx, func(ctx context.Context) error {
+		_, err := c.srv.Events.Get(c.calendarID, "ev1").Context(ctx).Do()
+		return err
+	})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the deadline to end the wait, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("waited %v despite the deadline", elapsed)
+	}
+	if len(api.requests) != 1 {
+		t.Errorf("expected 1 request, got %d", len(api.requests))
+	}
+}
+
+func TestExecutorRateLimit(t *testing.T) {
+	e := NewExecutor(50, 1)
+	start := time.Now()
+	for range 5 {
+		if err := e.Do(context.Background(), func(context.Context) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// The first call uses the burst, the other four wait 20ms each
+	if elapsed := time.Since(start); elapsed < 70*time.Millisecond {
+		t.Errorf("5 calls at 50/s took only %v", elapsed)
+	}
+}
+
+func TestInsertEventIsNotDuplicated(t *testing.T) {
+	var ids []string
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet {
+			reply(w, http.StatusOK, fmt.Sprintf(`{"id":%q,"etag":"\"1\""}`, ids[0]))
+			return
+		}
+		var e calendar.Event
+		json.NewDecoder(r.Body).Decode(&e)
+		ids = append(ids, e.Id)
+		if len(ids) == 1 {
+			// Created, but the response never arrives
+			apiError(w, http.StatusBadGateway, "backendError")
+			return
+		}
+		apiError(w, http.StatusConflict, "duplicate")
+	})
+	c := api.client("primary", nil, nil)
+
+	created, err := c.insertEvent(&calendar.Event{Summary: "Write report"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != ids[1] {
+		t.Fatalf("the retried insert must reuse its event ID, sent %q", ids)
+	}
+	if len(ids[0]) < 5 || strings.Trim(ids[0], "0123456789abcdefghijklmnopqrstuv") != "" {
+		t.Errorf("%q is not a valid event ID", ids[0])
+	}
+	if created.Id != ids[0] {
+		t.Errorf("got event %q, want the one the first attempt created", created.Id)
+	}
+}
@@ -0,0 +1,162 @@
+package google
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/index"
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/option"
+)
+
+// teamCalendar is the calendar the tests' clients sync to.
+const teamCalendar = "team@group.calendar.google.com"
+
+// fakeAPI is a fake Calendar API answered by a handler. Batch requests are
+// split into their parts, which the handler answers as requests of their own;
+// the responses go back in reverse order, so that results must be matched by
+// Content-ID.
+type fakeAPI struct {
+	t      *testing.T
+	server *httptest.Server
+	srv    *calendar.Service
+
+	mu sync.Mutex
+	// requests lists the requests answered by the handler, batch parts
+	// included, as "METHOD path".
+	requests []string
+	// batches lists the parts of each batch request.
+	batches [][]batchItem
+}
+
+// batchItem is one part of a batch request received by fakeAPI.
+type batchItem struct {
+	contentID string
+	req       *http.Request
+	body      string
+}
+
+// newFakeAPI starts a fake API answered by handler.
+func newFakeAPI(t *testing.T, handler http.HandlerFunc) *fakeAPI {
+	t.Helper()
+	api := &fakeAPI{t: t}
+	api.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/batch/calendar/v3" && r.Method == http.MethodPost {
+			api.serveBatch(w, r, handler)
+			return
+		}
+		api.record(r)
+		handler(w, r)
+	}))
+	t.Cleanup(api.server.Close)
+
+	srv, err := calendar.NewService(context.Background(),
+		option.WithHTTPClient(api.server.Client()), option.WithEndpoint(api.server.URL+"/calendar/v3/"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	api.srv = srv
+	return api
+}
+
+// client opens a client of calendarID on the fake API. Its executor retries
+// without waiting.
+func (api *fakeAPI) client(calendarID string, idx *index.EventIndex, cfg *config.Config) *CalendarClient {
+	c := NewCalendarClient(api.srv, calendarID, idx, cfg)
+	c.httpClient = api.server.Client()
+	c.exec = NewExecutor(1000, 100)
+	c.exec.sleep = func(context.Context, time.Duration) error { return nil }
+	return c
+}
+
+// sent returns the requests answered so far and forgets them.
+func (api *fakeAPI) sent() []string {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	requests := api.requests
+	api.requests = nil
+	return requests
+}
+
+func (api *fakeAPI) record(r *http.Request) {
+	api.mu.Lock()
+	defer api.mu.Unlock()
+	api.requests = append(api.requests, r.Method+" "+r.URL.Path)
+}
+
+func (api *fakeAPI) serveBatch(w http.ResponseWriter, r *http.Request, handler http.HandlerFunc) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	var items []batchItem
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		req, err := http.ReadRequest(bufio.NewReader(part))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(req.Body)
+		items = append(items, batchItem{contentID: part.Header.Get("Content-Id"), req: req, body: string(body)})
+	}
+	api.mu.Lock()
+	api.batches = append(api.batches, items)
+	api.mu.Unlock()
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		item.req.Body = io.NopCloser(strings.NewReader(item.body))
+		api.record(item.req)
+		rec := httptest.NewRecorder()
+		handler(rec, item.req)
+
+		id := strings.Replace(item.contentID, "<item-", "<response-item-", 1)
+		part, _ := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"application/http"}, "Content-Id": {id}})
+		var header bytes.Buffer
+		rec.Header().Write(&header)
+		fmt.Fprintf(part, "HTTP/1.1 %d %s\r\n%sContent-Length: %d\r\n\r\n%s",
+			rec.Code, http.StatusText(rec.Code), header.String(), rec.Body.Len(), rec.Body.String())
+	}
+	mw.Close()
+}
+
+// reply writes a JSON response.
+func reply(w http.ResponseWriter, status int, body string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	io.WriteString(w, body)
+}
+
+// apiError writes an API error with the given status and reason.
+func apiError(w http.ResponseWriter, status int, reason string) {
+	reply(w, status, fmt.Sprintf(`{"error":{"code":%d,"message":%q,"errors":[{"reason":%q}]}}`, status, http.StatusText(status), reason))
+}
+
+func notFound(w http.ResponseWriter) {
+	apiError(w, http.StatusNotFound, "notFound")
+}
@@ -0,0 +1,51 @@
+package google
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/harrisonrobin/taska/pkg/logging"
+	"google.golang.org/api/calendar/v3"
+)
+
+// MoveTo moves an event from this calendar to dest with Events.Move, which
+// keeps its ID, and points its index entry at dest.
+func (c *CalendarClient) MoveTo(eventID string, dest *CalendarClient) (*calendar.Event, error) {
+	moved, err := execute(context.Background(), c.exec, func(ctx context.Context) (*calendar.Event, error) {
+		return c.srv.Events.Move(c.calendarID, eventID, dest.calendarID).Context(ctx).Do()
+	})
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("moved event", logging.Op("move"), logging.Task(EventTaskID(moved)), logging.Event(moved.Id), "from", c.calendarID, "to", dest.calendarID)
+	dest.Adopt(moved)
+	return moved, nil
+}
+
+// Adopt points the index entry of an event that was moved to this calendar,
+// but is still recorded on another one, at it. The entry's hash is dropped,
+// since the calendar's privacy level may render the task differently, so the
+// next sync compares the event again. It reports whether the entry changed.
+func (c *CalendarClient) Adopt(event *calendar.Event) bool {
+	if c.index == nil {
+		return false
+	}
+	key, ok := c.index.KeyForEvent(event.Id)
+	if !ok {
+		return false
+	}
+	entry, _ := c.index.Entry(key)
+	if entry.CalendarID == c.calendarID {
+		return false
+	}
+	entry.CalendarID = c.calendarID
+	entry.ETag = event.Etag
+	entry.Hash = ""
+	c.index.Put(key, entry)
+	return true
+}
+
+// CalendarID returns the ID of the client's calendar.
+func (c *CalendarClient) CalendarID() string {
+	return c.calendarID
+}
@@ -0,0 +1,45 @@
+package google
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/harrisonrobin/taska/pkg/index"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestMoveTo(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var destinations []string
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		destinations = append(destinations, r.URL.Query().Get("destination"))
+		reply(w, http.StatusOK, `{"id":"ev1","etag":"\"2\"","extendedProperties":{"private":{"taskwarrior_id":"u1"}}}`)
+	})
+
+	idx, err := index.NewEventIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx.Put("u1", index.Entry{EventID: "ev1", CalendarID: "old@group.calendar.google.com", ETag: `"1"`, Hash: "abc"})
+	src := api.client("old@group.calendar.google.com", idx, nil)
+	dst := api.client("new@group.calendar.google.com", idx, nil)
+
+	if _, err := src.MoveTo("ev1", dst); err != nil {
+		t.Fatal(err)
+	}
+	moves := api.sent()
+	if len(moves) != 1 || moves[0] != "POST /calendar/v3/calendars/old@group.calendar.google.com/events/ev1/move" || destinations[0] != "new@group.calendar.google.com" {
+		t.Errorf("requests: %v to %v", moves, destinations)
+	}
+	entry, _ := idx.Entry("u1")
+	want := index.Entry{EventID: "ev1", CalendarID: "new@group.calendar.google.com", ETag: `"2"`}
+	if entry != want {
+		t.Errorf("entry = %+v, want %+v", entry, want)
+	}
+
+	// An event already recorded on the calendar keeps its etag, so a later
+	// edit is still noticed.
+	if dst.Adopt(&calendar.Event{Id: "ev1", Etag: `"3"`}) {
+		t.Error("adopted an event that was already recorded here")
+	}
+}
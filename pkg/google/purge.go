@@ -0,0 +1,66 @@
+package google
+
+import (
+	"context"
+	"log/slog"
+
+	"github.com/harrisonrobin/taska/pkg/logging"
+	"github.com/harrisonrobin/taska/pkg/util"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Purge removes taska's events from the calendar in batches: it deletes them,
+// or with detach strips the task properties so they stay behind as plain
+// events taska no longer manages. Events that are gone, deleted or detached
+// are dropped from the index. It returns the errors of the events that
+// failed by event ID.
+func (c *CalendarClient) Purge(ctx context.Context, events []*calendar.Event, detach bool) map[string]error {
+	ops := make([]BatchOp, len(events))
+	for i, e := range events {
+		ops[i] = BatchOp{Kind: BatchDelete, TaskUUID: EventTaskID(e), Role: EventRole(e), EventID: e.Id}
+		if detach {
+			ops[i].Kind = BatchPatch
+			ops[i].Event = detachPatch()
+		}
+	}
+
+	errs := make(map[string]error)
+	results, err := c.Batch(ctx, ops)
+	for _, res := range results {
+		op := res.Op
+		if res.Err != nil && !(op.Kind == BatchDelete && isGone(res.Err)) {
+			slog.Error("purge failed", logging.Op(op.Kind), logging.Task(op.TaskUUID), logging.Event(op.EventID), "error", res.Err)
+			errs[op.EventID] = res.Err
+			continue
+		}
+		slog.Info("purged event", logging.Op(op.Kind), logging.Task(op.TaskUUID), logging.Event(op.EventID))
+		c.forgetEvent(op.EventID)
+	}
+	if err != nil {
+		for _, op := range ops[len(results):] {
+			errs[op.EventID] = err
+		}
+	}
+	return errs
+}
+
+// detachPatch removes the private properties that tie an event to a task.
+func detachPatch() *calendar.Event {
+	return &calendar.Event{
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private:         map[string]string{},
+			ForceSendFields: []string{"Private"},
+			NullFields:      []string{"Private." + util.TaskIDProperty, "Private." + util.RoleProperty},
+		},
+	}
+}
+
+// forgetEvent drops the index entry of an event, if it has one.
+func (c *CalendarClient) forgetEvent(eventID string) {
+	if c.index == nil {
+		return
+	}
+	if key, ok := c.index.KeyForEvent(eventID); ok {
+		c.index.Remove(key)
+	}
+}
@@ -0,0 +1,77 @@
+package google
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/util"
+	"google.golang.org/api/calendar/v3"
+)
+
+func taskEvent(id, uuid, role string) *calendar.Event {
+	private := map[string]string{util.TaskIDProperty: uuid}
+	if role != index.RolePrimary {
+		private[util.RoleProperty] = role
+	}
+	return &calendar.Event{Id: id, ExtendedProperties: &calendar.EventExtendedProperties{Private: private}}
+}
+
+func TestPurge(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/events/gone"):
+			apiError(w, http.StatusGone, "deleted")
+		case strings.HasSuffix(r.URL.Path, "/events/locked"):
+			apiError(w, http.StatusForbidden, "forbidden")
+		case r.Method == http.MethodPatch:
+			reply(w, http.StatusOK, `{"id":"ev1"}`)
+		default:
+			w.WriteHeader(http.StatusNoContent)
+		}
+	})
+	idx, err := index.NewEventIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := api.client(teamCalendar, idx, nil)
+	for key, id := range map[string]string{"u1": "ev1", index.Key("u1", index.RoleDeadline): "ev2", "u2": "gone", "u3": "locked"} {
+		idx.Put(key, index.Entry{EventID: id})
+	}
+
+	events := []*calendar.Event{
+		taskEvent("ev1", "u1", index.RolePrimary),
+		taskEvent("ev2", "u1", index.RoleDeadline),
+		taskEvent("gone", "u2", index.RolePrimary),
+		taskEvent("locked", "u3", index.RolePrimary),
+	}
+	errs := c.Purge(context.Background(), events, false)
+	if len(errs) != 1 || errs["locked"] == nil {
+		t.Errorf("expected only the locked event to fail, got %v", errs)
+	}
+	for _, key := range []string{"u1", index.Key("u1", index.RoleDeadline), "u2"} {
+		if _, ok := idx.Entry(key); ok {
+			t.Errorf("%s still indexed", key)
+		}
+	}
+	if _, ok := idx.Entry("u3"); !ok {
+		t.Error("the event that failed was forgotten")
+	}
+
+	api.batches = nil
+	idx.Put("u1", index.Entry{EventID: "ev1"})
+	if errs := c.Purge(context.Background(), events[:1], true); len(errs) != 0 {
+		t.Fatalf("detach: %v", errs)
+	}
+	item := api.batches[0][0]
+	want := `{"extendedProperties":{"private":{"taska_role":null,"taskwarrior_id":null}}}`
+	if item.req.Method != http.MethodPatch || item.body != want {
+		t.Errorf("detach sent %s %s", item.req.Method, item.body)
+	}
+	if _, ok := idx.Entry("u1"); ok {
+		t.Error("detached event still indexed")
+	}
+}
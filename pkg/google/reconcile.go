@@ -0,0 +1,67 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/harrisonrobin/taska/pkg/logging"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+)
+
+// Export reads tasks from Taskwarrior by UUID, like taskwarrior.Client's
+// GetTasks.
+type Export func(uuids []string) ([]taskwarrior.Task, error)
+
+// reconcileExportSize limits how many UUIDs are passed to a single export.
+const reconcileExportSize = 100
+
+// Reconcile brings the calendar in line with Taskwarrior as a whole, for the
+// changes no hook run synced: pending are the pending tasks that sync to this
+// calendar. The other tasks with events indexed on the calendar are exported
+// with export, so that the ones completed, waiting or deleted since are
+// synced as well; those Taskwarrior no longer has lose their events. Tasks
+// exported as pending are left to the calendar they sync to. Everything goes
+// through SyncTasks, in batches. It returns the tasks synced and the errors
+// of the failed ones by UUID.
+func (c *CalendarClient) Reconcile(ctx context.Context, pending []taskwarrior.Task, export Export) ([]taskwarrior.Task, map[string]error, error) {
+	tasks := append([]taskwarrior.Task(nil), pending...)
+	if c.index != nil {
+		known := make(map[string]bool, len(pending))
+		for _, task := range pending {
+			known[task.UUID] = true
+		}
+		var others []string
+		for _, uuid := range c.index.Tasks(c.calendarID) {
+			if !known[uuid] {
+				others = append(others, uuid)
+			}
+		}
+		for start := 0; start < len(others); start += reconcileExportSize {
+			uuids := others[start:min(start+reconcileExportSize, len(others))]
+			exported, err := export(append([]string(nil), uuids...))
+			if err != nil {
+				return nil, nil, fmt.Errorf("could not export the indexed tasks: %w", err)
+			}
+			found := make(map[string]taskwarrior.Task, len(exported))
+			for _, task := range exported {
+				found[task.UUID] = task
+			}
+			for _, uuid := range uuids {
+				task, ok := found[uuid]
+				switch {
+				case !ok:
+					slog.Info("reconcile: task gone from Taskwarrior", logging.Op("reconcile"), logging.Task(uuid))
+					task = taskwarrior.Task{UUID: uuid, Status: taskwarrior.DELETED}
+				case task.Status == taskwarrior.PENDING:
+					continue
+				}
+				tasks = append(tasks, task)
+			}
+		}
+	}
+	if len(tasks) == 0 {
+		return nil, map[string]error{}, nil
+	}
+	return tasks, c.SyncTasks(ctx, tasks), nil
+}
@@ -0,0 +1,87 @@
+package google
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+)
+
+func TestReconcile(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodDelete {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		reply(w, http.StatusOK, fmt.Sprintf(`{"id":%q,"etag":"\"2\""}`, path.Base(r.URL.Path)))
+	})
+	idx, err := index.NewEventIndex()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := api.client(teamCalendar, idx, nil)
+	for _, uuid := range []string{"u1", "u3", "u4", "u5"} {
+		idx.Put(uuid, index.Entry{EventID: "ev" + uuid[1:], CalendarID: c.CalendarID(), ETag: `"1"`, Hash: "old"})
+	}
+	idx.Put("u6", index.Entry{EventID: "ev6", CalendarID: "other@group.calendar.google.com", ETag: `"1"`})
+
+	at := &taskwarrior.CustomTime{Time: time.Now().Add(24 * time.Hour)}
+	pending := []taskwarrior.Task{{UUID: "u1", Description: "Write report", Status: taskwarrior.PENDING, Scheduled: at}}
+	// Since the last hook run u3 was completed, u4 purged and u5 routed to
+	// another calendar, where it is pending
+	var exported []string
+	export := func(uuids []string) ([]taskwarrior.Task, error) {
+		exported = append(exported, uuids...)
+		return []taskwarrior.Task{
+			{UUID: "u3", Description: "Call", Status: taskwarrior.COMPLETED, Scheduled: at},
+			{UUID: "u5", Description: "Elsewhere", Status: taskwarrior.PENDING, Scheduled: at},
+		}, nil
+	}
+
+	tasks, errs, err := c.Reconcile(context.Background(), pending, export)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(errs) != 0 {
+		t.Fatal(errs)
+	}
+	if want := []string{"u3", "u4", "u5"}; !reflect.DeepEqual(exported, want) {
+		t.Errorf("exported %v, want %v", exported, want)
+	}
+	var synced []string
+	for _, task := range tasks {
+		synced = append(synced, task.UUID)
+	}
+	if want := []string{"u1", "u3", "u4"}; !reflect.DeepEqual(synced, want) {
+		t.Errorf("synced %v, want %v", synced, want)
+	}
+
+	if len(api.batches) != 1 {
+		t.Fatalf("expected one batch, got %d", len(api.batches))
+	}
+	var sent []string
+	for _, item := range api.batches[0] {
+		sent = append(sent, item.req.Method+" "+path.Base(item.req.URL.Path))
+	}
+	sort.Strings(sent)
+	if want := []string{"DELETE ev4", "PATCH ev1", "PATCH ev3"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+	if _, ok := idx.Entry("u4"); ok {
+		t.Error("the purged task's entry is still indexed")
+	}
+	if entry, _ := idx.Entry("u1"); entry.ETag != `"2"` || entry.Hash == "old" {
+		t.Errorf("u1 entry not updated: %+v", entry)
+	}
+	if entry, _ := idx.Entry("u5"); entry.ETag != `"1"` {
+		t.Errorf("the task pending elsewhere was synced here: %+v", entry)
+	}
+}
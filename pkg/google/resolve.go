@@ -0,0 +1,205 @@
+package google
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/state"
+	"google.golang.org/api/calendar/v3"
+)
+
+// calendarRef is what a calendar name resolved to, cached in the state store
+// so that it is not looked up on every run.
+type calendarRef struct {
+	ID string `json:"id"`
+	// Summary is the calendar's name when it was resolved.
+	Summary    string    `json:"summary"`
+	ResolvedAt time.Time `json:"resolved_at"`
+}
+
+// IsCalendarID reports whether a configured calendar is a raw calendar ID,
+// such as "primary" or "abc123@group.calendar.google.com", rather than a
+// calendar's name. IDs are used as they are.
+func IsCalendarID(calendar string) bool {
+	return calendar == "primary" || strings.Contains(calendar, "@")
+}
+
+// ResolveCalendar returns the ID of the named calendar. Raw IDs are returned
+// as they are and names are looked up once, then taken from the state store.
+func ResolveCalendar(srv *calendar.Service, calendarName string) (string, error) {
+	return resolveCalendar(srv, calendarName, nil)
+}
+
+// resolveCalendar is ResolveCalendar that also creates a missing calendar if
+// cfg asks for it.
+func resolveCalendar(srv *calendar.Service, calendarName string, cfg *config.Config) (string, error) {
+	if IsCalendarID(calendarName) {
+		return calendarName, nil
+	}
+	if ref, ok := cachedCalendar(calendarName); ok {
+		return ref.ID, nil
+	}
+	return lookUpCalendar(srv, calendarName, cfg, "")
+}
+
+// lookUpCalendar resolves a calendar name through the calendar list, or
+// creates the calendar if it is missing and cfg asks for it, and caches the
+// result. stale is the ID the name resolved to before, if it was not found
+// since, or "".
+func lookUpCalendar(srv *calendar.Service, calendarName string, cfg *config.Config, stale string) (string, error) {
+	if !cfg.CreatesMissingCalendar() {
+		entry, err := FindCalendar(srv, calendarName)
+		if err != nil {
+			return "", err
+		}
+		cacheCalendar(calendarName, entry.Id, entry.Summary)
+		return entry.Id, nil
+	}
+
+	// Hook runs start in parallel, and each would create the missing
+	// calendar. The lookup and the creation run in a write transaction of the
+	// state store instead, which holds its lock, and the name is taken from
+	// the cache if another run resolved it in the meantime.
+	var id string
+	err := state.Update(func(tx *state.Tx) error {
+		var ref calendarRef
+		found, err := tx.Get(state.Calendars, calendarName, &ref)
+		if err != nil {
+			return err
+		}
+		if found && ref.ID != "" && ref.ID != stale {
+			id = ref.ID
+			return nil
+		}
+		entry, err := FindCalendar(srv, calendarName)
+		if errors.Is(err, ErrCalendarNotFound) {
+			var created *calendar.Calendar
+			created, err = createCalendar(srv, calendarName, cfg.NewCalendarSettings())
+			if err != nil {
+				return fmt.Errorf("could not create missing calendar '%s': %w", calendarName, err)
+			}
+			slog.Info("created missing calendar", "calendar", calendarName, "id", created.Id)
+			entry = &calendar.CalendarListEntry{Id: created.Id, Summary: created.Summary}
+		}
+		if err != nil {
+			return err
+		}
+		id = entry.Id
+		return tx.Put(state.Calendars, calendarName, calendarRef{ID: entry.Id, Summary: entry.Summary, ResolvedAt: time.Now()})
+	})
+	return id, err
+}
+
+// CreateCalendar creates a calendar, makes sure it is in the user's calendar
+// list and caches its ID under its name.
+func CreateCalendar(srv *calendar.Service, calendarName string, settings config.NewCalendarConfig) (*calendar.Calendar, error) {
+	created, err := createCalendar(srv, calendarName, settings)
+	if err != nil {
+		return created, err
+	}
+	cacheCalendar(calendarName, created.Id, created.Summary)
+	return created, nil
+}
+
+// createCalendar is CreateCalendar without the caching.
+func createCalendar(srv *calendar.Service, calendarName string, settings config.NewCalendarConfig) (*calendar.Calendar, error) {
+	ctx := context.Background()
+	created, err := execute(ctx, defaultExecutor, func(ctx context.Context) (*calendar.Calendar, error) {
+		return srv.Calendars.Insert(&calendar.Calendar{
+			Summary:     calendarName,
+			Description: settings.Description,
+			TimeZone:    settings.TimeZone,
+		}).Context(ctx).Do()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// The creator normally gets the calendar listed; a service account
+	// acting as itself may not.
+	_, err = execute(ctx, defaultExecutor, func(ctx context.Context) (*calendar.CalendarListEntry, error) {
+		return srv.CalendarList.Get(created.Id).Context(ctx).Do()
+	})
+	if isGone(err) {
+		_, err = execute(ctx, defaultExecutor, func(ctx context.Context) (*calendar.CalendarListEntry, error) {
+			return srv.CalendarList.Insert(&calendar.CalendarListEntry{Id: created.Id}).Context(ctx).Do()
+		})
+	}
+	if err != nil {
+		return created, fmt.Errorf("calendar created but not added to the calendar list: %w", err)
+	}
+	return created, nil
+}
+
+func cacheCalendar(calendarName, id, summary string) {
+	ref := calendarRef{ID: id, Summary: summary, ResolvedAt: time.Now()}
+	err := state.Update(func(tx *state.Tx) error {
+		return tx.Put(state.Calendars, calendarName, ref)
+	})
+	if err != nil {
+		slog.Warn("could not cache calendar ID", "calendar", calendarName, "error", err)
+	}
+}
+
+func cachedCalendar(calendarName string) (calendarRef, bool) {
+	var ref calendarRef
+	var found bool
+	err := state.View(func(tx *state.Tx) error {
+		var err error
+		found, err = tx.Get(state.Calendars, calendarName, &ref)
+		return err
+	})
+	if err != nil {
+		slog.Warn("could not read the calendar ID cache", "error", err)
+		return calendarRef{}, false
+	}
+	return ref, found && ref.ID != ""
+}
+
+// reresolve handles a call that failed because the calendar was not found.
+// If the client was opened by a calendar name, the name is looked up again
+// (and the calendar created if the config asks for it); it reports whether it
+// now names another calendar, so the call is worth repeating.
+func (c *CalendarClient) reresolve(err error) bool {
+	if !isGone(err) || c.calendarName == "" || IsCalendarID(c.calendarName) {
+		return false
+	}
+	id, lookupErr := lookUpCalendar(c.srv, c.calendarName, c.cfg, c.calendarID)
+	if lookupErr != nil {
+		slog.Error("calendar not found", "calendar", c.calendarName, "id", c.calendarID, "error", lookupErr)
+		return false
+	}
+	if id == c.calendarID {
+		return false
+	}
+	slog.Warn("calendar ID changed", "calendar", c.calendarName, "old_id", c.calendarID, "id", id)
+	c.calendarID = id
+	return true
+}
+
+// noteSummary warns when the calendar, opened by a name, has been renamed
+// since. taska keeps using it by its ID until the config is updated.
+func (c *CalendarClient) noteSummary(summary string) {
+	if summary == "" || c.calendarName == "" || IsCalendarID(c.calendarName) || summary == c.calendarName || c.renameWarned {
+		return
+	}
+	c.renameWarned = true
+	slog.Warn("calendar was renamed; update the config with --set-calendar",
+		"calendar", c.calendarName, "new_name", summary, "id", c.calendarID)
+}
+
+// onCalendar runs a call that addresses the calendar itself, such as a
+// listing or an insert, through the executor. If the calendar is not found
+// and its name now resolves to another calendar, the call is repeated there.
+func onCalendar[T any](c *CalendarClient, call func(ctx context.Context) (T, error)) (T, error) {
+	result, err := execute(context.Background(), c.exec, call)
+	if err != nil && c.reresolve(err) {
+		return execute(context.Background(), c.exec, call)
+	}
+	return result, err
+}
@@ -0,0 +1,257 @@
+package google
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"google.golang.org/api/calendar/v3"
+)
+
+// calendarList serves a calendar list with the given calendars, name to ID,
+// and empty event listings for the calendars in it. Created calendars are
+// added to it.
+func calendarList(calendars map[string]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/calendar/v3/users/me/calendarList" {
+			var items []string
+			for name, id := range calendars {
+				items = append(items, fmt.Sprintf(`{"id":%q,"summary":%q,"accessRole":"owner"}`, id, name))
+			}
+			reply(w, http.StatusOK, fmt.Sprintf(`{"items":[%s]}`, strings.Join(items, ",")))
+			return
+		}
+		if r.Method == http.MethodPost && r.URL.Path == "/calendar/v3/calendars" {
+			var created calendar.Calendar
+			json.NewDecoder(r.Body).Decode(&created)
+			created.Id = strings.ToLower(created.Summary) + "@group.calendar.google.com"
+			calendars[created.Summary] = created.Id
+			body, _ := json.Marshal(created)
+			reply(w, http.StatusOK, string(body))
+			return
+		}
+		for name, id := range calendars {
+			if r.URL.Path == "/calendar/v3/users/me/calendarList/"+id {
+				reply(w, http.StatusOK, fmt.Sprintf(`{"id":%q,"summary":%q}`, id, name))
+				return
+			}
+		}
+		for name, id := range calendars {
+			if r.URL.Path == "/calendar/v3/calendars/"+id+"/events" {
+				reply(w, http.StatusOK, fmt.Sprintf(`{"summary":%q,"items":[]}`, name))
+				return
+			}
+		}
+		notFound(w)
+	}
+}
+
+func TestResolveCalendar(t *testing.T) {
+	calendars := map[string]string{"Tasks": "tasks@group.calendar.google.com"}
+	t.Setenv("HOME", t.TempDir())
+	api := newFakeAPI(t, calendarList(calendars))
+	srv := api.srv
+
+	for _, id := range []string{"primary", "team@group.calendar.google.com"} {
+		if got, err := ResolveCalendar(srv, id); err != nil || got != id {
+			t.Errorf("ResolveCalendar(%q) = %q, %v", id, got, err)
+		}
+	}
+	if sent := api.sent(); len(sent) != 0 {
+		t.Fatalf("raw IDs were looked up: %v", sent)
+	}
+
+	for range 2 {
+		if got, err := ResolveCalendar(srv, "Tasks"); err != nil || got != calendars["Tasks"] {
+			t.Fatalf("ResolveCalendar(Tasks) = %q, %v", got, err)
+		}
+	}
+	if sent := api.sent(); len(sent) != 1 {
+		t.Errorf("expected the name to be looked up once, got %v", sent)
+	}
+
+	// A rename keeps the cached ID.
+	calendars["Chores"] = calendars["Tasks"]
+	delete(calendars, "Tasks")
+	if got, err := ResolveCalendar(srv, "Tasks"); err != nil || got != calendars["Chores"] {
+		t.Errorf("after a rename got %q, %v", got, err)
+	}
+
+	if _, err := ResolveCalendar(srv, "Missing"); err == nil {
+		t.Error("expected an error for an unknown calendar")
+	}
+}
+
+func TestReresolveOnNotFound(t *testing.T) {
+	calendars := map[string]string{"Tasks": "old@group.calendar.google.com"}
+	t.Setenv("HOME", t.TempDir())
+	api := newFakeAPI(t, calendarList(calendars))
+	srv := api.srv
+	if _, err := ResolveCalendar(srv, "Tasks"); err != nil {
+		t.Fatal(err)
+	}
+
+	// The calendar is deleted and created again under the same name.
+	calendars["Tasks"] = "new@group.calendar.google.com"
+	c := NewCalendarClient(srv, "old@group.calendar.google.com", nil, nil)
+	c.calendarName = "Tasks"
+	api.sent()
+
+	if _, err := c.ListTaskEvents(); err != nil {
+		t.Fatalf("ListTaskEvents: %v", err)
+	}
+	if c.calendarID != calendars["Tasks"] {
+		t.Errorf("calendar ID = %q, want %q", c.calendarID, calendars["Tasks"])
+	}
+	if sent := api.sent(); len(sent) != 3 || sent[2] != "GET /calendar/v3/calendars/new@group.calendar.google.com/events" {
+		t.Errorf("expected the listing to be repeated on the new calendar, got %v", sent)
+	}
+	if got, _ := ResolveCalendar(srv, "Tasks"); got != calendars["Tasks"] {
+		t.Errorf("cache not updated: %q", got)
+	}
+
+	// A raw ID is never resolved again.
+	raw := NewCalendarClient(srv, "gone@group.calendar.google.com", nil, nil)
+	raw.calendarName = "gone@group.calendar.google.com"
+	api.sent()
+	if _, err := raw.ListTaskEvents(); err == nil || len(api.requests) != 1 {
+		t.Errorf("expected a single failed listing, got %v after %v", err, api.requests)
+	}
+}
+
+func TestRenameWarning(t *testing.T) {
+	calendars := map[string]string{"Chores": "tasks@group.calendar.google.com"}
+	t.Setenv("HOME", t.TempDir())
+	srv := newFakeAPI(t, calendarList(calendars)).srv
+	c := NewCalendarClient(srv, "tasks@group.calendar.google.com", nil, nil)
+	c.calendarName = "Tasks"
+
+	if _, err := c.ListTaskEvents(); err != nil {
+		t.Fatal(err)
+	}
+	if !c.renameWarned {
+		t.Error("rename not noticed")
+	}
+}
+
+func TestCreateMissingCalendar(t *testing.T) {
+	calendars := map[string]string{}
+	t.Setenv("HOME", t.TempDir())
+	api := newFakeAPI(t, calendarList(calendars))
+	srv := api.srv
+
+	if _, err := resolveCalendar(srv, "Tasks", &config.Config{}); !errors.Is(err, ErrCalendarNotFound) {
+		t.Fatalf("expected ErrCalendarNotFound without create_missing_calendar, got %v", err)
+	}
+
+	cfg := &config.Config{
+		CreateMissingCalendar: true,
+		NewCalendar:           config.NewCalendarConfig{TimeZone: "Europe/Berlin", Description: "Synced from Taskwarrior"},
+	}
+	id, err := resolveCalendar(srv, "Tasks", cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id != "tasks@group.calendar.google.com" || calendars["Tasks"] != id {
+		t.Errorf("created %q, calendars %v", id, calendars)
+	}
+	if sent := api.sent(); sent[len(sent)-1] != "GET /calendar/v3/users/me/calendarList/"+id {
+		t.Errorf("the calendar list was not checked, last request %s", sent[len(sent)-1])
+	}
+
+	if got, err := ResolveCalendar(srv, "Tasks"); err != nil || got != id || len(api.requests) != 0 {
+		t.Errorf("created calendar not cached: %q, %v after %v", got, err, api.requests)
+	}
+
+	created, err := CreateCalendar(srv, "Errands", cfg.NewCalendar)
+	if err != nil || created.TimeZone != "Europe/Berlin" || created.Description != "Synced from Taskwarrior" {
+		t.Errorf("CreateCalendar sent %+v, %v", created, err)
+	}
+}
+
+func TestCreateMissingCalendarOnce(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	var mu sync.Mutex
+	var listed []string
+	created := 0
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/calendar/v3/users/me/calendarList":
+			mu.Lock()
+			items := strings.Join(listed, ",")
+			mu.Unlock()
+			// Slow enough for every run to list before the first creates
+			time.Sleep(50 * time.Millisecond)
+			reply(w, http.StatusOK, fmt.Sprintf(`{"items":[%s]}`, items))
+		case r.Method == http.MethodPost && r.URL.Path == "/calendar/v3/calendars":
+			mu.Lock()
+			created++
+			listed = append(listed, `{"id":"tasks@group.calendar.google.com","summary":"Tasks"}`)
+			mu.Unlock()
+			reply(w, http.StatusOK, `{"id":"tasks@group.calendar.google.com","summary":"Tasks"}`)
+		default:
+			reply(w, http.StatusOK, `{"id":"tasks@group.calendar.google.com","summary":"Tasks"}`)
+		}
+	})
+	cfg := &config.Config{CreateMissingCalendar: true}
+
+	// The hook runs of one task command resolve the missing calendar at once
+	ids := make([]string, 4)
+	errs := make([]error, len(ids))
+	var wg sync.WaitGroup
+	for i := range ids {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ids[i], errs[i] = resolveCalendar(api.srv, "Tasks", cfg)
+		}()
+	}
+	wg.Wait()
+
+	for i := range ids {
+		if errs[i] != nil || ids[i] != "tasks@group.calendar.google.com" {
+			t.Errorf("run %d resolved %q, %v", i, ids[i], errs[i])
+		}
+	}
+	if created != 1 {
+		t.Errorf("created the calendar %d times", created)
+	}
+}
+
+func TestFindCalendarOutsideTheList(t *testing.T) {
+	api := newFakeAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/calendar/v3/users/me/calendarList":
+			reply(w, http.StatusOK, `{"items":[]}`)
+		case "/calendar/v3/calendars/shared@group.calendar.google.com":
+			reply(w, http.StatusOK, `{"id":"shared@group.calendar.google.com","summary":"Team"}`)
+		case "/calendar/v3/calendars/private@group.calendar.google.com":
+			apiError(w, http.StatusForbidden, "forbidden")
+		default:
+			notFound(w)
+		}
+	})
+	srv := api.srv
+
+	entry, err := FindCalendar(srv, "shared@group.calendar.google.com")
+	if err != nil || entry.Summary != "Team" {
+		t.Fatalf("got %+v, %v", entry, err)
+	}
+	if _, err := FindCalendar(srv, "gone@group.calendar.google.com"); !errors.Is(err, ErrCalendarNotFound) {
+		t.Errorf("missing calendar: got %v, want ErrCalendarNotFound", err)
+	}
+	if _, err := FindCalendar(srv, "private@group.calendar.google.com"); err == nil || errors.Is(err, ErrCalendarNotFound) {
+		t.Errorf("a forbidden lookup must report its error, got %v", err)
+	}
+	for _, r := range api.requests {
+		if !strings.HasPrefix(r, http.MethodGet+" ") {
+			t.Errorf("lookup sent %s", r)
+		}
+	}
+}
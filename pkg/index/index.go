@@ -2,90 +2,152 @@ package index
 
 import (
 	"encoding/json"
-	"os"
-	"path/filepath"
+	"sort"
+	"strings"
 	"sync"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/state"
+)
+
+// Roles distinguish the events a single task can own. The primary work block
+// has no role, so its key is the bare task UUID as before.
+const (
+	RolePrimary  = ""
+	RoleDeadline = "deadline"
+
+	roleSeparator = "#"
 )
 
+// Roles lists every role a task can have an event for.
+var Roles = []string{RolePrimary, RoleDeadline}
+
+// RoleName returns the display name of a role.
+func RoleName(role string) string {
+	if role == RolePrimary {
+		return "primary"
+	}
+	return role
+}
+
+// Key returns the index key of a task's event with the given role.
+func Key(taskID, role string) string {
+	if role == RolePrimary {
+		return taskID
+	}
+	return taskID + roleSeparator + role
+}
+
+// Entry records a task's event and the state it was last synced in.
+type Entry struct {
+	EventID    string `json:"event_id"`
+	CalendarID string `json:"calendar_id,omitempty"`
+	// ETag is the event's etag after our last write or check.
+	ETag string `json:"etag,omitempty"`
+	// Hash is the hash of the event rendered from the task at that time; when
+	// the task renders to the same hash again there is nothing to sync.
+	Hash     string    `json:"hash,omitempty"`
+	SyncedAt time.Time `json:"synced_at,omitempty"`
+}
+
+// EventIndex maps tasks to their calendar events. It is loaded from the
+// state store when created; Save writes back only the keys changed since.
 type EventIndex struct {
-	Mappings map[string]string `json:"mappings"`
-	Path     string            `json:"-"`
+	Mappings map[string]Entry
 	mu       sync.RWMutex
-	dirty    bool
+	changed  map[string]bool
 }
 
 func NewEventIndex() (*EventIndex, error) {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		return nil, err
-	}
-	path := filepath.Join(home, ".config", "taska", "events.json")
-
 	idx := &EventIndex{
-		Mappings: make(map[string]string),
-		Path:     path,
+		Mappings: make(map[string]Entry),
+		changed:  make(map[string]bool),
 	}
-
-	if _, err := os.Stat(path); err == nil {
-		if err := idx.Load(); err != nil {
-			return nil, err
-		}
+	if err := idx.Load(); err != nil {
+		return nil, err
 	}
-
 	return idx, nil
 }
 
 func (idx *EventIndex) Load() error {
-	f, err := os.Open(idx.Path)
-	if err != nil {
-		return err
-	}
-	defer f.Close()
-	return json.NewDecoder(f).Decode(&idx.Mappings)
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return state.View(func(tx *state.Tx) error {
+		return tx.ForEach(state.Events, func(key string, value json.RawMessage) error {
+			var entry Entry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return err
+			}
+			idx.Mappings[key] = entry
+			return nil
+		})
+	})
 }
 
 func (idx *EventIndex) Save() error {
-	idx.mu.RLock()
-	if !idx.dirty {
-		idx.mu.RUnlock()
-		return nil
-	}
-	idx.mu.RUnlock()
-
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
-
-	dir := filepath.Dir(idx.Path)
-	if err := os.MkdirAll(dir, 0700); err != nil {
-		return err
+	if len(idx.changed) == 0 {
+		return nil
 	}
 
-	f, err := os.Create(idx.Path)
+	err := state.Update(func(tx *state.Tx) error {
+		for key := range idx.changed {
+			entry, exists := idx.Mappings[key]
+			var err error
+			if exists {
+				err = tx.Put(state.Events, key, entry)
+			} else {
+				err = tx.Delete(state.Events, key)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
 	if err != nil {
 		return err
 	}
-	defer f.Close()
-
-	if err := json.NewEncoder(f).Encode(idx.Mappings); err != nil {
-		return err
-	}
-	idx.dirty = false
+	clear(idx.changed)
 	return nil
 }
 
-func (idx *EventIndex) Get(taskID string) string {
+// Get returns the event ID recorded under key, or "".
+func (idx *EventIndex) Get(key string) string {
 	idx.mu.RLock()
 	defer idx.mu.RUnlock()
-	return idx.Mappings[taskID]
+	return idx.Mappings[key].EventID
 }
 
-func (idx *EventIndex) Set(taskID, eventID string) {
+// Entry returns the entry recorded under key.
+func (idx *EventIndex) Entry(key string) (Entry, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	entry, ok := idx.Mappings[key]
+	return entry, ok
+}
+
+// Put records an entry under key.
+func (idx *EventIndex) Put(key string, entry Entry) {
 	idx.mu.Lock()
 	defer idx.mu.Unlock()
-	if idx.Mappings[taskID] != eventID {
-		idx.Mappings[taskID] = eventID
-		idx.dirty = true
+	if idx.Mappings[key] != entry {
+		idx.Mappings[key] = entry
+		idx.changed[key] = true
+	}
+}
+
+// KeyForEvent returns the key an event is recorded under.
+func (idx *EventIndex) KeyForEvent(eventID string) (string, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	for key, entry := range idx.Mappings {
+		if entry.EventID == eventID {
+			return key, true
+		}
 	}
+	return "", false
 }
 
 func (idx *EventIndex) Remove(taskID string) {
@@ -93,6 +155,39 @@ func (idx *EventIndex) Remove(taskID string) {
 	defer idx.mu.Unlock()
 	if _, exists := idx.Mappings[taskID]; exists {
 		delete(idx.Mappings, taskID)
-		idx.dirty = true
+		idx.changed[taskID] = true
+	}
+}
+
+// RemoveTask removes the mappings of all of a task's events, whatever their role.
+func (idx *EventIndex) RemoveTask(taskID string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for key := range idx.Mappings {
+		if key == taskID || strings.HasPrefix(key, taskID+roleSeparator) {
+			delete(idx.Mappings, key)
+			idx.changed[key] = true
+		}
+	}
+}
+
+// Tasks returns the UUIDs of the tasks with an event on the calendar, sorted.
+// Entries recorded without a calendar count as on every calendar.
+func (idx *EventIndex) Tasks(calendarID string) []string {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	seen := make(map[string]bool)
+	var uuids []string
+	for key, entry := range idx.Mappings {
+		if entry.CalendarID != "" && entry.CalendarID != calendarID {
+			continue
+		}
+		uuid, _, _ := strings.Cut(key, roleSeparator)
+		if !seen[uuid] {
+			seen[uuid] = true
+			uuids = append(uuids, uuid)
+		}
 	}
+	sort.Strings(uuids)
+	return uuids
 }
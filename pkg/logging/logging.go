@@ -0,0 +1,135 @@
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/harrisonrobin/taska/pkg/paths"
+)
+
+const (
+	logFile = "taska.log"
+
+	// DefaultMaxSize is the size at which the log file is rotated.
+	DefaultMaxSize = 5 * 1024 * 1024
+	// DefaultMaxBackups is the number of rotated log files kept.
+	DefaultMaxBackups = 3
+)
+
+// Attribute keys shared by every sync action.
+const (
+	KeyTask  = "task_uuid"
+	KeyEvent = "event_id"
+	KeyOp    = "op"
+)
+
+// Options configures the logging subsystem.
+type Options struct {
+	// Level is debug, info (default), warn or error.
+	Level string
+	// Format is text (default) or json.
+	Format string
+	// Path of the log file; DefaultPath() when empty.
+	Path       string
+	MaxSize    int64
+	MaxBackups int
+	// Stderr mirrors every record to stderr as text.
+	Stderr bool
+	// Foreground keeps the standard library's log package printing to stderr
+	// as well, so a run in a terminal still shows its failures.
+	Foreground bool
+}
+
+// DefaultPath returns $XDG_STATE_HOME/taska/taska.log, falling back to
+// ~/.local/state/taska/taska.log. Named profiles log under profiles/<name>.
+func DefaultPath() (string, error) {
+	dir, err := paths.StateDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, logFile), nil
+}
+
+// Setup installs a slog logger as the process default, which also routes the
+// standard library's log package through it (and, for Foreground runs, to
+// stderr). The returned closer flushes and
+// closes the log file. If the file cannot be opened, logging falls back to
+// stderr and the error is returned alongside a usable closer.
+func Setup(opts Options) (io.Closer, error) {
+	level, err := parseLevel(opts.Level)
+	if err != nil {
+		return nopCloser{}, err
+	}
+	handlerOpts := &slog.HandlerOptions{Level: level}
+
+	path := opts.Path
+	if path == "" {
+		if path, err = DefaultPath(); err != nil {
+			slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, handlerOpts)))
+			return nopCloser{}, err
+		}
+	}
+	maxSize := opts.MaxSize
+	if maxSize <= 0 {
+		maxSize = DefaultMaxSize
+	}
+	maxBackups := opts.MaxBackups
+	if maxBackups <= 0 {
+		maxBackups = DefaultMaxBackups
+	}
+
+	file, err := NewRotatingFile(path, maxSize, maxBackups)
+	if err != nil {
+		slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, handlerOpts)))
+		return nopCloser{}, err
+	}
+
+	var handler slog.Handler
+	switch strings.ToLower(opts.Format) {
+	case "", "text":
+		handler = slog.NewTextHandler(file, handlerOpts)
+	case "json":
+		handler = slog.NewJSONHandler(file, handlerOpts)
+	default:
+		file.Close()
+		return nopCloser{}, fmt.Errorf("unknown log format '%s' (want text or json)", opts.Format)
+	}
+	if opts.Stderr {
+		handler = multiHandler{handler, slog.NewTextHandler(os.Stderr, handlerOpts)}
+	}
+
+	slog.SetDefault(slog.New(handler))
+	if opts.Foreground && !opts.Stderr {
+		log.SetOutput(io.MultiWriter(os.Stderr, slog.NewLogLogger(handler, slog.LevelInfo).Writer()))
+	}
+	return file, nil
+}
+
+func parseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if s == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("unknown log level '%s': %w", s, err)
+	}
+	return level, nil
+}
+
+// Task returns the attribute identifying the task a record is about.
+func Task(uuid string) slog.Attr { return slog.String(KeyTask, uuid) }
+
+// Event returns the attribute identifying the calendar event a record is about.
+func Event(id string) slog.Attr { return slog.String(KeyEvent, id) }
+
+// Op returns the attribute naming the sync operation, e.g. "create" or "patch".
+func Op(op string) slog.Attr { return slog.String(KeyOp, op) }
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
@@ -0,0 +1,98 @@
+package logging
+
+import (
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "taska.log")
+	r, err := NewRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("NewRotatingFile failed: %v", err)
+	}
+	defer r.Close()
+
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := r.Write([]byte(line)); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	want := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for file, content := range want {
+		b, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) failed: %v", file, err)
+		}
+		if string(b) != content {
+			t.Errorf("Expected %s to contain %q, got %q", file, content, string(b))
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected at most 2 backups, found %s.3", path)
+	}
+}
+
+func TestSetupJSON(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	path := filepath.Join(t.TempDir(), "taska.log")
+	closer, err := Setup(Options{Path: path, Format: "json", Level: "debug"})
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	slog.Info("created event", Op("create"), Task("abc"), Event("evt1"))
+	closer.Close()
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	for _, want := range []string{`"task_uuid":"abc"`, `"event_id":"evt1"`, `"op":"create"`} {
+		if !strings.Contains(string(b), want) {
+			t.Errorf("Expected log to contain %s, got %s", want, string(b))
+		}
+	}
+}
+
+func TestSetupForegroundKeepsStderr(t *testing.T) {
+	defer slog.SetDefault(slog.Default())
+	dir := t.TempDir()
+	stderr, err := os.Create(filepath.Join(dir, "stderr"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stderr.Close()
+	saved := os.Stderr
+	os.Stderr = stderr
+	defer func() { os.Stderr = saved }()
+
+	path := filepath.Join(dir, "taska.log")
+	closer, err := Setup(Options{Path: path, Foreground: true})
+	if err != nil {
+		t.Fatalf("Setup failed: %v", err)
+	}
+	log.Printf("could not start background process")
+	slog.Info("synced")
+	closer.Close()
+
+	printed, _ := os.ReadFile(stderr.Name())
+	if !strings.Contains(string(printed), "could not start background process") {
+		t.Errorf("log.Printf did not reach stderr: %q", printed)
+	}
+	if strings.Contains(string(printed), "synced") {
+		t.Errorf("slog records reached stderr without Stderr: %q", printed)
+	}
+	logged, _ := os.ReadFile(path)
+	if !strings.Contains(string(logged), "could not start background process") {
+		t.Errorf("log.Printf did not reach the log file: %q", logged)
+	}
+}
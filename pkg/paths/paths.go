@@ -0,0 +1,98 @@
+// Package paths resolves the per-profile configuration and state directories.
+//
+// The default profile keeps the historical layout (~/.config/taska and
+// ~/.local/state/taska). A named profile lives in a "profiles/<name>"
+// subdirectory of each, with its own credentials, token, config and state.
+package paths
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+const (
+	// AppName is the directory name used under the XDG base directories.
+	AppName = "taska"
+	// DefaultProfile is the name of the profile used when none is selected.
+	DefaultProfile = "default"
+
+	profilesDir = "profiles"
+)
+
+var (
+	profile      = DefaultProfile
+	validProfile = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]*$`)
+)
+
+// ValidateProfile checks that name can be used as a directory name.
+func ValidateProfile(name string) error {
+	if !validProfile.MatchString(name) {
+		return fmt.Errorf("invalid profile name '%s': use letters, digits, '-' and '_'", name)
+	}
+	return nil
+}
+
+// SetProfile selects the profile for the rest of the process. An empty name
+// selects the default profile.
+func SetProfile(name string) error {
+	if name == "" {
+		name = DefaultProfile
+	}
+	if err := ValidateProfile(name); err != nil {
+		return err
+	}
+	profile = name
+	return nil
+}
+
+// Profile returns the selected profile name.
+func Profile() string {
+	return profile
+}
+
+// IsDefault reports whether the default profile is selected.
+func IsDefault() bool {
+	return profile == DefaultProfile
+}
+
+// BaseConfigDir returns ~/.config/taska, the config directory of the default
+// profile, whichever profile is selected.
+func BaseConfigDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", AppName), nil
+}
+
+// ConfigDir returns the directory holding the selected profile's credentials,
+// token, config and sync state.
+func ConfigDir() (string, error) {
+	base, err := BaseConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return forProfile(base), nil
+}
+
+// StateDir returns $XDG_STATE_HOME/taska (or ~/.local/state/taska) for the
+// selected profile. It holds the log file.
+func StateDir() (string, error) {
+	if state := os.Getenv("XDG_STATE_HOME"); state != "" {
+		return forProfile(filepath.Join(state, AppName)), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return forProfile(filepath.Join(home, ".local", "state", AppName)), nil
+}
+
+func forProfile(base string) string {
+	if profile == DefaultProfile {
+		return base
+	}
+	return filepath.Join(base, profilesDir, profile)
+}
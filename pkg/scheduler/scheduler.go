@@ -0,0 +1,268 @@
+// Package scheduler updates task events at the moments they change on their
+// own, without the task changing: a pending task's event gets the overdue
+// mark once its scheduled or due date passes.
+//
+// Every sync records when the task's rendering changes next; `taska tick`,
+// `taska daemon` and the hook runs export the tasks that are due from
+// Taskwarrior, render them again and patch their events' summaries.
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/google"
+	"github.com/harrisonrobin/taska/pkg/logging"
+	"github.com/harrisonrobin/taska/pkg/state"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+)
+
+// exportBatchSize limits how many UUIDs are passed to a single export.
+const exportBatchSize = 100
+
+// Entry is a task whose events change at At. The task itself is exported
+// from Taskwarrior when the entry is due, as it may have changed without
+// taska's hook running.
+type Entry struct {
+	UUID string `json:"uuid"`
+	// CalendarID is the calendar the task's events are on, or "" for the
+	// profile's calendar.
+	CalendarID string    `json:"calendar_id,omitempty"`
+	At         time.Time `json:"at"`
+}
+
+// Scheduler holds the entries. It is loaded from the state store when
+// created; Save writes back only the changes.
+type Scheduler struct {
+	Entries map[string]Entry
+	// LastSync is when a background run last reached the calendar.
+	LastSync time.Time
+	changed  map[string]bool
+	synced   bool
+}
+
+func New() (*Scheduler, error) {
+	s := &Scheduler{
+		Entries: make(map[string]Entry),
+		changed: make(map[string]bool),
+	}
+	if err := s.Load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *Scheduler) Load() error {
+	return state.View(func(tx *state.Tx) error {
+		if _, err := tx.Get(state.Meta, state.LastSyncKey, &s.LastSync); err != nil {
+			return err
+		}
+		return tx.ForEach(state.Schedule, func(uuid string, value json.RawMessage) error {
+			var entry Entry
+			if err := json.Unmarshal(value, &entry); err != nil {
+				return err
+			}
+			s.Entries[uuid] = entry
+			return nil
+		})
+	})
+}
+
+func (s *Scheduler) Save() error {
+	if len(s.changed) == 0 && !s.synced {
+		return nil
+	}
+	err := state.Update(func(tx *state.Tx) error {
+		if s.synced {
+			if err := tx.Put(state.Meta, state.LastSyncKey, s.LastSync); err != nil {
+				return err
+			}
+		}
+		for uuid := range s.changed {
+			entry, exists := s.Entries[uuid]
+			var err error
+			if exists {
+				err = tx.Put(state.Schedule, uuid, entry)
+			} else {
+				err = tx.Delete(state.Schedule, uuid)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	clear(s.changed)
+	s.synced = false
+	return nil
+}
+
+// NextChange returns when a task's rendering next changes on its own after
+// now. Only pending tasks that are neither started nor overdue yet change:
+// they become overdue when the earlier of their scheduled and due dates
+// passes.
+func NextChange(task *taskwarrior.Task, now time.Time) (time.Time, bool) {
+	if task.Status != taskwarrior.PENDING || (task.Start != nil && !task.Start.IsZero()) {
+		return time.Time{}, false
+	}
+	var at time.Time
+	for _, d := range []*taskwarrior.CustomTime{task.Scheduled, task.Due} {
+		if d == nil || d.IsZero() {
+			continue
+		}
+		if !d.After(now) {
+			return time.Time{}, false // already overdue
+		}
+		if at.IsZero() || d.Before(at) {
+			at = d.Time
+		}
+	}
+	return at, !at.IsZero()
+}
+
+// Track records a task as synced to a calendar: it is scheduled for its next
+// change, or dropped if it has none.
+func (s *Scheduler) Track(task taskwarrior.Task, calendarID string, now time.Time) {
+	at, ok := NextChange(&task, now)
+	if !ok || !task.WantsEvent() {
+		s.Remove(task.UUID)
+		return
+	}
+	entry := Entry{UUID: task.UUID, CalendarID: calendarID, At: at}
+	if old, exists := s.Entries[task.UUID]; exists && old.CalendarID == entry.CalendarID && old.At.Equal(entry.At) {
+		return
+	}
+	s.Entries[task.UUID] = entry
+	s.changed[task.UUID] = true
+}
+
+func (s *Scheduler) Remove(uuid string) {
+	if _, exists := s.Entries[uuid]; exists {
+		delete(s.Entries, uuid)
+		s.changed[uuid] = true
+	}
+}
+
+// MoveCalendar points the entries of one calendar at another.
+func (s *Scheduler) MoveCalendar(fromID, toID string) {
+	for uuid, entry := range s.Entries {
+		if entry.CalendarID == fromID {
+			entry.CalendarID = toID
+			s.Entries[uuid] = entry
+			s.changed[uuid] = true
+		}
+	}
+}
+
+// Due returns the entries due at now, earliest first.
+func (s *Scheduler) Due(now time.Time) []Entry {
+	var due []Entry
+	for _, entry := range s.Entries {
+		if !entry.At.After(now) {
+			due = append(due, entry)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].At.Equal(due[j].At) {
+			return due[i].At.Before(due[j].At)
+		}
+		return due[i].UUID < due[j].UUID
+	})
+	return due
+}
+
+// Next returns the time of the earliest entry.
+func (s *Scheduler) Next() (time.Time, bool) {
+	var next time.Time
+	for _, entry := range s.Entries {
+		if next.IsZero() || entry.At.Before(next) {
+			next = entry.At
+		}
+	}
+	return next, !next.IsZero()
+}
+
+// MarkSynced records the time of a successful background run.
+func (s *Scheduler) MarkSynced(now time.Time) {
+	s.LastSync = now
+	s.synced = true
+}
+
+// ClientFor opens the client of a calendar ID, "" being the profile's
+// calendar.
+type ClientFor func(calendarID string) (*google.CalendarClient, error)
+
+// Run exports the due entries' tasks, renders them again and patches their
+// events, then schedules each task for its next change. Tasks that are gone
+// or no longer pending are dropped: their own sync updates their events.
+// Entries that failed with an error worth retrying stay due for the next
+// run. It returns how many tasks were refreshed.
+func (s *Scheduler) Run(ctx context.Context, now time.Time, clientFor ClientFor, export google.Export) (int, error) {
+	due := s.Due(now)
+	tasks := make(map[string]taskwarrior.Task, len(due))
+	for start := 0; start < len(due); start += exportBatchSize {
+		var uuids []string
+		for _, entry := range due[start:min(start+exportBatchSize, len(due))] {
+			uuids = append(uuids, entry.UUID)
+		}
+		exported, err := export(uuids)
+		if err != nil {
+			return 0, fmt.Errorf("could not export the due tasks: %w", err)
+		}
+		for _, task := range exported {
+			tasks[task.UUID] = task
+		}
+	}
+
+	byCalendar := make(map[string][]taskwarrior.Task)
+	var calendars []string
+	for _, entry := range due {
+		task, ok := tasks[entry.UUID]
+		if !ok || task.Status != taskwarrior.PENDING {
+			slog.Info("scheduler: task gone or no longer pending", logging.Op("tick"), logging.Task(entry.UUID))
+			s.Remove(entry.UUID)
+			continue
+		}
+		if _, ok := byCalendar[entry.CalendarID]; !ok {
+			calendars = append(calendars, entry.CalendarID)
+		}
+		byCalendar[entry.CalendarID] = append(byCalendar[entry.CalendarID], task)
+	}
+
+	refreshed := 0
+	var failed error
+	for _, calendarID := range calendars {
+		tasks := byCalendar[calendarID]
+		c, err := clientFor(calendarID)
+		if err != nil {
+			failed = fmt.Errorf("could not open calendar %q: %w", calendarID, err)
+			slog.Error("scheduler: could not open calendar", logging.Op("tick"), "calendar", calendarID, "error", err)
+			continue
+		}
+		errs := c.RefreshSummaries(ctx, tasks)
+		for _, task := range tasks {
+			err := errs[task.UUID]
+			switch {
+			case err == nil:
+				slog.Info("scheduler: refreshed event", logging.Op("tick"), logging.Task(task.UUID))
+				refreshed++
+			case google.Retryable(err):
+				slog.Warn("scheduler: will retry", logging.Op("tick"), logging.Task(task.UUID), "error", err)
+				failed = err
+				continue
+			default:
+				// e.g. edited on the calendar: the next sync of the task decides
+				slog.Error("scheduler: could not refresh event", logging.Op("tick"), logging.Task(task.UUID), "error", err)
+			}
+			s.Track(task, calendarID, now)
+		}
+	}
+	return refreshed, failed
+}
@@ -0,0 +1,167 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/google"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+)
+
+func date(t time.Time) *taskwarrior.CustomTime {
+	return &taskwarrior.CustomTime{Time: t}
+}
+
+func TestNextChange(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	later, latest := now.Add(time.Hour), now.Add(48*time.Hour)
+
+	tests := []struct {
+		name   string
+		task   taskwarrior.Task
+		wantAt time.Time
+	}{
+		{
+			name:   "scheduled first",
+			task:   taskwarrior.Task{Status: taskwarrior.PENDING, Scheduled: date(later), Due: date(latest)},
+			wantAt: later,
+		},
+		{
+			name:   "due only",
+			task:   taskwarrior.Task{Status: taskwarrior.PENDING, Due: date(latest)},
+			wantAt: latest,
+		},
+		{
+			name:   "due before scheduled",
+			task:   taskwarrior.Task{Status: taskwarrior.PENDING, Scheduled: date(latest), Due: date(later)},
+			wantAt: later,
+		},
+		{
+			name: "already overdue",
+			task: taskwarrior.Task{Status: taskwarrior.PENDING, Scheduled: date(now.Add(-time.Hour)), Due: date(latest)},
+		},
+		{
+			name: "started",
+			task: taskwarrior.Task{Status: taskwarrior.PENDING, Start: date(now), Due: date(latest)},
+		},
+		{
+			name: "completed",
+			task: taskwarrior.Task{Status: taskwarrior.COMPLETED, Due: date(latest)},
+		},
+		{
+			name: "no dates",
+			task: taskwarrior.Task{Status: taskwarrior.PENDING},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, ok := NextChange(&tt.task, now)
+			if ok != !tt.wantAt.IsZero() || !at.Equal(tt.wantAt) {
+				t.Errorf("got %v %v, want %v", at, ok, tt.wantAt)
+			}
+		})
+	}
+}
+
+func TestTrack(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Track(taskwarrior.Task{UUID: "u1", Status: taskwarrior.PENDING, Due: date(now.Add(2 * time.Hour))}, "cal", now)
+	s.Track(taskwarrior.Task{UUID: "u2", Status: taskwarrior.PENDING, Scheduled: date(now.Add(time.Hour))}, "cal", now)
+	s.Track(taskwarrior.Task{UUID: "u3", Status: taskwarrior.PENDING}, "cal", now)
+	s.MarkSynced(now)
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Entries) != 2 || !s.LastSync.Equal(now) {
+		t.Fatalf("reloaded %v, last sync %v", s.Entries, s.LastSync)
+	}
+	if next, ok := s.Next(); !ok || !next.Equal(now.Add(time.Hour)) {
+		t.Errorf("next = %v %v", next, ok)
+	}
+	if due := s.Due(now.Add(30 * time.Minute)); len(due) != 0 {
+		t.Errorf("due early: %v", due)
+	}
+	due := s.Due(now.Add(3 * time.Hour))
+	if len(due) != 2 || due[0].UUID != "u2" || due[1].UUID != "u1" {
+		t.Errorf("due = %v", due)
+	}
+	if s.Entries["u1"].CalendarID != "cal" || !s.Entries["u1"].At.Equal(now.Add(2*time.Hour)) {
+		t.Errorf("u1 = %+v", s.Entries["u1"])
+	}
+
+	// Completing a task drops it
+	s.Track(taskwarrior.Task{UUID: "u1", Status: taskwarrior.COMPLETED, Due: date(now.Add(2 * time.Hour))}, "cal", now)
+	if err := s.Save(); err != nil {
+		t.Fatal(err)
+	}
+	s, err = New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Entries["u1"]; ok || len(s.Entries) != 1 {
+		t.Errorf("entries after completing u1: %v", s.Entries)
+	}
+}
+
+func TestRunExportsDueTasks(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	s, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, uuid := range []string{"gone", "done", "open"} {
+		s.Track(taskwarrior.Task{UUID: uuid, Status: taskwarrior.PENDING, Due: date(now.Add(time.Hour))}, "cal", now)
+	}
+	s.Track(taskwarrior.Task{UUID: "later", Status: taskwarrior.PENDING, Due: date(now.Add(48 * time.Hour))}, "cal", now)
+
+	// Completed and open since the last sync, without the hook seeing it
+	var exported []string
+	export := func(uuids []string) ([]taskwarrior.Task, error) {
+		exported = append(exported, uuids...)
+		return []taskwarrior.Task{
+			{UUID: "done", Status: taskwarrior.COMPLETED},
+			{UUID: "open", Status: taskwarrior.PENDING, Due: date(now.Add(time.Hour))},
+		}, nil
+	}
+	clientFor := func(string) (*google.CalendarClient, error) {
+		return nil, errors.New("offline")
+	}
+
+	refreshed, err := s.Run(context.Background(), now.Add(2*time.Hour), clientFor, export)
+	if refreshed != 0 || err == nil {
+		t.Errorf("got %d, %v; want the offline calendar's error", refreshed, err)
+	}
+	if len(exported) != 3 {
+		t.Errorf("exported %v, want the three due tasks", exported)
+	}
+	if _, ok := s.Entries["gone"]; ok {
+		t.Error("the deleted task is still scheduled")
+	}
+	if _, ok := s.Entries["done"]; ok {
+		t.Error("the completed task is still scheduled")
+	}
+	for _, uuid := range []string{"open", "later"} {
+		if _, ok := s.Entries[uuid]; !ok {
+			t.Errorf("%s was dropped", uuid)
+		}
+	}
+
+	exportErr := func([]string) ([]taskwarrior.Task, error) { return nil, errors.New("no task binary") }
+	if _, err := s.Run(context.Background(), now.Add(2*time.Hour), clientFor, exportErr); err == nil || len(s.Entries) != 2 {
+		t.Errorf("a failed export dropped entries or went unreported: %v, %v", err, s.Entries)
+	}
+}
@@ -0,0 +1,391 @@
+// Package state keeps taska's sync state (the event index, the schedule, the
+// project colours and the resolved calendar IDs) in a single bbolt database, state.db, in the
+// profile's config directory.
+//
+// The database is opened for each transaction and closed again, so the many
+// short-lived hook processes that run at once only hold its lock briefly.
+// Values are JSON encoded.
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/paths"
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	// SchemaVersion is the layout this version of taska reads and writes.
+	SchemaVersion = 5
+
+	dbFile = "state.db"
+
+	// lockTimeout bounds how long a process waits for another one to finish
+	// its transaction.
+	lockTimeout = 10 * time.Second
+)
+
+// Buckets.
+const (
+	// Meta holds the schema version and process-wide values such as the
+	// last sync time.
+	Meta = "meta"
+	// Events maps index keys (task UUID, or UUID#role) to index entries.
+	Events = "events"
+	// Schedule holds the scheduler's entries by task UUID.
+	Schedule = "schedule"
+	// Colors holds the colour cache state by project.
+	Colors = "colors"
+	// Conflicts holds the recorded sync conflicts by index key.
+	Conflicts = "conflicts"
+	// Calendars caches the calendar IDs that calendar names resolved to.
+	Calendars = "calendars"
+)
+
+// Meta keys.
+const (
+	// LastSyncKey is the time of the last successful background run.
+	LastSyncKey = "last_sync"
+	// MigrationKey is the calendar migration in progress, if any.
+	MigrationKey = "migration"
+
+	schemaVersionKey = "schema_version"
+)
+
+var buckets = []string{Meta, Events, Schedule, Colors, Conflicts, Calendars}
+
+// overdueBucket held the overdue sweep entries until the scheduler replaced
+// them in schema version 5.
+const overdueBucket = "overdue"
+
+// The JSON files replaced by state.db, imported once by the migration to
+// schema version 1.
+const (
+	legacyEvents  = "events.json"
+	legacyOverdue = "pending_tasks.json"
+	legacyColors  = "project_colors.json"
+)
+
+// ErrNewerSchema is returned when state.db was written by a newer taska.
+var ErrNewerSchema = errors.New("state.db was written by a newer version of taska")
+
+// Path returns the location of the state database for the selected profile.
+func Path() (string, error) {
+	dir, err := paths.ConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, dbFile), nil
+}
+
+// View runs fn in a read-only transaction.
+func View(fn func(tx *Tx) error) error {
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.View(func(btx *bolt.Tx) error {
+		return fn(&Tx{tx: btx})
+	})
+}
+
+// Update runs fn in a read-write transaction. If fn returns an error nothing
+// is written.
+func Update(fn func(tx *Tx) error) error {
+	db, err := open()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	return db.Update(func(btx *bolt.Tx) error {
+		return fn(&Tx{tx: btx})
+	})
+}
+
+// Tx is a transaction on the state database.
+type Tx struct {
+	tx *bolt.Tx
+}
+
+// Get decodes the value stored under key into v. It reports whether the key
+// exists.
+func (t *Tx) Get(bucket, key string, v any) (bool, error) {
+	raw := t.bucket(bucket).Get([]byte(key))
+	if raw == nil {
+		return false, nil
+	}
+	if err := json.Unmarshal(raw, v); err != nil {
+		return true, fmt.Errorf("corrupt state %s/%s: %w", bucket, key, err)
+	}
+	return true, nil
+}
+
+// Put stores v under key.
+func (t *Tx) Put(bucket, key string, v any) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return t.bucket(bucket).Put([]byte(key), raw)
+}
+
+// Delete removes key. Deleting a missing key is not an error.
+func (t *Tx) Delete(bucket, key string) error {
+	return t.bucket(bucket).Delete([]byte(key))
+}
+
+// ForEach calls fn for every key in the bucket with its raw JSON value. The
+// value is only valid during the call.
+func (t *Tx) ForEach(bucket string, fn func(key string, value json.RawMessage) error) error {
+	return t.bucket(bucket).ForEach(func(k, v []byte) error {
+		return fn(string(k), v)
+	})
+}
+
+// Clear removes every key from the bucket.
+func (t *Tx) Clear(bucket string) error {
+	if err := t.tx.DeleteBucket([]byte(bucket)); err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+		return err
+	}
+	_, err := t.tx.CreateBucket([]byte(bucket))
+	return err
+}
+
+func (t *Tx) bucket(name string) *bolt.Bucket {
+	b := t.tx.Bucket([]byte(name))
+	if b == nil {
+		// Every bucket is created by the schema migration.
+		panic("state: unknown bucket " + name)
+	}
+	return b
+}
+
+// open opens the database, creating and migrating it as needed.
+func open() (*bolt.DB, error) {
+	path, err := Path()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: lockTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("could not open %s: %w", path, err)
+	}
+	if err := migrate(db, filepath.Dir(path)); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// migrations[v] upgrades the database from schema version v to v+1.
+var migrations = []func(m *migrator, tx *Tx) error{
+	(*migrator).importLegacy,
+	(*migrator).indexEntries,
+	(*migrator).addBuckets,
+	(*migrator).addBuckets,
+	(*migrator).scheduleEntries,
+}
+
+// migrator carries the state of one migration run.
+type migrator struct {
+	dir string
+	// imported lists the legacy files to move aside once the migration has
+	// been committed.
+	imported []string
+}
+
+// migrate brings the database up to SchemaVersion.
+func migrate(db *bolt.DB, dir string) error {
+	var version int
+	err := db.View(func(btx *bolt.Tx) error {
+		var err error
+		version, err = schemaVersion(btx)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if version == SchemaVersion {
+		return nil
+	}
+	if version > SchemaVersion {
+		return fmt.Errorf("%w (schema %d, this version reads %d)", ErrNewerSchema, version, SchemaVersion)
+	}
+
+	m := &migrator{dir: dir}
+	err = db.Update(func(btx *bolt.Tx) error {
+		for _, name := range buckets {
+			if _, err := btx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		tx := &Tx{tx: btx}
+		for v := version; v < SchemaVersion; v++ {
+			if err := migrations[v](m, tx); err != nil {
+				return fmt.Errorf("upgrading to schema %d: %w", v+1, err)
+			}
+		}
+		return btx.Bucket([]byte(Meta)).Put([]byte(schemaVersionKey), []byte(strconv.Itoa(SchemaVersion)))
+	})
+	if err != nil {
+		return fmt.Errorf("state migration failed: %w", err)
+	}
+
+	// Keep the old files around, renamed so they are not imported again.
+	for _, path := range m.imported {
+		os.Rename(path, path+".migrated")
+	}
+	return nil
+}
+
+func schemaVersion(btx *bolt.Tx) (int, error) {
+	meta := btx.Bucket([]byte(Meta))
+	if meta == nil {
+		return 0, nil
+	}
+	raw := meta.Get([]byte(schemaVersionKey))
+	if raw == nil {
+		return 0, nil
+	}
+	v, err := strconv.Atoi(string(raw))
+	if err != nil {
+		return 0, fmt.Errorf("corrupt schema version %q", raw)
+	}
+	return v, nil
+}
+
+// importLegacy (schema 1) copies the JSON state files into the new buckets.
+func (m *migrator) importLegacy(tx *Tx) error {
+	dir := m.dir
+
+	// events.json: {"<key>": "<event id>"}
+	var mappings map[string]string
+	if ok, err := readLegacy(filepath.Join(dir, legacyEvents), &mappings); err != nil {
+		return err
+	} else if ok {
+		for key, eventID := range mappings {
+			if err := tx.Put(Events, key, eventID); err != nil {
+				return err
+			}
+		}
+		m.imported = append(m.imported, filepath.Join(dir, legacyEvents))
+	}
+
+	// pending_tasks.json: {"entries": {"<uuid>": {...}}, "last_sync": "..."}
+	var table struct {
+		Entries  map[string]json.RawMessage `json:"entries"`
+		LastSync time.Time                  `json:"last_sync"`
+	}
+	if ok, err := readLegacy(filepath.Join(dir, legacyOverdue), &table); err != nil {
+		return err
+	} else if ok {
+		if _, err := tx.tx.CreateBucketIfNotExists([]byte(overdueBucket)); err != nil {
+			return err
+		}
+		for uuid, entry := range table.Entries {
+			if err := tx.Put(overdueBucket, uuid, entry); err != nil {
+				return err
+			}
+		}
+		if !table.LastSync.IsZero() {
+			if err := tx.Put(Meta, LastSyncKey, table.LastSync); err != nil {
+				return err
+			}
+		}
+		m.imported = append(m.imported, filepath.Join(dir, legacyOverdue))
+	}
+
+	// project_colors.json: {"<project>": {...}}
+	var projects map[string]json.RawMessage
+	if ok, err := readLegacy(filepath.Join(dir, legacyColors), &projects); err != nil {
+		return err
+	} else if ok {
+		for project, s := range projects {
+			if err := tx.Put(Colors, project, s); err != nil {
+				return err
+			}
+		}
+		m.imported = append(m.imported, filepath.Join(dir, legacyColors))
+	}
+	return nil
+}
+
+// indexEntries (schema 2) turns the event index values from bare event IDs
+// into entries with sync metadata.
+func (m *migrator) indexEntries(tx *Tx) error {
+	converted := make(map[string]map[string]string)
+	err := tx.ForEach(Events, func(key string, value json.RawMessage) error {
+		var eventID string
+		if err := json.Unmarshal(value, &eventID); err != nil {
+			return nil // already an entry
+		}
+		converted[key] = map[string]string{"event_id": eventID}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for key, entry := range converted {
+		if err := tx.Put(Events, key, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addBuckets (schemas 3 and 4) adds the Conflicts and Calendars buckets.
+// Missing buckets are created before the steps run, so there is nothing left
+// to do.
+func (m *migrator) addBuckets(tx *Tx) error {
+	return nil
+}
+
+// scheduleEntries (schema 5) turns the overdue sweep entries into scheduler
+// entries, due when the task was scheduled. The scheduler reads the task
+// itself from Taskwarrior when the entry is due.
+func (m *migrator) scheduleEntries(tx *Tx) error {
+	old := tx.tx.Bucket([]byte(overdueBucket))
+	if old == nil {
+		return nil
+	}
+	err := old.ForEach(func(k, v []byte) error {
+		var entry struct {
+			Scheduled time.Time `json:"scheduled"`
+		}
+		if err := json.Unmarshal(v, &entry); err != nil {
+			return fmt.Errorf("overdue entry %s: %w", k, err)
+		}
+		return tx.Put(Schedule, string(k), map[string]any{
+			"uuid": string(k),
+			"at":   entry.Scheduled,
+		})
+	})
+	if err != nil {
+		return err
+	}
+	return tx.tx.DeleteBucket([]byte(overdueBucket))
+}
+
+func readLegacy(path string, v any) (bool, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if err := json.Unmarshal(b, v); err != nil {
+		return false, fmt.Errorf("could not import %s: %w", path, err)
+	}
+	return true, nil
+}
@@ -0,0 +1,175 @@
+package state
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// useTempHome points the config directory at a fresh temporary home.
+func useTempHome(t *testing.T) string {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	dir := filepath.Join(home, ".config", "taska")
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestMigrateLegacyFiles(t *testing.T) {
+	dir := useTempHome(t)
+	write := func(name, content string) {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(legacyEvents, `{"u1":"ev1","u1#deadline":"ev2"}`)
+	write(legacyOverdue, `{"entries":{"u1":{"gcal_id":"ev1","summary":"Write","scheduled":"2025-01-02T10:00:00Z"}},"last_sync":"2025-01-01T08:00:00Z"}`)
+	write(legacyColors, `{"Work":{"color_id":"3","active_tasks":1,"last_modified":"2025-01-01T00:00:00Z"}}`)
+
+	err := View(func(tx *Tx) error {
+		var event struct {
+			EventID string `json:"event_id"`
+		}
+		if ok, err := tx.Get(Events, "u1#deadline", &event); !ok || err != nil || event.EventID != "ev2" {
+			t.Errorf("events: got %+v, %v, %v", event, ok, err)
+		}
+		var entry struct {
+			UUID string    `json:"uuid"`
+			At   time.Time `json:"at"`
+		}
+		if ok, _ := tx.Get(Schedule, "u1", &entry); !ok || entry.UUID != "u1" ||
+			!entry.At.Equal(time.Date(2025, 1, 2, 10, 0, 0, 0, time.UTC)) {
+			t.Errorf("overdue entry not imported into the schedule: %+v", entry)
+		}
+		var lastSync time.Time
+		if ok, _ := tx.Get(Meta, LastSyncKey, &lastSync); !ok || !lastSync.Equal(time.Date(2025, 1, 1, 8, 0, 0, 0, time.UTC)) {
+			t.Errorf("last sync = %v", lastSync)
+		}
+		var color struct {
+			ColorID string `json:"color_id"`
+		}
+		if ok, _ := tx.Get(Colors, "Work", &color); !ok || color.ColorID != "3" {
+			t.Errorf("colour not imported: %+v", color)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %v", err)
+	}
+
+	for _, name := range []string{legacyEvents, legacyOverdue, legacyColors} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("%s was not moved aside", name)
+		}
+		if _, err := os.Stat(filepath.Join(dir, name+".migrated")); err != nil {
+			t.Errorf("%s.migrated missing: %v", name, err)
+		}
+	}
+
+	// A legacy file appearing later is not imported again.
+	write(legacyEvents, `{"u2":"ev3"}`)
+	View(func(tx *Tx) error {
+		if ok, _ := tx.Get(Events, "u2", new(string)); ok {
+			t.Error("legacy file imported twice")
+		}
+		return nil
+	})
+}
+
+func TestMigrateIndexEntries(t *testing.T) {
+	useTempHome(t)
+	path, _ := Path()
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Update(func(tx *bolt.Tx) error {
+		for _, name := range buckets {
+			tx.CreateBucketIfNotExists([]byte(name))
+		}
+		tx.Bucket([]byte(Events)).Put([]byte("u1"), []byte(`"ev1"`))
+		return tx.Bucket([]byte(Meta)).Put([]byte(schemaVersionKey), []byte("1"))
+	})
+	db.Close()
+
+	err = View(func(tx *Tx) error {
+		var entry map[string]string
+		if _, err := tx.Get(Events, "u1", &entry); err != nil {
+			return err
+		}
+		if entry["event_id"] != "ev1" {
+			t.Errorf("entry = %v", entry)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("View: %v", err)
+	}
+}
+
+func TestUpdateRollsBack(t *testing.T) {
+	useTempHome(t)
+	boom := errors.New("boom")
+	err := Update(func(tx *Tx) error {
+		if err := tx.Put(Events, "u1", "ev1"); err != nil {
+			return err
+		}
+		return boom
+	})
+	if !errors.Is(err, boom) {
+		t.Fatalf("Update returned %v", err)
+	}
+	View(func(tx *Tx) error {
+		if ok, _ := tx.Get(Events, "u1", new(string)); ok {
+			t.Error("failed transaction was committed")
+		}
+		return nil
+	})
+}
+
+func TestNewerSchemaIsRejected(t *testing.T) {
+	useTempHome(t)
+	path, _ := Path()
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Update(func(tx *bolt.Tx) error {
+		b, _ := tx.CreateBucketIfNotExists([]byte(Meta))
+		return b.Put([]byte(schemaVersionKey), []byte(strconv.Itoa(SchemaVersion+1)))
+	})
+	db.Close()
+
+	if err := View(func(*Tx) error { return nil }); !errors.Is(err, ErrNewerSchema) {
+		t.Errorf("got %v, want ErrNewerSchema", err)
+	}
+}
+
+func TestForEachAndClear(t *testing.T) {
+	useTempHome(t)
+	Update(func(tx *Tx) error {
+		tx.Put(Colors, "A", map[string]string{"color_id": "1"})
+		return tx.Put(Colors, "B", map[string]string{"color_id": "2"})
+	})
+	count := func() (n int) {
+		View(func(tx *Tx) error {
+			return tx.ForEach(Colors, func(string, json.RawMessage) error { n++; return nil })
+		})
+		return n
+	}
+	if n := count(); n != 2 {
+		t.Fatalf("ForEach saw %d keys, want 2", n)
+	}
+	Update(func(tx *Tx) error { return tx.Clear(Colors) })
+	if n := count(); n != 0 {
+		t.Errorf("Clear left %d keys", n)
+	}
+}
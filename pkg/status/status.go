@@ -0,0 +1,204 @@
+package status
+
+import (
+	"sort"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"github.com/harrisonrobin/taska/pkg/util"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Sync states of a task.
+const (
+	Synced       = "synced"
+	MissingEvent = "missing event"
+	Stale        = "stale"
+	Orphan       = "orphan event"
+	NotSyncable  = "not syncable"
+)
+
+// TaskStatus is the sync state of a single task, or of an orphan event.
+type TaskStatus struct {
+	UUID        string `json:"uuid"`
+	Description string `json:"description,omitempty"`
+	State       string `json:"state"`
+	Detail      string `json:"detail,omitempty"`
+	// EventIDs maps each role ("primary", "deadline") to its live event.
+	EventIDs map[string]string `json:"event_ids,omitempty"`
+}
+
+// Kinds of queued operations.
+const (
+	// OpMarkOverdue marks a task's events overdue once its scheduled or due
+	// date passes.
+	OpMarkOverdue = "mark-overdue"
+	// OpRetryOverdue is an overdue mark whose moment has passed that the
+	// next tick applies, e.g. after the calendar could not be reached.
+	OpRetryOverdue = "retry-overdue"
+	// OpResolveConflict is a conflict kept for `taska conflicts` to decide.
+	OpResolveConflict = "resolve-conflict"
+	// OpMigrate is an interrupted calendar migration that `taska migrate`
+	// resumes.
+	OpMigrate = "migrate"
+)
+
+// QueuedOp is an operation taska will perform later without a task change,
+// such as marking a task overdue once its scheduled or due date passes.
+type QueuedOp struct {
+	UUID    string `json:"uuid,omitempty"`
+	EventID string `json:"event_id,omitempty"`
+	Op      string `json:"op"`
+	// Due is when the operation runs, or when it was queued if it waits
+	// for the user.
+	Due    time.Time `json:"due"`
+	Detail string    `json:"detail,omitempty"`
+}
+
+// Report is the output of `taska status`.
+type Report struct {
+	Calendar     string     `json:"calendar"`
+	LastSync     *time.Time `json:"last_sync,omitempty"`
+	TokenExpiry  *time.Time `json:"token_expiry,omitempty"`
+	TokenRefresh bool       `json:"token_refreshable"`
+	TokenError   string     `json:"token_error,omitempty"`
+	// ServiceAccount is the account's email when one is used instead of a token.
+	ServiceAccount string       `json:"service_account,omitempty"`
+	Queued         []QueuedOp   `json:"queued"`
+	Tasks          []TaskStatus `json:"tasks"`
+}
+
+// Renderer renders the events a task should have, keyed by role. It returns an
+// error for tasks that cannot be synced.
+type Renderer func(task taskwarrior.Task) (map[string]*calendar.Event, error)
+
+// Lookup returns the index entry recorded under a key for the calendar, like
+// google.CalendarClient's IndexEntry.
+type Lookup func(key string) (index.Entry, bool)
+
+// Classify joins tasks with their index entries and the live events of the
+// calendar. Events whose task is not in tasks are reported as orphans when
+// includeOrphans is set; the caller should only set it when tasks covers every
+// task that may own events.
+func Classify(tasks []taskwarrior.Task, events []*calendar.Event, render Renderer, lookup Lookup, includeOrphans bool) []TaskStatus {
+	live := make(map[string]map[string]*calendar.Event)
+	for _, event := range events {
+		props := event.ExtendedProperties
+		if props == nil || props.Private[util.TaskIDProperty] == "" {
+			continue
+		}
+		uuid := props.Private[util.TaskIDProperty]
+		if live[uuid] == nil {
+			live[uuid] = make(map[string]*calendar.Event)
+		}
+		live[uuid][props.Private[util.RoleProperty]] = event
+	}
+
+	var statuses []TaskStatus
+	seen := make(map[string]bool)
+	for _, task := range tasks {
+		seen[task.UUID] = true
+		statuses = append(statuses, classifyTask(task, live[task.UUID], render, lookup))
+	}
+
+	if includeOrphans {
+		for uuid, byRole := range live {
+			if seen[uuid] {
+				continue
+			}
+			statuses = append(statuses, TaskStatus{
+				UUID:     uuid,
+				State:    Orphan,
+				Detail:   "no matching task",
+				EventIDs: eventIDs(byRole),
+			})
+		}
+	}
+
+	sort.SliceStable(statuses, func(i, j int) bool {
+		if statuses[i].State != statuses[j].State {
+			return statuses[i].State < statuses[j].State
+		}
+		return statuses[i].UUID < statuses[j].UUID
+	})
+	return statuses
+}
+
+func classifyTask(task taskwarrior.Task, byRole map[string]*calendar.Event, render Renderer, lookup Lookup) TaskStatus {
+	st := TaskStatus{
+		UUID:        task.UUID,
+		Description: task.Description,
+		EventIDs:    eventIDs(byRole),
+	}
+
+	targets, err := render(task)
+	if err != nil {
+		st.State = NotSyncable
+		st.Detail = err.Error()
+		if len(byRole) > 0 {
+			st.State = Orphan
+		}
+		return st
+	}
+	if len(targets) == 0 {
+		st.State = NotSyncable
+		st.Detail = "task is " + task.Status + " or blocked"
+		if len(byRole) > 0 {
+			st.State = Orphan
+			st.Detail = "event should have been removed (" + st.Detail + ")"
+		}
+		return st
+	}
+
+	st.State = Synced
+	for _, role := range index.Roles {
+		name := index.RoleName(role)
+		target, wanted := targets[role]
+		existing, exists := byRole[role]
+		entry, indexed := lookup(index.Key(task.UUID, role))
+		switch {
+		case wanted && !exists && indexed:
+			st.State = MissingEvent
+			st.Detail = name + " event " + entry.EventID + " was deleted from the calendar"
+			return st
+		case wanted && !exists:
+			st.State = MissingEvent
+			st.Detail = name + " event missing"
+			return st
+		case !wanted && exists:
+			st.State = Stale
+			st.Detail = name + " event should be removed"
+		case !wanted:
+		case indexed && existing.Id != entry.EventID:
+			st.State = Stale
+			st.Detail = name + " event " + entry.EventID + " is gone, " + existing.Id + " is not indexed"
+		case indexed && entry.ETag != "" && existing.Etag != entry.ETag:
+			st.State = Stale
+			st.Detail = name + " event was edited on the calendar since the last sync"
+		case indexed && entry.Hash != "" && util.EventHash(target) != entry.Hash:
+			st.State = Stale
+			st.Detail = "task changed since its " + name + " event was synced"
+		case indexed && entry.Hash != "":
+			// Both sides are as they were at the last sync
+		default:
+			patch, err := util.EventNeedsUpdate(&task, existing, target)
+			if err != nil || patch != nil {
+				st.State = Stale
+				st.Detail = name + " event needs a patch"
+			}
+		}
+	}
+	return st
+}
+
+func eventIDs(byRole map[string]*calendar.Event) map[string]string {
+	if len(byRole) == 0 {
+		return nil
+	}
+	ids := make(map[string]string, len(byRole))
+	for role, event := range byRole {
+		ids[index.RoleName(role)] = event.Id
+	}
+	return ids
+}
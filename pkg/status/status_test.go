@@ -0,0 +1,121 @@
+package status
+
+import (
+	"testing"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"github.com/harrisonrobin/taska/pkg/util"
+	"google.golang.org/api/calendar/v3"
+)
+
+func noEntries(string) (index.Entry, bool) { return index.Entry{}, false }
+
+func TestClassify(t *testing.T) {
+	t.Setenv("HOME", t.TempDir()) // event conversion loads the colour cache
+	at := &taskwarrior.CustomTime{Time: time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)}
+	tasks := []taskwarrior.Task{
+		{UUID: "synced", Description: "A", Status: "pending", Scheduled: at},
+		{UUID: "stale", Description: "B", Status: "pending", Scheduled: at},
+		{UUID: "missing", Description: "C", Status: "pending", Scheduled: at},
+		{UUID: "nodates", Description: "D", Status: "pending"},
+		{UUID: "waiting", Description: "E", Status: "waiting", Scheduled: at},
+	}
+
+	render := func(task taskwarrior.Task) (map[string]*calendar.Event, error) {
+		if !task.WantsEvent() {
+			return map[string]*calendar.Event{}, nil
+		}
+		event, err := util.ConvertTaskToCalendarEvent(&task, nil)
+		if err != nil {
+			return nil, err
+		}
+		return map[string]*calendar.Event{"": event}, nil
+	}
+
+	liveEvent := func(uuid, summary string) *calendar.Event {
+		task := taskwarrior.Task{UUID: uuid, Description: summary, Status: "pending", Scheduled: at}
+		event, err := util.ConvertTaskToCalendarEvent(&task, nil)
+		if err != nil {
+			t.Fatalf("ConvertTaskToCalendarEvent failed: %v", err)
+		}
+		event.Id = "evt-" + uuid
+		return event
+	}
+	events := []*calendar.Event{
+		liveEvent("synced", "A"),
+		liveEvent("stale", "old title"),
+		liveEvent("waiting", "E"),
+		liveEvent("gone", "F"),
+	}
+
+	want := map[string]string{
+		"synced":  Synced,
+		"stale":   Stale,
+		"missing": MissingEvent,
+		"nodates": NotSyncable,
+		"waiting": Orphan,
+		"gone":    Orphan,
+	}
+
+	got := Classify(tasks, events, render, noEntries, true)
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d statuses, got %d: %+v", len(want), len(got), got)
+	}
+	for _, st := range got {
+		if st.State != want[st.UUID] {
+			t.Errorf("%s: expected %s, got %s (%s)", st.UUID, want[st.UUID], st.State, st.Detail)
+		}
+	}
+
+	withoutOrphans := Classify(tasks, events, render, noEntries, false)
+	for _, st := range withoutOrphans {
+		if st.UUID == "gone" {
+			t.Errorf("Expected orphan events to be skipped, got %+v", st)
+		}
+	}
+}
+
+func TestClassifyIndexed(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	at := &taskwarrior.CustomTime{Time: time.Date(2030, 1, 1, 9, 0, 0, 0, time.UTC)}
+	task := taskwarrior.Task{UUID: "u1", Description: "Write report", Status: "pending", Scheduled: at}
+	rendered, err := util.ConvertTaskToCalendarEvent(&task, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	render := func(taskwarrior.Task) (map[string]*calendar.Event, error) {
+		return map[string]*calendar.Event{"": rendered}, nil
+	}
+	live := func(id, etag string) []*calendar.Event {
+		event := *rendered
+		event.Id, event.Etag = id, etag
+		return []*calendar.Event{&event}
+	}
+	synced := index.Entry{EventID: "ev1", ETag: `"1"`, Hash: util.EventHash(rendered)}
+
+	tests := []struct {
+		name   string
+		entry  index.Entry
+		events []*calendar.Event
+		want   string
+		detail string
+	}{
+		{"as synced", synced, live("ev1", `"1"`), Synced, ""},
+		{"edited on the calendar", synced, live("ev1", `"2"`), Stale, "primary event was edited on the calendar since the last sync"},
+		{"task changed", index.Entry{EventID: "ev1", ETag: `"1"`, Hash: "old"}, live("ev1", `"1"`), Stale, "task changed since its primary event was synced"},
+		{"deleted on the calendar", synced, nil, MissingEvent, "primary event ev1 was deleted from the calendar"},
+		{"another event", synced, live("ev2", `"1"`), Stale, "primary event ev1 is gone, ev2 is not indexed"},
+		{"no hash recorded", index.Entry{EventID: "ev1", ETag: `"1"`}, live("ev1", `"1"`), Synced, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lookup := func(key string) (index.Entry, bool) { return tt.entry, key == "u1" }
+			got := Classify([]taskwarrior.Task{task}, tt.events, render, lookup, false)
+			if len(got) != 1 || got[0].State != tt.want || got[0].Detail != tt.detail {
+				t.Errorf("got %+v, want %s (%s)", got, tt.want, tt.detail)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+// Package systemd renders the systemd user units that run `taska sync` on a
+// timer or `taska daemon`, and installs them into the user's unit directory.
+// The timer reconciles the calendars with Taskwarrior and runs the overdue
+// sweep, catching up on changes no hook synced; the daemon only sweeps, as
+// the moments tasks become overdue pass.
+package systemd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/paths"
+)
+
+// Options describe the units to render.
+type Options struct {
+	// Executable is the absolute path of the taska binary.
+	Executable string
+	// Profile is the profile the units run for; the default profile's units
+	// carry no suffix.
+	Profile string
+	// Interval is how often the timer syncs, and the longest time between two
+	// ticks of the daemon.
+	Interval time.Duration
+}
+
+// Unit is a rendered unit file.
+type Unit struct {
+	Name    string
+	Content string
+}
+
+// Names returns the sync service, timer and daemon service names of a
+// profile.
+func Names(profile string) (service, timer, daemon string) {
+	base := "taska-sync"
+	daemonBase := "taska-daemon"
+	if profile != "" && profile != paths.DefaultProfile {
+		base += "-" + profile
+		daemonBase += "-" + profile
+	}
+	return base + ".service", base + ".timer", daemonBase + ".service"
+}
+
+var templates = template.Must(template.New("units").Parse(`
+{{- define "sync.service" -}}
+[Unit]
+Description=taska: reconcile the calendars with Taskwarrior and mark overdue tasks{{.ForProfile}}
+Documentation=https://github.com/harrisonrobin/taska
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+Type=oneshot
+ExecStart={{.Command}} sync
+{{end}}
+{{- define "sync.timer" -}}
+[Unit]
+Description=taska: run {{.Service}} every {{.Interval}}
+Documentation=https://github.com/harrisonrobin/taska
+
+[Timer]
+OnBootSec=1min
+OnUnitActiveSec={{.Interval}}
+Unit={{.Service}}
+
+[Install]
+WantedBy=timers.target
+{{end}}
+{{- define "daemon.service" -}}
+[Unit]
+Description=taska: refresh the events of tasks as their scheduled or due date passes{{.ForProfile}}
+Documentation=https://github.com/harrisonrobin/taska
+After=network-online.target
+Wants=network-online.target
+
+[Service]
+ExecStart={{.Command}} daemon --interval {{.DaemonInterval}}
+Restart=on-failure
+RestartSec=30s
+
+[Install]
+WantedBy=default.target
+{{end}}`))
+
+// Render returns the sync service, the timer and the daemon service.
+func Render(opts Options) ([]Unit, error) {
+	if !filepath.IsAbs(opts.Executable) {
+		return nil, fmt.Errorf("executable %q is not an absolute path", opts.Executable)
+	}
+	if opts.Interval < time.Second {
+		return nil, fmt.Errorf("interval %s is shorter than a second", opts.Interval)
+	}
+	service, timer, daemon := Names(opts.Profile)
+	command := execArg(opts.Executable)
+	forProfile := ""
+	if opts.Profile != "" && opts.Profile != paths.DefaultProfile {
+		command += " --profile " + execArg(opts.Profile)
+		forProfile = " (profile " + opts.Profile + ")"
+	}
+	data := map[string]string{
+		"Command":        command,
+		"ForProfile":     forProfile,
+		"Service":        service,
+		"Interval":       timeSpan(opts.Interval),
+		"DaemonInterval": opts.Interval.String(),
+	}
+
+	var units []Unit
+	for _, u := range []struct{ name, template string }{
+		{service, "sync.service"}, {timer, "sync.timer"}, {daemon, "daemon.service"},
+	} {
+		var buf bytes.Buffer
+		if err := templates.ExecuteTemplate(&buf, u.template, data); err != nil {
+			return nil, err
+		}
+		units = append(units, Unit{Name: u.name, Content: buf.String()})
+	}
+	return units, nil
+}
+
+// execArg quotes an ExecStart argument for systemd: specifiers are escaped,
+// and arguments with spaces or quotes are double-quoted.
+func execArg(s string) string {
+	s = strings.ReplaceAll(s, "%", "%%")
+	if !strings.ContainsAny(s, " \t\"'\\") {
+		return s
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+// timeSpan formats a duration the way systemd time spans are written, in its
+// largest whole unit.
+func timeSpan(d time.Duration) string {
+	switch {
+	case d%time.Hour == 0:
+		return fmt.Sprintf("%dh", d/time.Hour)
+	case d%time.Minute == 0:
+		return fmt.Sprintf("%dmin", d/time.Minute)
+	default:
+		return fmt.Sprintf("%ds", d/time.Second)
+	}
+}
+
+// UserDir returns $XDG_CONFIG_HOME/systemd/user, or ~/.config/systemd/user.
+func UserDir() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, "systemd", "user"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "systemd", "user"), nil
+}
+
+// Write writes the units into dir.
+func Write(dir string, units []Unit) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for _, u := range units {
+		if err := os.WriteFile(filepath.Join(dir, u.Name), []byte(u.Content), 0o644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes the named units from dir. Units that are not there are
+// skipped.
+func Remove(dir string, names ...string) error {
+	for _, name := range names {
+		if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// Systemctl runs `systemctl --user` with args and returns its trimmed output.
+func Systemctl(args ...string) (string, error) {
+	out, err := exec.Command("systemctl", append([]string{"--user"}, args...)...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
@@ -0,0 +1,84 @@
+package systemd
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files")
+
+func TestRenderGolden(t *testing.T) {
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{"default", Options{Executable: "/usr/local/bin/taska", Profile: "default", Interval: 15 * time.Minute}},
+		{"profile", Options{Executable: "/home/me/my bin/taska", Profile: "work", Interval: 90 * time.Second}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			units, err := Render(tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(units) != 3 {
+				t.Fatalf("expected 3 units, got %d", len(units))
+			}
+			for _, u := range units {
+				golden := filepath.Join("testdata", tt.name, u.Name)
+				if *update {
+					if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+						t.Fatal(err)
+					}
+					if err := os.WriteFile(golden, []byte(u.Content), 0o644); err != nil {
+						t.Fatal(err)
+					}
+				}
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("%v (run go test -update to create it)", err)
+				}
+				if u.Content != string(want) {
+					t.Errorf("%s differs from %s:\n%s", u.Name, golden, u.Content)
+				}
+			}
+		})
+	}
+}
+
+func TestRenderRejects(t *testing.T) {
+	for name, opts := range map[string]Options{
+		"relative executable": {Executable: "taska", Interval: time.Minute},
+		"short interval":      {Executable: "/usr/bin/taska", Interval: time.Millisecond},
+	} {
+		if _, err := Render(opts); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestWriteAndRemove(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "systemd", "user")
+	units, err := Render(Options{Executable: "/usr/bin/taska", Interval: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(dir, units); err != nil {
+		t.Fatal(err)
+	}
+	service, timer, daemon := Names("")
+	for _, name := range []string{service, timer, daemon} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Error(err)
+		}
+	}
+	if err := Remove(dir, service, timer, daemon, "missing.service"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+		t.Errorf("left behind %v", entries)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os/exec"
+	"strings"
 )
 
 type Client struct{}
@@ -33,6 +34,59 @@ func (c *Client) GetTasks(filter []string) ([]Task, error) {
 	return tasks, nil
 }
 
+// Version returns the version reported by `task --version`.
+func (c *Client) Version() (string, error) {
+	output, err := exec.Command("task", "--version").Output()
+	if err != nil {
+		return "", fmt.Errorf("taskwarrior command failed: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Get returns the value of a DOM reference such as "rc.uda.est.type" via
+// `task _get`. Unset values are returned as "".
+func (c *Client) Get(name string) (string, error) {
+	return c.get("rc.hooks=0", "_get", name)
+}
+
+// GetConfigured is Get without the rc.hooks=0 override, for reading settings
+// the override would hide, such as rc.hooks itself.
+func (c *Client) GetConfigured(name string) (string, error) {
+	return c.get("_get", name)
+}
+
+func (c *Client) get(args ...string) (string, error) {
+	output, err := exec.Command("task", args...).Output()
+	if err != nil {
+		return "", fmt.Errorf("taskwarrior command failed: %w", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// Modify applies modifications such as "scheduled:20250102T100000Z" to a
+// task. Hooks run unless hooks is false, in which case taska itself does not
+// see the change.
+func (c *Client) Modify(uuid string, hooks bool, mods ...string) error {
+	return c.run(hooks, append([]string{uuid, "modify"}, mods...)...)
+}
+
+// Annotate adds an annotation to a task without running hooks.
+func (c *Client) Annotate(uuid, text string) error {
+	return c.run(false, uuid, "annotate", text)
+}
+
+func (c *Client) run(hooks bool, args ...string) error {
+	rc := []string{"rc.confirmation=off", "rc.recurrence.confirmation=no", "rc.verbose=nothing"}
+	if !hooks {
+		rc = append(rc, "rc.hooks=0")
+	}
+	output, err := exec.Command("task", append(rc, args...)...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("taskwarrior command failed: %w: %s", err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
 // ParseTask parses a single task JSON from an io.Reader
 func (c *Client) ParseTask(r io.Reader) (Task, error) {
 	var task Task
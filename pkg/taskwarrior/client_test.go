@@ -1,6 +1,8 @@
 package taskwarrior
 
 import (
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 	"time"
@@ -45,3 +47,21 @@ func TestParseTask(t *testing.T) {
 		t.Errorf("Expected Due %v, got %v", expectedDue, task.Due.Time)
 	}
 }
+
+func TestGetConfiguredKeepsHooks(t *testing.T) {
+	// A fake task binary that reports rc.hooks as the override left it
+	dir := t.TempDir()
+	script := "#!/bin/sh\nhooks=on\nfor a; do [ \"$a\" = rc.hooks=0 ] && hooks=0; done\necho $hooks\n"
+	if err := os.WriteFile(filepath.Join(dir, "task"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir)
+
+	client := NewClient()
+	if got, err := client.Get("rc.hooks"); err != nil || got != "0" {
+		t.Errorf("Get = %q, %v; want the override", got, err)
+	}
+	if got, err := client.GetConfigured("rc.hooks"); err != nil || got != "on" {
+		t.Errorf("GetConfigured = %q, %v; want the configured value", got, err)
+	}
+}
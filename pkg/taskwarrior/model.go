@@ -50,7 +50,10 @@ type Task struct {
 	Scheduled   *CustomTime `json:"scheduled,omitempty"`
 	Status      string      `json:"status"`
 	Project     string      `json:"project,omitempty"`
+	Priority    string      `json:"priority,omitempty"`
 	Tags        []string    `json:"tags,omitempty"`
+	// Urgency is only in `task export`; hooks receive tasks without it.
+	Urgency     float64 `json:"urgency,omitempty"`
 	Annotations []struct {
 		Description string      `json:"description"`
 		Entry       *CustomTime `json:"entry"`
@@ -58,6 +61,8 @@ type Task struct {
 	// Time Tracking & Accounting
 	Start *CustomTime `json:"start,omitempty"`
 	End   *CustomTime `json:"end,omitempty"`
+	// Modified is when the task last changed.
+	Modified *CustomTime `json:"modified,omitempty"`
 	// UDA fields are often flat in JSON export, but let's check input.
 	// Taskwarrior exports UDAs as top-level fields like "est" and "act" if configured.
 	// We'll use a specific struct or map for them?
@@ -67,4 +72,109 @@ type Task struct {
 	Act string `json:"act,omitempty"` // Duration string like "30m" -- Timewarrior format might differ?
 	// Note: Timewarrior usually doesn't inject INTO the task JSON unless 'hook' does it or it's stored in UDA.
 	// User implies it IS in UDA.
+	// Remind overrides the configured reminders, e.g. "15m" or "popup:10m,email:1h".
+	// "none" removes all reminders from the event.
+	Remind string `json:"remind,omitempty"`
+}
+
+// FormatTime formats t the way Taskwarrior exports dates, which it also
+// accepts in modifications.
+func FormatTime(t time.Time) string {
+	return t.UTC().Format(taskwarriorTimeLayout)
+}
+
+// WantsEvent reports whether a task should be on the calendar at all. Waiting,
+// deleted and BLOCKED tasks have their events removed.
+func (t *Task) WantsEvent() bool {
+	if t.Status == WAITING || t.Status == DELETED {
+		return false
+	}
+	for _, tag := range t.Tags {
+		if tag == "BLOCKED" {
+			return false
+		}
+	}
+	return true
+}
+
+// Urgency coefficients of Taskwarrior's defaults.
+const (
+	urgencyNext        = 15.0
+	urgencyDue         = 12.0
+	urgencyBlocked     = -5.0
+	urgencyScheduled   = 5.0
+	urgencyActive      = 4.0
+	urgencyWaiting     = -3.0
+	urgencyProject     = 1.0
+	urgencyTags        = 1.0
+	urgencyAnnotations = 1.0
+)
+
+var urgencyPriority = map[string]float64{"H": 6.0, "M": 3.9, "L": 1.8}
+
+// UrgencyAt returns the task's urgency: the exported one, or else an
+// estimate from Taskwarrior's default coefficients, leaving out age and
+// dependencies.
+func (t *Task) UrgencyAt(now time.Time) float64 {
+	if t.Urgency != 0 {
+		return t.Urgency
+	}
+	if t.Status == COMPLETED || t.Status == DELETED {
+		return 0
+	}
+	u := urgencyPriority[strings.ToUpper(t.Priority)]
+	if t.Project != "" {
+		u += urgencyProject
+	}
+	if t.Start != nil && !t.Start.IsZero() {
+		u += urgencyActive
+	}
+	if t.Scheduled != nil && !t.Scheduled.IsZero() && t.Scheduled.Before(now) {
+		u += urgencyScheduled
+	}
+	if t.Status == WAITING {
+		u += urgencyWaiting
+	}
+	if t.Due != nil && !t.Due.IsZero() {
+		u += urgencyDue * dueFactor(now.Sub(t.Due.Time))
+	}
+	u += urgencyTags * countFactor(len(t.Tags))
+	u += urgencyAnnotations * countFactor(len(t.Annotations))
+	for _, tag := range t.Tags {
+		switch tag {
+		case "next":
+			u += urgencyNext
+		case "BLOCKED":
+			u += urgencyBlocked
+		}
+	}
+	return u
+}
+
+// dueFactor grows from 0.2 two weeks before the due date to 1 a week after.
+func dueFactor(overdue time.Duration) float64 {
+	days := overdue.Hours() / 24
+	switch {
+	case days >= 7:
+		return 1
+	case days >= -14:
+		return (days+14)*0.8/21 + 0.2
+	default:
+		return 0.2
+	}
+}
+
+// countFactor weighs tags and annotations: 0.8 for one, 0.9 for two and 1
+// for more.
+func countFactor(n int) float64 {
+	switch n {
+	case 0:
+		return 0
+	case 1:
+		return 0.8
+	case 2:
+		return 0.9
+	default:
+		return 1
+	}
 }
@@ -0,0 +1,30 @@
+package taskwarrior
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func TestUrgencyAt(t *testing.T) {
+	now := time.Date(2026, 3, 2, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		task Task
+		want float64
+	}{
+		{"exported", Task{Urgency: 7.25, Priority: "H"}, 7.25},
+		{"priority and project", Task{Status: PENDING, Priority: "H", Project: "Work"}, 7},
+		{"due in two weeks", Task{Status: PENDING, Due: &CustomTime{Time: now.Add(14 * 24 * time.Hour)}}, 2.4},
+		{"a week overdue", Task{Status: PENDING, Due: &CustomTime{Time: now.Add(-7 * 24 * time.Hour)}}, 12},
+		{"started with one tag", Task{Status: PENDING, Start: &CustomTime{Time: now}, Tags: []string{"next"}}, 4 + 0.8 + 15},
+		{"completed", Task{Status: COMPLETED, Priority: "H"}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.task.UrgencyAt(now); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,20 @@
+package util
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// EventHash returns a stable hash of a rendered event. A task that renders to
+// the same hash as at its last sync has nothing to send to the calendar. It
+// returns "" if the event cannot be encoded.
+func EventHash(event *calendar.Event) string {
+	b, err := event.MarshalJSON()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:16])
+}
@@ -0,0 +1,51 @@
+package util
+
+import (
+	"github.com/harrisonrobin/taska/pkg/config"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	busySummary = "Busy"
+
+	visibilityDefault   = "default"
+	visibilityPrivate   = "private"
+	transparencyOpaque  = "opaque"
+	transparencyVisible = "transparent"
+)
+
+// ApplyPrivacy redacts a rendered event according to a privacy level.
+// "title-only" drops the description; "busy" also replaces the summary and
+// marks the event private. The task UUID is kept only in the private extended
+// property, which is not shown to other readers of the calendar.
+func ApplyPrivacy(event *calendar.Event, level string, busyTransparency string) {
+	switch level {
+	case config.PrivacyTitleOnly:
+		event.Description = ""
+	case config.PrivacyBusy:
+		event.Summary = busySummary
+		event.Description = ""
+		event.Visibility = visibilityPrivate
+		if busyTransparency == transparencyVisible {
+			event.Transparency = transparencyVisible
+		} else {
+			event.Transparency = transparencyOpaque
+		}
+	}
+}
+
+// normalizeVisibility maps the API's implicit default to its explicit value.
+func normalizeVisibility(v string) string {
+	if v == "" {
+		return visibilityDefault
+	}
+	return v
+}
+
+// normalizeTransparency maps the API's implicit default to its explicit value.
+func normalizeTransparency(t string) string {
+	if t == "" {
+		return transparencyOpaque
+	}
+	return t
+}
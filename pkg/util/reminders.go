@@ -0,0 +1,165 @@
+package util
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"google.golang.org/api/calendar/v3"
+)
+
+const (
+	ReminderPopup = "popup"
+	ReminderEmail = "email"
+
+	// maxReminderOverrides is the number of reminder overrides Google accepts per event.
+	maxReminderOverrides = 5
+	// maxReminderMinutes is the furthest ahead (4 weeks) Google allows a reminder.
+	maxReminderMinutes = 40320
+)
+
+// ParseReminders parses the value of the 'remind' UDA. It is a comma separated
+// list of durations, each optionally prefixed with a method, e.g. "15m" or
+// "popup:10m,email:1d". Durations may be Go durations, a number of days ("2d")
+// or ISO 8601 ("PT15M"). The value "none" yields an empty, non-nil list.
+func ParseReminders(s string) ([]*calendar.EventReminder, error) {
+	s = strings.TrimSpace(s)
+	if strings.EqualFold(s, "none") {
+		return []*calendar.EventReminder{}, nil
+	}
+
+	var reminders []*calendar.EventReminder
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		method := ReminderPopup
+		if m, rest, ok := strings.Cut(item, ":"); ok {
+			method = strings.ToLower(m)
+			item = rest
+		}
+		d, err := parseReminderDuration(item)
+		if err != nil {
+			return nil, err
+		}
+		reminder, err := newReminder(method, d)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, reminder)
+	}
+	if len(reminders) == 0 {
+		return nil, fmt.Errorf("no reminders in '%s'", s)
+	}
+	return reminders, nil
+}
+
+func parseReminderDuration(s string) (time.Duration, error) {
+	if strings.HasPrefix(s, "P") {
+		return ParseDuration(s)
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid reminder duration '%s': %w", s, err)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid reminder duration '%s': %w", s, err)
+	}
+	return d, nil
+}
+
+func newReminder(method string, before time.Duration) (*calendar.EventReminder, error) {
+	if method == "" {
+		method = ReminderPopup
+	}
+	if method != ReminderPopup && method != ReminderEmail {
+		return nil, fmt.Errorf("unknown reminder method '%s' (want popup or email)", method)
+	}
+	minutes := int64(before / time.Minute)
+	if minutes < 0 || minutes > maxReminderMinutes {
+		return nil, fmt.Errorf("reminder %s is out of range (0 to 4 weeks)", before)
+	}
+	return &calendar.EventReminder{
+		Method:          method,
+		Minutes:         minutes,
+		ForceSendFields: []string{"Minutes"}, // 0 minutes means "at start" and must be sent
+	}, nil
+}
+
+// TaskReminders resolves the reminders for a task. The 'remind' UDA wins over
+// the config rules. It returns nil when neither applies so that the calendar's
+// default reminders are left alone.
+func TaskReminders(task *taskwarrior.Task, cfg *config.Config) (*calendar.EventReminders, error) {
+	var overrides []*calendar.EventReminder
+	if task.Remind != "" {
+		parsed, err := ParseReminders(task.Remind)
+		if err != nil {
+			return nil, err
+		}
+		overrides = parsed
+	} else {
+		rules := cfg.ReminderRules(task)
+		if len(rules) == 0 {
+			return nil, nil
+		}
+		for _, rule := range rules {
+			reminder, err := newReminder(strings.ToLower(rule.Method), rule.Before.Duration)
+			if err != nil {
+				return nil, err
+			}
+			overrides = append(overrides, reminder)
+		}
+	}
+
+	sortReminders(overrides)
+	if len(overrides) > maxReminderOverrides {
+		overrides = overrides[:maxReminderOverrides]
+	}
+	return &calendar.EventReminders{
+		UseDefault: false,
+		Overrides:  overrides,
+		// Both must be sent explicitly, or a patch would keep the old values.
+		ForceSendFields: []string{"UseDefault", "Overrides"},
+	}, nil
+}
+
+// RemindersEqual reports whether two reminder settings are equivalent.
+// A nil value is treated as "use the calendar defaults".
+func RemindersEqual(a, b *calendar.EventReminders) bool {
+	aDefault := a == nil || a.UseDefault
+	bDefault := b == nil || b.UseDefault
+	if aDefault || bDefault {
+		return aDefault == bDefault
+	}
+	if len(a.Overrides) != len(b.Overrides) {
+		return false
+	}
+	aSorted := append([]*calendar.EventReminder(nil), a.Overrides...)
+	bSorted := append([]*calendar.EventReminder(nil), b.Overrides...)
+	sortReminders(aSorted)
+	sortReminders(bSorted)
+	for i := range aSorted {
+		if aSorted[i].Method != bSorted[i].Method || aSorted[i].Minutes != bSorted[i].Minutes {
+			return false
+		}
+	}
+	return true
+}
+
+func sortReminders(reminders []*calendar.EventReminder) {
+	sort.SliceStable(reminders, func(i, j int) bool {
+		if reminders[i].Minutes != reminders[j].Minutes {
+			return reminders[i].Minutes < reminders[j].Minutes
+		}
+		return reminders[i].Method < reminders[j].Method
+	})
+}
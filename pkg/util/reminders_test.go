@@ -0,0 +1,88 @@
+package util
+
+import (
+	"testing"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"google.golang.org/api/calendar/v3"
+)
+
+func TestParseReminders(t *testing.T) {
+	reminders, err := ParseReminders("email:1d, 15m,popup:PT5M")
+	if err != nil {
+		t.Fatalf("ParseReminders failed: %v", err)
+	}
+	want := []calendar.EventReminder{
+		{Method: "email", Minutes: 1440},
+		{Method: "popup", Minutes: 15},
+		{Method: "popup", Minutes: 5},
+	}
+	if len(reminders) != len(want) {
+		t.Fatalf("Expected %d reminders, got %d", len(want), len(reminders))
+	}
+	for i, w := range want {
+		if reminders[i].Method != w.Method || reminders[i].Minutes != w.Minutes {
+			t.Errorf("Reminder %d: expected %s/%d, got %s/%d", i, w.Method, w.Minutes, reminders[i].Method, reminders[i].Minutes)
+		}
+	}
+
+	if none, err := ParseReminders("none"); err != nil || none == nil || len(none) != 0 {
+		t.Errorf("Expected empty reminder list for 'none', got %v (err: %v)", none, err)
+	}
+	if _, err := ParseReminders("sms:10m"); err == nil {
+		t.Error("Expected error for unknown method")
+	}
+}
+
+func TestTaskRemindersOverrideAndPatch(t *testing.T) {
+	cfg := &config.Config{
+		Reminders: []config.ReminderRule{
+			{Before: config.Duration{Duration: 10 * time.Minute}},
+			{Selector: config.Selector{Project: "Work"}, Method: "email", Before: config.Duration{Duration: time.Hour}},
+		},
+	}
+	task := &taskwarrior.Task{
+		UUID:      "12345678-1234-1234-1234-123456789012",
+		Status:    "pending",
+		Project:   "Work",
+		Scheduled: &taskwarrior.CustomTime{Time: time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)},
+	}
+
+	reminders, err := TaskReminders(task, cfg)
+	if err != nil {
+		t.Fatalf("TaskReminders failed: %v", err)
+	}
+	if reminders.UseDefault || len(reminders.Overrides) != 1 || reminders.Overrides[0].Method != "email" {
+		t.Errorf("Expected the project rule to replace the global one, got %+v", reminders.Overrides)
+	}
+
+	task.Remind = "popup:15m"
+	target, err := ConvertTaskToCalendarEvent(task, cfg)
+	if err != nil {
+		t.Fatalf("ConvertTaskToCalendarEvent failed: %v", err)
+	}
+	if len(target.Reminders.Overrides) != 1 || target.Reminders.Overrides[0].Minutes != 15 {
+		t.Fatalf("Expected the UDA to override the rules, got %+v", target.Reminders.Overrides)
+	}
+
+	existing := *target
+	existing.Reminders = &calendar.EventReminders{UseDefault: true}
+	patch, err := EventNeedsUpdate(task, &existing, target)
+	if err != nil {
+		t.Fatalf("EventNeedsUpdate failed: %v", err)
+	}
+	if patch == nil || patch.Reminders == nil {
+		t.Fatal("Expected a reminder patch")
+	}
+
+	existing.Reminders = target.Reminders
+	patch, err = EventNeedsUpdate(task, &existing, target)
+	if err != nil {
+		t.Fatalf("EventNeedsUpdate failed: %v", err)
+	}
+	if patch != nil {
+		t.Errorf("Expected no patch for identical reminders, got %+v", patch)
+	}
+}
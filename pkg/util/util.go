@@ -2,13 +2,16 @@ package util
 
 import (
 	"fmt"
-	"log"
+	"log/slog"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/harrisonrobin/taska/pkg/colors"
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/logging"
 	"github.com/harrisonrobin/taska/pkg/taskwarrior"
 	"google.golang.org/api/calendar/v3"
 )
@@ -19,6 +22,16 @@ const (
 	NEEDS_UPDATE_DUE         = "due"
 )
 
+const (
+	// TaskIDProperty is the private extended property holding the task UUID.
+	TaskIDProperty = "taskwarrior_id"
+	// RoleProperty is the private extended property holding the event's role
+	// (see index.Roles). It is absent on a task's primary event.
+	RoleProperty = "taska_role"
+
+	defaultDeadlineColorID = "11" // Tomato
+)
+
 // ParseDuration parses ISO 8601 duration format (PT1H30M) from Taskwarrior JSON export
 func ParseDuration(s string) (time.Duration, error) {
 	if s == "" {
@@ -77,34 +90,51 @@ func EventNeedsUpdate(task *taskwarrior.Task, existingEvent *calendar.Event, tar
 	// 2. Check for Description (Annotations/Notes) Mismatch
 	if existingEvent.Description != targetEvent.Description {
 		patch.Description = targetEvent.Description
+		if targetEvent.Description == "" {
+			// Redacted events clear the description, which a patch omits unless forced
+			patch.ForceSendFields = append(patch.ForceSendFields, "Description")
+		}
 		needsUpdate = true
 	}
 
 	// 3. Check for Color Mismatch
 	if existingEvent.ColorId != targetEvent.ColorId {
 		patch.ColorId = targetEvent.ColorId
+		if targetEvent.ColorId == "" {
+			// Back to the calendar's colour, which a patch omits unless nulled
+			patch.NullFields = append(patch.NullFields, "ColorId")
+		}
 		needsUpdate = true
 	}
 
-	// 4. Check for Time/Due Date Mismatch
-	existingStartTime, err := time.Parse(time.RFC3339, existingEvent.Start.DateTime)
-	if err != nil {
-		return nil, err
+	// 4. Check for Reminder Mismatch. A nil target means taska doesn't manage
+	// the reminders of this event, so whatever is on the calendar is kept.
+	if targetEvent.Reminders != nil && !RemindersEqual(existingEvent.Reminders, targetEvent.Reminders) {
+		patch.Reminders = targetEvent.Reminders
+		needsUpdate = true
 	}
-	targetStartTime, err := time.Parse(time.RFC3339, targetEvent.Start.DateTime)
-	if err != nil {
-		return nil, err
+
+	// 5. Check for Visibility/Transparency Mismatch (privacy levels)
+	if normalizeVisibility(existingEvent.Visibility) != normalizeVisibility(targetEvent.Visibility) {
+		patch.Visibility = normalizeVisibility(targetEvent.Visibility)
+		needsUpdate = true
+	}
+	if normalizeTransparency(existingEvent.Transparency) != normalizeTransparency(targetEvent.Transparency) {
+		patch.Transparency = normalizeTransparency(targetEvent.Transparency)
+		needsUpdate = true
 	}
-	existingEndTime, err := time.Parse(time.RFC3339, existingEvent.End.DateTime)
+
+	// 6. Check for Time/Due Date Mismatch
+	startEqual, err := eventTimesEqual(existingEvent.Start, targetEvent.Start)
 	if err != nil {
 		return nil, err
 	}
-	targetEndTime, err := time.Parse(time.RFC3339, targetEvent.End.DateTime)
+	endEqual, err := eventTimesEqual(existingEvent.End, targetEvent.End)
 	if err != nil {
 		return nil, err
 	}
 
-	if !existingStartTime.Equal(targetStartTime) || !existingEndTime.Equal(targetEndTime) {
+	if !startEqual || !endEqual {
 		patch.Start = targetEvent.Start
 		patch.End = targetEvent.End
 		needsUpdate = true
@@ -116,7 +146,30 @@ func EventNeedsUpdate(task *taskwarrior.Task, existingEvent *calendar.Event, tar
 	return nil, nil
 }
 
-func ConvertTaskToCalendarEvent(task *taskwarrior.Task) (*calendar.Event, error) {
+// eventTimesEqual compares two event times, which are either all-day dates or
+// RFC 3339 timestamps.
+func eventTimesEqual(a, b *calendar.EventDateTime) (bool, error) {
+	if a == nil || b == nil {
+		return a == b, nil
+	}
+	if a.Date != "" || b.Date != "" {
+		return a.Date == b.Date && a.DateTime == "" && b.DateTime == "", nil
+	}
+	aTime, err := time.Parse(time.RFC3339, a.DateTime)
+	if err != nil {
+		return false, err
+	}
+	bTime, err := time.Parse(time.RFC3339, b.DateTime)
+	if err != nil {
+		return false, err
+	}
+	return aTime.Equal(bTime), nil
+}
+
+// ConvertTaskToCalendarEvent renders a task as a calendar event. cfg supplies the
+// duration policy and reminder rules and may be nil, in which case the built-in
+// defaults are used.
+func ConvertTaskToCalendarEvent(task *taskwarrior.Task, cfg *config.Config) (*calendar.Event, error) {
 	if task == nil {
 		return nil, fmt.Errorf("could not convert nil Task")
 	}
@@ -144,22 +197,13 @@ func ConvertTaskToCalendarEvent(task *taskwarrior.Task) (*calendar.Event, error)
 	}
 
 	// 2. Color Logic
-	// We need to instantiate ColorCache. Since this function is pure utility, we might need to pass cache or instantiate it here.
-	// Instantiating here for now as user requested refactor. Best practice would be dependency injection but let's keep it simple.
-	colorID := "1" // Default lavender
-	cache, err := colors.NewColorCache()
-	if err == nil {
-		isActive := task.Status == "pending" || task.Status == "waiting" // broad definition
-		colorID = cache.GetColorID(task.Project, isActive)
-	} else {
-		log.Printf("Warning: could not load color cache: %v", err)
-	}
+	colorID := colors.Pick(task, cfg)
 
 	// 3. Time-Shift Logic
 	var start, end time.Time
 
-	// Default duration if nothing matches
-	defaultDuration := 30 * time.Minute
+	// Default duration, limits and slot rounding come from the config rules
+	policy := cfg.DurationPolicy(task)
 
 	// Logic:
 	// If Done: End = Now (or task.End), Start = End - Estimate/Actual/Duration
@@ -174,43 +218,46 @@ func ConvertTaskToCalendarEvent(task *taskwarrior.Task) (*calendar.Event, error)
 			end = time.Now()
 		}
 
-		duration := defaultDuration
+		duration := policy.Default
 		if act > 0 {
 			duration = act
 		} else if est > 0 {
 			duration = est
 		}
-		start = end.Add(-duration)
-	} else if task.Start != nil && !task.Start.IsZero() {
-		// Started
-		start = task.Start.Time
-		if est > 0 {
-			end = start.Add(est)
-		} else {
-			end = start.Add(defaultDuration)
-		}
-	} else if task.Scheduled != nil && !task.Scheduled.IsZero() {
-		// Scheduled
-		start = task.Scheduled.Time
-		if est > 0 {
-			end = start.Add(est)
+		start = end.Add(-policy.Clamp(duration))
+	} else {
+		if task.Start != nil && !task.Start.IsZero() {
+			// Started
+			start = task.Start.Time
+		} else if task.Scheduled != nil && !task.Scheduled.IsZero() {
+			// Scheduled
+			start = task.Scheduled.Time
+		} else if task.Due != nil && !task.Due.IsZero() {
+			// Due
+			start = task.Due.Time
 		} else {
-			end = start.Add(defaultDuration)
+			// ROI: If no dates, we can't sync it easily.
+			return nil, fmt.Errorf("task has no date usage (due, start, scheduled, or end): %s", task.UUID)
 		}
-	} else if task.Due != nil && !task.Due.IsZero() {
-		// Due
-		start = task.Due.Time
+
+		duration := policy.Default
 		if est > 0 {
-			end = start.Add(est)
-		} else {
-			end = start.Add(defaultDuration)
+			duration = est
 		}
-	} else {
-		// ROI: If no dates, we can't sync it easily.
-		return nil, fmt.Errorf("task has no date usage (due, start, scheduled, or end): %s", task.UUID)
+		end = start.Add(policy.Clamp(duration))
 	}
+	// Slots are counted on the user's clock, task dates are in UTC
+	snappedStart, snappedEnd := policy.Snap(start.In(time.Local), end.In(time.Local))
+	start, end = snappedStart.In(start.Location()), snappedEnd.In(end.Location())
 
-	// 4. Description & Accounting
+	// 4. Reminders
+	reminders, err := TaskReminders(task, cfg)
+	if err != nil {
+		slog.Warn("ignoring invalid reminders", logging.Task(task.UUID), "error", err)
+		reminders = nil
+	}
+
+	// 5. Description & Accounting
 	var descBuilder strings.Builder
 
 	// Tags Header
@@ -286,13 +333,67 @@ func ConvertTaskToCalendarEvent(task *taskwarrior.Task) (*calendar.Event, error)
 			DateTime: end.UTC().Format(time.RFC3339),
 		},
 		Description: descBuilder.String(),
+		Reminders:   reminders,
+		ExtendedProperties: &calendar.EventExtendedProperties{
+			Private: map[string]string{
+				TaskIDProperty: task.UUID,
+			},
+		},
+	}
+
+	return event, nil
+}
+
+// ConvertTaskToDeadlineEvent renders the deadline marker of a task: a second
+// event at the due date of a task that is also scheduled. It returns nil when
+// markers are disabled or the task should not have one, for example because it
+// is no longer pending.
+func ConvertTaskToDeadlineEvent(task *taskwarrior.Task, cfg *config.Config) (*calendar.Event, error) {
+	if task == nil {
+		return nil, fmt.Errorf("could not convert nil Task")
+	}
+	if cfg == nil || !cfg.Deadlines.Enabled {
+		return nil, nil
+	}
+	if task.Status != "pending" {
+		return nil, nil
+	}
+	if task.Due == nil || task.Due.IsZero() || task.Scheduled == nil || task.Scheduled.IsZero() {
+		return nil, nil
+	}
+
+	colorID := cfg.Deadlines.ColorID
+	if colorID == "" {
+		colorID = defaultDeadlineColorID
+	}
+
+	event := &calendar.Event{
+		Summary:     fmt.Sprintf("⚑ Due: %s", task.Description),
+		ColorId:     colorID,
+		Description: fmt.Sprintf("Deadline of a scheduled task.\nUUID: %s\n", task.UUID),
 		ExtendedProperties: &calendar.EventExtendedProperties{
 			Private: map[string]string{
-				"taskwarrior_id": task.UUID,
+				TaskIDProperty: task.UUID,
+				RoleProperty:   index.RoleDeadline,
 			},
 		},
 	}
 
+	switch cfg.Deadlines.Style {
+	case "", config.DeadlineStylePoint:
+		due := task.Due.UTC().Format(time.RFC3339)
+		event.Start = &calendar.EventDateTime{DateTime: due}
+		event.End = &calendar.EventDateTime{DateTime: due}
+	case config.DeadlineStyleAllDay:
+		day := task.Due.Local()
+		event.Start = &calendar.EventDateTime{Date: day.Format(time.DateOnly)}
+		event.End = &calendar.EventDateTime{Date: day.AddDate(0, 0, 1).Format(time.DateOnly)}
+		// All-day markers shouldn't block the day in free/busy.
+		event.Transparency = transparencyVisible
+	default:
+		return nil, fmt.Errorf("unknown deadline marker style '%s'", cfg.Deadlines.Style)
+	}
+
 	return event, nil
 }
 
@@ -1,13 +1,29 @@
 package util
 
 import (
+	"os"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/harrisonrobin/taska/pkg/config"
 	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"google.golang.org/api/calendar/v3"
 )
 
+// TestMain keeps the colour cache, which event conversion loads from the state
+// store, out of the real home directory.
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "taska-util-test")
+	if err != nil {
+		panic(err)
+	}
+	os.Setenv("HOME", home)
+	code := m.Run()
+	os.RemoveAll(home)
+	os.Exit(code)
+}
+
 func TestConvertTaskToCalendarEvent(t *testing.T) {
 	deadline := time.Date(2023, 1, 1, 12, 0, 0, 0, time.UTC)
 	task := &taskwarrior.Task{
@@ -23,7 +39,7 @@ func TestConvertTaskToCalendarEvent(t *testing.T) {
 		Entry       *taskwarrior.CustomTime `json:"entry"`
 	}{Description: "Note 1"})
 
-	event, err := ConvertTaskToCalendarEvent(task)
+	event, err := ConvertTaskToCalendarEvent(task, nil)
 	if err != nil {
 		t.Fatalf("ConvertTaskToCalendarEvent failed: %v", err)
 	}
@@ -52,3 +68,175 @@ func TestConvertTaskToCalendarEvent(t *testing.T) {
 		t.Errorf("Expected description to contain 'Note 1', got: %s", event.Description)
 	}
 }
+
+func TestConvertTaskToCalendarEventDurationPolicy(t *testing.T) {
+	scheduled := time.Date(2023, 1, 1, 9, 10, 0, 0, time.UTC)
+	task := &taskwarrior.Task{
+		UUID:        "12345678-1234-1234-1234-123456789012",
+		Description: "Standup",
+		Status:      "pending",
+		Scheduled:   &taskwarrior.CustomTime{Time: scheduled},
+		Project:     "Meetings",
+	}
+	cfg := &config.Config{
+		Durations: config.DurationConfig{
+			Round: config.Duration{Duration: 15 * time.Minute},
+			Rules: []config.DurationRule{
+				{Selector: config.Selector{Project: "Meetings"}, Default: config.Duration{Duration: time.Hour}},
+			},
+		},
+	}
+
+	event, err := ConvertTaskToCalendarEvent(task, cfg)
+	if err != nil {
+		t.Fatalf("ConvertTaskToCalendarEvent failed: %v", err)
+	}
+	if event.Start.DateTime != "2023-01-01T09:00:00Z" {
+		t.Errorf("Expected start 2023-01-01T09:00:00Z, got %s", event.Start.DateTime)
+	}
+	if event.End.DateTime != "2023-01-01T10:15:00Z" {
+		t.Errorf("Expected end 2023-01-01T10:15:00Z, got %s", event.End.DateTime)
+	}
+}
+
+func TestConvertTaskToDeadlineEvent(t *testing.T) {
+	due := time.Date(2023, 1, 2, 17, 0, 0, 0, time.UTC)
+	task := &taskwarrior.Task{
+		UUID:        "12345678-1234-1234-1234-123456789012",
+		Description: "Report",
+		Status:      "pending",
+		Scheduled:   &taskwarrior.CustomTime{Time: due.Add(-24 * time.Hour)},
+		Due:         &taskwarrior.CustomTime{Time: due},
+	}
+
+	if event, _ := ConvertTaskToDeadlineEvent(task, nil); event != nil {
+		t.Errorf("Expected no marker when disabled, got %+v", event)
+	}
+
+	cfg := &config.Config{Deadlines: config.DeadlineConfig{Enabled: true}}
+	event, err := ConvertTaskToDeadlineEvent(task, cfg)
+	if err != nil {
+		t.Fatalf("ConvertTaskToDeadlineEvent failed: %v", err)
+	}
+	if event.Start.DateTime != "2023-01-02T17:00:00Z" || event.End.DateTime != event.Start.DateTime {
+		t.Errorf("Expected zero-length marker at due, got %s - %s", event.Start.DateTime, event.End.DateTime)
+	}
+	if event.ExtendedProperties.Private[RoleProperty] != "deadline" {
+		t.Errorf("Expected deadline role, got %v", event.ExtendedProperties.Private)
+	}
+
+	cfg.Deadlines.Style = config.DeadlineStyleAllDay
+	allDay, err := ConvertTaskToDeadlineEvent(task, cfg)
+	if err != nil {
+		t.Fatalf("ConvertTaskToDeadlineEvent failed: %v", err)
+	}
+	if allDay.Start.Date == "" || allDay.Start.DateTime != "" {
+		t.Errorf("Expected all-day marker, got %+v", allDay.Start)
+	}
+	patch, err := EventNeedsUpdate(task, event, allDay)
+	if err != nil {
+		t.Fatalf("EventNeedsUpdate failed: %v", err)
+	}
+	if patch == nil || patch.Start == nil {
+		t.Error("Expected switching marker style to patch the times")
+	}
+
+	task.Status = "completed"
+	if event, _ := ConvertTaskToDeadlineEvent(task, cfg); event != nil {
+		t.Errorf("Expected no marker for a completed task, got %+v", event)
+	}
+}
+
+func TestApplyPrivacyBusy(t *testing.T) {
+	task := &taskwarrior.Task{
+		UUID:        "12345678-1234-1234-1234-123456789012",
+		Description: "Therapy",
+		Status:      "pending",
+		Scheduled:   &taskwarrior.CustomTime{Time: time.Date(2023, 1, 1, 9, 0, 0, 0, time.UTC)},
+		Tags:        []string{"health"},
+	}
+	full, err := ConvertTaskToCalendarEvent(task, nil)
+	if err != nil {
+		t.Fatalf("ConvertTaskToCalendarEvent failed: %v", err)
+	}
+	busy, err := ConvertTaskToCalendarEvent(task, nil)
+	if err != nil {
+		t.Fatalf("ConvertTaskToCalendarEvent failed: %v", err)
+	}
+	ApplyPrivacy(busy, config.PrivacyBusy, "")
+
+	if busy.Summary != "Busy" || busy.Description != "" || busy.Visibility != "private" || busy.Transparency != "opaque" {
+		t.Errorf("Expected redacted busy event, got summary=%q description=%q visibility=%q transparency=%q",
+			busy.Summary, busy.Description, busy.Visibility, busy.Transparency)
+	}
+	if busy.ExtendedProperties.Private[TaskIDProperty] != task.UUID {
+		t.Error("Expected the task UUID to stay in the private extended property")
+	}
+
+	patch, err := EventNeedsUpdate(task, full, busy)
+	if err != nil {
+		t.Fatalf("EventNeedsUpdate failed: %v", err)
+	}
+	if patch == nil || patch.Visibility != "private" {
+		t.Fatalf("Expected a patch making the event private, got %+v", patch)
+	}
+	forced := false
+	for _, f := range patch.ForceSendFields {
+		if f == "Description" {
+			forced = true
+		}
+	}
+	if !forced {
+		t.Error("Expected the cleared description to be force-sent")
+	}
+}
+
+func TestEventHash(t *testing.T) {
+	due := time.Date(2026, 3, 1, 9, 0, 0, 0, time.UTC)
+	task := &taskwarrior.Task{UUID: "u1", Description: "Write report", Status: "pending", Due: &taskwarrior.CustomTime{Time: due}}
+
+	render := func() string {
+		event, err := ConvertTaskToCalendarEvent(task, nil)
+		if err != nil {
+			t.Fatalf("ConvertTaskToCalendarEvent failed: %v", err)
+		}
+		return EventHash(event)
+	}
+
+	a, b := render(), render()
+	if a == "" || a != b {
+		t.Fatalf("same task hashed to %q and %q", a, b)
+	}
+
+	task.Description = "Write the report"
+	if c := render(); c == a {
+		t.Error("changed description kept the same hash")
+	}
+}
+
+func TestEventNeedsUpdateClearsColor(t *testing.T) {
+	task := &taskwarrior.Task{UUID: "u1", Description: "Reopened", Status: taskwarrior.PENDING}
+	at := &calendar.EventDateTime{DateTime: "2026-03-02T09:00:00Z"}
+	existing := &calendar.Event{Summary: "Reopened", ColorId: "8", Start: at, End: at}
+	target := &calendar.Event{Summary: "Reopened", Start: at, End: at}
+
+	patch, err := EventNeedsUpdate(task, existing, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if patch == nil {
+		t.Fatal("expected a patch clearing the colour")
+	}
+	body, err := patch.MarshalJSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"colorId":null`) {
+		t.Errorf("patch does not clear the colour: %s", body)
+	}
+
+	existing.ColorId = ""
+	if patch, _ := EventNeedsUpdate(task, existing, target); patch != nil {
+		t.Errorf("no patch expected once the colour is cleared, got %+v", patch)
+	}
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/harrisonrobin/taska/pkg/calendars"
+	"github.com/harrisonrobin/taska/pkg/colors"
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/conflicts"
+	"github.com/harrisonrobin/taska/pkg/google"
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/scheduler"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+	"golang.org/x/term"
+	"google.golang.org/api/calendar/v3"
+)
+
+// runPurge implements `taska purge [--calendar X] [--filter F] [--detach]
+// [--dry-run] [--yes]`: it removes every event taska manages on a calendar,
+// or those of the tasks matching a Taskwarrior filter, and forgets them.
+func runPurge(args []string, calendarName string, cfg *config.Config) error {
+	fs := flag.NewFlagSet("purge", flag.ExitOnError)
+	fs.StringVar(&calendarName, "calendar", calendarName, "The calendar to purge")
+	filter := fs.String("filter", "", "Only purge the events of tasks matching this Taskwarrior filter, e.g. project:Foo")
+	detach := fs.Bool("detach", false, "Keep the events, but stop managing them")
+	dryRun := fs.Bool("dry-run", false, "Only show what would be purged")
+	yes := fs.Bool("yes", false, "Do not ask for confirmation")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: taska purge [--calendar name] [--filter filter] [--detach] [--dry-run] [--yes]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 {
+		fs.Usage()
+		return fmt.Errorf("unexpected arguments %q; pass a filter with --filter", fs.Args())
+	}
+
+	evtIndex, err := index.NewEventIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load event index: %w", err)
+	}
+	gClient, err := google.NewClient(calendarName, evtIndex, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open calendar '%s': %w", calendarName, err)
+	}
+	events, err := gClient.ListTaskEvents()
+	if err != nil {
+		return err
+	}
+	owners, err := eventOwners(events)
+	if err != nil {
+		return err
+	}
+
+	all := events
+	if *filter != "" {
+		matched, err := taskwarrior.NewClient().GetTasks(strings.Fields(*filter))
+		if err != nil {
+			return err
+		}
+		selected := make(map[string]bool, len(matched))
+		for _, t := range matched {
+			selected[t.UUID] = true
+		}
+		var kept []*calendar.Event
+		for _, e := range events {
+			if selected[google.EventTaskID(e)] {
+				kept = append(kept, e)
+			}
+		}
+		events = kept
+	}
+
+	verb := "Delete"
+	if *detach {
+		verb = "Detach"
+	}
+	if len(events) == 0 {
+		fmt.Printf("No taska events to purge on '%s'.\n", gClient.CalendarName())
+		return nil
+	}
+	printTaskEvents(os.Stdout, events, owners)
+	if *dryRun {
+		fmt.Printf("Dry run: would %s %d event(s) on '%s'.\n", strings.ToLower(verb), len(events), gClient.CalendarName())
+		return nil
+	}
+	if !*yes {
+		ok, err := confirm(fmt.Sprintf("%s %d event(s) on '%s'?", verb, len(events), gClient.CalendarName()))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Println("Nothing purged.")
+			return nil
+		}
+	}
+
+	errs := gClient.Purge(context.Background(), events, *detach)
+	purged := make(map[string]bool)
+	for _, e := range events {
+		if errs[e.Id] == nil {
+			purged[e.Id] = true
+		}
+	}
+	if err := forgetPurged(all, purged, owners, evtIndex); err != nil {
+		return err
+	}
+	fmt.Printf("Purged %d of %d event(s).\n", len(events)-len(errs), len(events))
+	if len(errs) > 0 {
+		return fmt.Errorf("%d event(s) could not be purged, see the log", len(errs))
+	}
+	return nil
+}
+
+// eventOwners exports the tasks the events belong to, by UUID. Tasks that no
+// longer exist are missing.
+func eventOwners(events []*calendar.Event) (map[string]taskwarrior.Task, error) {
+	var uuids []string
+	seen := make(map[string]bool)
+	for _, e := range events {
+		if uuid := google.EventTaskID(e); !seen[uuid] {
+			seen[uuid] = true
+			uuids = append(uuids, uuid)
+		}
+	}
+	owners := make(map[string]taskwarrior.Task, len(uuids))
+	twClient := taskwarrior.NewClient()
+	for start := 0; start < len(uuids); start += uuidBatchSize {
+		end := min(start+uuidBatchSize, len(uuids))
+		tasks, err := twClient.GetTasks(append([]string(nil), uuids[start:end]...))
+		if err != nil {
+			return nil, err
+		}
+		for _, t := range tasks {
+			owners[t.UUID] = t
+		}
+	}
+	return owners, nil
+}
+
+// printTaskEvents lists taska events with their tasks. Without owners the
+// PROJECT column is left empty.
+func printTaskEvents(out io.Writer, events []*calendar.Event, owners map[string]taskwarrior.Task) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "START\tSUMMARY\tTASK\tROLE\tPROJECT")
+	for _, e := range events {
+		uuid := google.EventTaskID(e)
+		project := owners[uuid].Project
+		if _, ok := owners[uuid]; !ok && owners != nil {
+			project = "(task gone)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", calendars.FormatStart(e), e.Summary, uuid, index.RoleName(google.EventRole(e)), project)
+	}
+	w.Flush()
+}
+
+// confirm asks a yes/no question on the terminal.
+func confirm(question string) (bool, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return false, fmt.Errorf("not a terminal; pass --yes to purge without confirmation")
+	}
+	fmt.Printf("%s [y/N] ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return false, err
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+// forgetPurged clears the purged events, by ID, from the sync state: the
+// event index, the schedule, their conflicts and the colours of the
+// projects left without events among all of the calendar's taska events.
+func forgetPurged(all []*calendar.Event, purged map[string]bool, owners map[string]taskwarrior.Task, evtIndex *index.EventIndex) error {
+	if err := evtIndex.Save(); err != nil {
+		return fmt.Errorf("failed to save event index: %w", err)
+	}
+
+	if sched, err := scheduler.New(); err == nil {
+		for _, e := range all {
+			if purged[e.Id] {
+				sched.Remove(google.EventTaskID(e))
+			}
+		}
+		if err := sched.Save(); err != nil {
+			return fmt.Errorf("failed to save the schedule: %w", err)
+		}
+	}
+
+	for _, e := range all {
+		if purged[e.Id] {
+			if err := conflicts.Delete(index.Key(google.EventTaskID(e), google.EventRole(e))); err != nil {
+				return err
+			}
+		}
+	}
+
+	// A project keeps its colour while any of its tasks is still on the calendar
+	kept := make(map[string]bool)
+	for _, e := range all {
+		if !purged[e.Id] {
+			kept[owners[google.EventTaskID(e)].Project] = true
+		}
+	}
+	cache, err := colors.NewColorCache()
+	if err != nil {
+		return err
+	}
+	for _, e := range all {
+		if project := owners[google.EventTaskID(e)].Project; purged[e.Id] && project != "" && !kept[project] {
+			cache.Remove(project)
+		}
+	}
+	return cache.Save()
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/auth"
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/conflicts"
+	"github.com/harrisonrobin/taska/pkg/google"
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/scheduler"
+	"github.com/harrisonrobin/taska/pkg/status"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+)
+
+// uuidBatchSize limits how many UUIDs are passed to a single `task export`.
+const uuidBatchSize = 100
+
+// runStatus implements `taska status [--json] [uuid|filter...]`.
+func runStatus(args []string, calendarName string, cfg *config.Config) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := fs.Bool("json", false, "Output JSON")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: taska status [--json] [uuid|filter...]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	filter := fs.Args()
+	// Without a filter every taska event on the calendar is accounted for, so
+	// events without a task can be reported as orphans.
+	fullScan := len(filter) == 0
+	if fullScan {
+		filter = []string{"status:pending"}
+	}
+
+	twClient := taskwarrior.NewClient()
+	tasks, err := twClient.GetTasks(filter)
+	if err != nil {
+		return err
+	}
+
+	evtIndex, err := index.NewEventIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load event index: %w", err)
+	}
+	gClient, err := google.NewClient(calendarName, evtIndex, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to open calendar '%s': %w", calendarName, err)
+	}
+	events, err := gClient.ListTaskEvents()
+	if err != nil {
+		return err
+	}
+
+	if fullScan {
+		// Completed tasks keep their events; look up the owners of events we
+		// haven't seen so they are classified instead of reported as orphans.
+		known := make(map[string]bool, len(tasks))
+		for _, t := range tasks {
+			known[t.UUID] = true
+		}
+		var unknown []string
+		for _, e := range events {
+			if uuid := google.EventTaskID(e); !known[uuid] {
+				known[uuid] = true
+				unknown = append(unknown, uuid)
+			}
+		}
+		for start := 0; start < len(unknown); start += uuidBatchSize {
+			end := min(start+uuidBatchSize, len(unknown))
+			owners, err := twClient.GetTasks(append([]string(nil), unknown[start:end]...))
+			if err != nil {
+				return err
+			}
+			tasks = append(tasks, owners...)
+		}
+	}
+
+	report := status.Report{
+		Calendar: gClient.CalendarName(),
+		Queued:   []status.QueuedOp{},
+		Tasks:    status.Classify(tasks, events, gClient.RenderTaskEvents, gClient.IndexEntry, fullScan),
+	}
+
+	if sched, err := scheduler.New(); err == nil {
+		if !sched.LastSync.IsZero() {
+			report.LastSync = &sched.LastSync
+		}
+		report.Queued = append(report.Queued, scheduledOps(sched, evtIndex, time.Now())...)
+	}
+	if list, err := conflicts.List(); err == nil {
+		for _, c := range list {
+			if c.Pending() {
+				report.Queued = append(report.Queued, status.QueuedOp{
+					UUID: c.TaskUUID, EventID: c.EventID, Op: status.OpResolveConflict, Due: c.DetectedAt,
+					Detail: "run taska conflicts",
+				})
+			}
+		}
+	}
+	if m, resuming, err := loadMigration(); err == nil && resuming {
+		report.Queued = append(report.Queued, status.QueuedOp{
+			Op: status.OpMigrate, Due: m.StartedAt,
+			Detail: fmt.Sprintf("from '%s' to '%s', run taska migrate to resume", m.From, m.To),
+		})
+	}
+	sort.Slice(report.Queued, func(i, j int) bool {
+		return report.Queued[i].Due.Before(report.Queued[j].Due)
+	})
+
+	if cfg.UsesServiceAccount() {
+		if email, err := auth.ServiceAccountEmail(cfg.ServiceAccount); err != nil {
+			report.TokenError = err.Error()
+		} else {
+			report.ServiceAccount = email
+		}
+	} else if tok, err := auth.LoadToken(cfg); err != nil {
+		report.TokenError = err.Error()
+	} else {
+		if !tok.Expiry.IsZero() {
+			report.TokenExpiry = &tok.Expiry
+		}
+		report.TokenRefresh = tok.RefreshToken != ""
+	}
+
+	if *asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	printStatus(os.Stdout, report)
+	return nil
+}
+
+// scheduledOps lists the overdue marks of the schedule. Entries whose moment
+// has passed wait for the next tick, having failed or not run yet.
+func scheduledOps(sched *scheduler.Scheduler, evtIndex *index.EventIndex, now time.Time) []status.QueuedOp {
+	var ops []status.QueuedOp
+	for uuid, e := range sched.Entries {
+		op := status.QueuedOp{UUID: uuid, EventID: evtIndex.Get(uuid), Op: status.OpMarkOverdue, Due: e.At}
+		if !e.At.After(now) {
+			op.Op = status.OpRetryOverdue
+		}
+		ops = append(ops, op)
+	}
+	return ops
+}
+
+func printStatus(out io.Writer, report status.Report) {
+	now := time.Now()
+	fmt.Fprintf(out, "Calendar:   %s\n", report.Calendar)
+	if report.LastSync != nil {
+		fmt.Fprintf(out, "Last sync:  %s (%s ago)\n", report.LastSync.Local().Format(time.DateTime), now.Sub(*report.LastSync).Round(time.Second))
+	} else {
+		fmt.Fprintln(out, "Last sync:  never")
+	}
+	switch {
+	case report.ServiceAccount != "":
+		fmt.Fprintf(out, "Token:      service account %s\n", report.ServiceAccount)
+	case report.TokenError != "":
+		fmt.Fprintf(out, "Token:      unavailable (%s)\n", report.TokenError)
+	case report.TokenExpiry != nil:
+		refresh := "not refreshable, run taska --auth"
+		if report.TokenRefresh {
+			refresh = "refreshable"
+		}
+		fmt.Fprintf(out, "Token:      access token expires %s, %s\n", report.TokenExpiry.Local().Format(time.DateTime), refresh)
+	default:
+		fmt.Fprintln(out, "Token:      no expiry recorded")
+	}
+
+	fmt.Fprintf(out, "Queued:     %d operation(s)\n", len(report.Queued))
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, op := range report.Queued {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", op.Op, op.UUID, op.Due.Local().Format(time.DateTime), op.Detail)
+	}
+	w.Flush()
+
+	fmt.Fprintln(out)
+	w = tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "STATE\tUUID\tDESCRIPTION\tDETAIL")
+	counts := make(map[string]int)
+	for _, t := range report.Tasks {
+		counts[t.State]++
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", t.State, t.UUID, t.Description, t.Detail)
+	}
+	w.Flush()
+
+	var summary []string
+	for _, state := range []string{status.Synced, status.Stale, status.MissingEvent, status.Orphan, status.NotSyncable} {
+		if counts[state] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[state], state))
+		}
+	}
+	if len(summary) > 0 {
+		fmt.Fprintf(out, "\n%s\n", strings.Join(summary, ", "))
+	}
+}
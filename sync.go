@@ -0,0 +1,100 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/colors"
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/google"
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/logging"
+	"github.com/harrisonrobin/taska/pkg/paths"
+	"github.com/harrisonrobin/taska/pkg/scheduler"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+)
+
+// runSync implements `taska sync`: it reconciles the calendars of the profile
+// with the pending tasks, catching up on the changes no hook run synced, then
+// runs the overdue sweep as `taska tick` does.
+func runSync(args []string, calendarName string, cfg *config.Config) error {
+	fs := flag.NewFlagSet("sync", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: taska sync")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	ctx := context.Background()
+	twClient := taskwarrior.NewClient()
+	pending, err := twClient.GetTasks([]string{"status:pending"})
+	if err != nil {
+		return err
+	}
+	evtIndex, err := index.NewEventIndex()
+	if err != nil {
+		return fmt.Errorf("failed to load event index: %w", err)
+	}
+	sched, err := scheduler.New()
+	if err != nil {
+		return fmt.Errorf("failed to load the schedule: %w", err)
+	}
+	colors.ReloadShared()
+	defer saveColors()
+
+	// Tasks routed to another profile are reconciled by that profile's run.
+	// The profile's calendar is always reconciled, as its indexed tasks may
+	// all have been completed or deleted.
+	calendars := []string{calendarName}
+	byCalendar := map[string][]taskwarrior.Task{calendarName: nil}
+	for i := range pending {
+		target := resolveTarget(cfg, &pending[i], calendarName, false)
+		if target.Profile != paths.Profile() {
+			continue
+		}
+		if _, ok := byCalendar[target.Calendar]; !ok {
+			calendars = append(calendars, target.Calendar)
+		}
+		byCalendar[target.Calendar] = append(byCalendar[target.Calendar], pending[i])
+	}
+
+	synced, failed := 0, 0
+	var syncErr error
+	for _, name := range calendars {
+		gClient, err := google.NewClient(name, evtIndex, cfg)
+		if err != nil {
+			syncErr = fmt.Errorf("failed to open calendar '%s': %w", name, err)
+			slog.Error("reconcile: could not open calendar", logging.Op("reconcile"), "calendar", name, "error", err)
+			continue
+		}
+		tasks, errs, err := gClient.Reconcile(ctx, byCalendar[name], twClient.GetTasks)
+		if err != nil {
+			syncErr = err
+			continue
+		}
+		for _, task := range tasks {
+			if errs[task.UUID] != nil {
+				failed++
+				continue
+			}
+			synced++
+			sched.Track(task, gClient.CalendarID(), time.Now())
+		}
+	}
+	if err := evtIndex.Save(); err != nil {
+		return fmt.Errorf("failed to save event index: %w", err)
+	}
+
+	refreshed, err := runSchedule(ctx, sched, scheduleClients(calendarName, nil, evtIndex, cfg), evtIndex)
+	fmt.Printf("Synced %d task(s), refreshed %d.\n", synced, refreshed)
+	if syncErr != nil {
+		return syncErr
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d task(s) failed to sync, see the log", failed)
+	}
+	return err
+}
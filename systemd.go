@@ -0,0 +1,146 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/paths"
+	"github.com/harrisonrobin/taska/pkg/systemd"
+)
+
+const systemdUsage = `Usage: taska systemd install [--interval 15m] [--daemon] [--no-enable]
+       taska systemd uninstall
+       taska systemd status`
+
+// runSystemd implements `taska systemd [install|uninstall|status]`, which
+// manage the systemd user units running `taska sync` on a timer or `taska
+// daemon` for the selected profile.
+func runSystemd(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%s", systemdUsage)
+	}
+	dir, err := systemd.UserDir()
+	if err != nil {
+		return err
+	}
+	switch args[0] {
+	case "install":
+		return installUnits(args[1:], dir)
+	case "uninstall":
+		return uninstallUnits(dir)
+	case "status":
+		return unitStatus(dir)
+	default:
+		return fmt.Errorf("unknown systemd command '%s'\n%s", args[0], systemdUsage)
+	}
+}
+
+// installUnits implements `taska systemd install`: it writes the sync service
+// and its timer, and with --daemon the daemon service, then enables and
+// starts them.
+func installUnits(args []string, dir string) error {
+	fs := flag.NewFlagSet("systemd install", flag.ExitOnError)
+	interval := fs.Duration("interval", 15*time.Minute, "How often the timer runs taska sync")
+	daemon := fs.Bool("daemon", false, "Also install and start taska-daemon.service")
+	noEnable := fs.Bool("no-enable", false, "Only write the unit files")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), systemdUsage)
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	self, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("could not find self: %w", err)
+	}
+	if resolved, err := filepath.EvalSymlinks(self); err == nil {
+		self = resolved
+	}
+	units, err := systemd.Render(systemd.Options{Executable: self, Profile: paths.Profile(), Interval: *interval})
+	if err != nil {
+		return err
+	}
+	service, timer, daemonService := systemd.Names(paths.Profile())
+	if !*daemon {
+		units = units[:2]
+	}
+	if err := systemd.Write(dir, units); err != nil {
+		return err
+	}
+	for _, u := range units {
+		fmt.Printf("Wrote %s\n", filepath.Join(dir, u.Name))
+	}
+	if *noEnable {
+		fmt.Printf("Enable them with: systemctl --user daemon-reload && systemctl --user enable --now %s\n", timer)
+		return nil
+	}
+
+	enable := []string{timer}
+	if *daemon {
+		enable = append(enable, daemonService)
+	}
+	if out, err := systemd.Systemctl("daemon-reload"); err != nil {
+		return fmt.Errorf("systemctl --user daemon-reload: %v: %s", err, out)
+	}
+	if out, err := systemd.Systemctl(append([]string{"enable", "--now"}, enable...)...); err != nil {
+		return fmt.Errorf("systemctl --user enable --now: %v: %s", err, out)
+	}
+	fmt.Printf("Enabled %v; %s runs every %s.\n", enable, service, *interval)
+	return nil
+}
+
+// uninstallUnits implements `taska systemd uninstall`: it stops and disables
+// the profile's units and removes their files.
+func uninstallUnits(dir string) error {
+	service, timer, daemon := systemd.Names(paths.Profile())
+	// Units that were never enabled make systemctl fail; that is fine
+	systemd.Systemctl("disable", "--now", timer, daemon)
+	systemd.Systemctl("stop", service)
+	if err := systemd.Remove(dir, service, timer, daemon); err != nil {
+		return err
+	}
+	if out, err := systemd.Systemctl("daemon-reload"); err != nil {
+		fmt.Fprintf(os.Stderr, "systemctl --user daemon-reload: %v: %s\n", err, out)
+	}
+	fmt.Printf("Removed %s, %s and %s from %s\n", service, timer, daemon, dir)
+	return nil
+}
+
+// unitStatus implements `taska systemd status`: whether each unit is
+// installed, enabled and active, and when the timer runs next.
+func unitStatus(dir string) error {
+	service, timer, daemon := systemd.Names(paths.Profile())
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "UNIT\tINSTALLED\tENABLED\tACTIVE")
+	for _, name := range []string{service, timer, daemon} {
+		installed := "no"
+		if _, err := os.Stat(filepath.Join(dir, name)); err == nil {
+			installed = "yes"
+		}
+		// is-enabled and is-active exit non-zero for "disabled", "inactive" etc.
+		enabled, _ := systemd.Systemctl("is-enabled", name)
+		active, _ := systemd.Systemctl("is-active", name)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", name, installed, orDash(enabled), orDash(active))
+	}
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	if next, err := systemd.Systemctl("show", "--property=NextElapseUSecRealtime", "--value", timer); err == nil && next != "" {
+		fmt.Printf("Next sync: %s\n", next)
+	}
+	return nil
+}
+
+// orDash returns a unit state printed by systemctl, or "-" for nothing or an
+// error message.
+func orDash(s string) string {
+	if s == "" || strings.ContainsAny(s, " \n") {
+		return "-"
+	}
+	return s
+}
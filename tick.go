@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/harrisonrobin/taska/pkg/colors"
+	"github.com/harrisonrobin/taska/pkg/config"
+	"github.com/harrisonrobin/taska/pkg/google"
+	"github.com/harrisonrobin/taska/pkg/index"
+	"github.com/harrisonrobin/taska/pkg/logging"
+	"github.com/harrisonrobin/taska/pkg/scheduler"
+	"github.com/harrisonrobin/taska/pkg/taskwarrior"
+)
+
+// runTick implements `taska tick`: it refreshes the events of the tasks whose
+// scheduled or due date has passed since they were last synced.
+func runTick(args []string, calendarName string, cfg *config.Config) error {
+	fs := flag.NewFlagSet("tick", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: taska tick")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	refreshed, err := tick(context.Background(), calendarName, cfg)
+	if refreshed > 0 {
+		fmt.Printf("Refreshed %d task(s).\n", refreshed)
+	}
+	return err
+}
+
+// runDaemon implements `taska daemon [--interval d]`: it ticks whenever an
+// entry of the schedule is due, and at least every interval to pick up the
+// tasks synced in the meantime, until interrupted.
+func runDaemon(args []string, calendarName string, cfg *config.Config) error {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	interval := fs.Duration("interval", time.Minute, "The longest time between two ticks")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: taska daemon [--interval 1m]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *interval <= 0 {
+		return fmt.Errorf("--interval must be positive")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	slog.Info("daemon started", logging.Op("tick"), "interval", interval.String())
+	for {
+		// Errors are logged and retried on the next tick
+		if _, err := tick(ctx, calendarName, cfg); err != nil {
+			slog.Error("tick failed", logging.Op("tick"), "error", err)
+		}
+		wait := *interval
+		if sched, err := scheduler.New(); err == nil {
+			if next, ok := sched.Next(); ok {
+				wait = min(wait, max(time.Until(next), time.Second))
+			}
+		}
+		select {
+		case <-ctx.Done():
+			slog.Info("daemon stopped", logging.Op("tick"))
+			return nil
+		case <-time.After(wait):
+		}
+	}
+}
+
+// tick runs the schedule's due entries once. The state is loaded afresh, as
+// hook runs update it in between.
+func tick(ctx context.Context, calendarName string, cfg *config.Config) (int, error) {
+	sched, err := scheduler.New()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load the schedule: %w", err)
+	}
+	if len(sched.Due(time.Now())) == 0 {
+		return 0, nil
+	}
+	evtIndex, err := index.NewEventIndex()
+	if err != nil {
+		return 0, fmt.Errorf("failed to load event index: %w", err)
+	}
+	// Hook runs assign colours in between, so the daemon rereads the cache
+	colors.ReloadShared()
+	defer saveColors()
+	return runSchedule(ctx, sched, scheduleClients(calendarName, nil, evtIndex, cfg), evtIndex)
+}
+
+// runSchedule runs the due entries and saves the schedule and the new ETags.
+func runSchedule(ctx context.Context, sched *scheduler.Scheduler, clientFor scheduler.ClientFor, evtIndex *index.EventIndex) (int, error) {
+	refreshed, err := sched.Run(ctx, time.Now(), clientFor, taskwarrior.NewClient().GetTasks)
+	if err == nil {
+		sched.MarkSynced(time.Now())
+	}
+	if evtIndex != nil && refreshed > 0 {
+		if err := evtIndex.Save(); err != nil {
+			return refreshed, fmt.Errorf("failed to save event index: %w", err)
+		}
+	}
+	if err := sched.Save(); err != nil {
+		return refreshed, fmt.Errorf("failed to save the schedule: %w", err)
+	}
+	return refreshed, err
+}
+
+// scheduleClients opens the client of each calendar in the schedule once,
+// sharing the event index. The client of the calendar already open, if any,
+// is reused; "" is calendarName.
+func scheduleClients(calendarName string, open *google.CalendarClient, evtIndex *index.EventIndex, cfg *config.Config) scheduler.ClientFor {
+	clients := make(map[string]*google.CalendarClient)
+	if open != nil {
+		clients[open.CalendarID()] = open
+	}
+	return func(calendarID string) (*google.CalendarClient, error) {
+		if c, ok := clients[calendarID]; ok {
+			return c, nil
+		}
+		name := calendarID
+		if name == "" {
+			name = calendarName
+		}
+		c, err := google.NewClient(name, evtIndex, cfg)
+		if err != nil {
+			return nil, err
+		}
+		clients[calendarID] = c
+		return c, nil
+	}
+}